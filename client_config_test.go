@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestGetConnectOptions(t *testing.T) {
+	useTestConfig(t)
+	key, url0 := AccessKey, BrokerURL
+	AccessKey, BrokerURL = "KuF3NT/jUBJ62LNBB/A8XZA9CqS3Cu79B/ABmfA1UCw=", "tcp://127.0.0.1:1883"
+	t.Cleanup(func() { AccessKey, BrokerURL = key, url0 })
+
+	opts, err := getConnectOptions("dev1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.ClientID != "dev1" || opts.Username != testProductID {
+		t.Errorf("client id/username = %q/%q", opts.ClientID, opts.Username)
+	}
+	if opts.AutoReconnect || opts.ConnectRetry {
+		t.Error("reconnect must be left to the connection loop")
+	}
+	q, err := url.ParseQuery(opts.Password)
+	if err != nil || q.Get("res") != "products/"+testProductID+"/devices/dev1" || q.Get("method") != AuthMethod {
+		t.Errorf("password = %q", opts.Password)
+	}
+
+	AccessKey = "###"
+	if _, err := getConnectOptions("dev1"); err == nil {
+		t.Error("invalid key: expected error")
+	}
+}
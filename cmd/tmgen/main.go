@@ -0,0 +1,442 @@
+// tmgen 读取 OneNET 物模型 JSON，生成类型化的设备结构体、标识符与 Topic 常量、
+// 校验函数以及属性 set/get 处理函数。物模型经 onenet/thingmodel 解析，生成的代码
+// 依赖同一个包的类型化取值层 (目标包中的 thingModelSpec、thingmodel.Date)。
+//
+// 配合 go generate 使用:
+//
+//	//go:generate go run ./cmd/tmgen -in thingmodel.json -out thingmodel_gen.go
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"qsiot_server/onenet/thingmodel"
+	"qsiot_server/onenet/topics"
+)
+
+// thingmodelImport 生成代码引用的物模型包
+const thingmodelImport = "qsiot_server/onenet/thingmodel"
+
+// ======================================================================
+// 代码生成
+// ======================================================================
+
+// topicDef 描述一个物模型 Topic 常量
+type topicDef struct {
+	name    string
+	suffix  string
+	comment string
+}
+
+var publishTopics = []topicDef{
+	{"PropertyPostTopicTemplate", topics.PropertyPost, "发布: 直连设备上报属性"},
+	{"EventPostTopicTemplate", topics.EventPost, "发布: 直连设备上报事件"},
+	{"PropertySetReplyTopicTemplate", topics.PropertySetReply, "发布: 直连设备属性设置响应"},
+	{"PropertyGetReplyTopicTemplate", topics.PropertyGetReply, "发布: 直连设备回复平台获取设备属性"},
+	{"PackPostTopicTemplate", topics.PackPost, "发布: 直连设备或子设备批量上报属性或事件"},
+}
+
+var subscribeTopics = []topicDef{
+	{"PropertyPostReplyTopicTemplate", topics.PropertyPostReply, "订阅: 直连设备上报属性响应"},
+	{"EventPostReplyTopicTemplate", topics.EventPostReply, "订阅: 直连设备上报事件响应"},
+	{"PackPostReplyTopicTemplate", topics.PackPostReply, "订阅: 平台回复\"设备批量上报属性或事件\""},
+	{"PropertySetTopicTemplate", topics.PropertySet, "订阅: 设置直连设备属性"},
+	{"PropertyGetTopicTemplate", topics.PropertyGet, "订阅: 平台获取直连设备的属性"},
+}
+
+type generator struct {
+	model   *thingmodel.Model
+	buf     bytes.Buffer
+	structs bytes.Buffer // 嵌套结构体类型定义
+	emitted map[string]bool
+	imports map[string]bool
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// goName 将物模型标识符转换为导出的 Go 标识符 ($OneNET_LBS -> OneNETLBS, cell_info -> CellInfo)
+func goName(identifier string) string {
+	parts := strings.FieldsFunc(identifier, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var b strings.Builder
+	for _, p := range parts {
+		b.WriteString(strings.ToUpper(p[:1]) + p[1:])
+	}
+	name := b.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "X" + name
+	}
+	return name
+}
+
+// goType 返回数据类型对应的 Go 类型，struct 类型会以 typeName 命名并登记待生成
+func (g *generator) goType(dt thingmodel.DataType, typeName string) (string, error) {
+	switch dt.Type {
+	case "int32", "enum":
+		return "int32", nil
+	case "int64":
+		return "int64", nil
+	case "float":
+		return "float32", nil
+	case "double":
+		return "float64", nil
+	case "bool":
+		return "bool", nil
+	case "string", "text":
+		return "string", nil
+	case "date":
+		// 毫秒时间戳，见 thingmodel.Date
+		g.imports[thingmodelImport] = true
+		return "thingmodel.Date", nil
+	case "struct":
+		if err := g.emitStruct(typeName, "", dt.Fields); err != nil {
+			return "", err
+		}
+		return typeName, nil
+	case "array":
+		elem, err := g.goType(*dt.Elem, typeName+"Item")
+		if err != nil {
+			return "", err
+		}
+		return "[]" + elem, nil
+	default:
+		return "", fmt.Errorf("%s: unsupported data type %q", typeName, dt.Type)
+	}
+}
+
+// emitStruct 生成结构体定义及其 validate 方法
+func (g *generator) emitStruct(typeName, doc string, fields []thingmodel.Field) error {
+	if g.emitted[typeName] {
+		return nil
+	}
+	g.emitted[typeName] = true
+
+	type fieldInfo struct {
+		thingmodel.Field
+		goName string
+		goType string
+	}
+	var infos []fieldInfo
+	for _, f := range fields {
+		t, err := g.goType(f.DataType, typeName+goName(f.Identifier))
+		if err != nil {
+			return err
+		}
+		infos = append(infos, fieldInfo{f, goName(f.Identifier), t})
+	}
+
+	w := &g.structs
+	if doc == "" {
+		doc = "物模型结构体类型"
+	}
+	fmt.Fprintf(w, "// %s %s\n", typeName, doc)
+	fmt.Fprintf(w, "type %s struct {\n", typeName)
+	for _, f := range infos {
+		fmt.Fprintf(w, "\t%s %s `json:%q` // %s\n", f.goName, f.goType, f.Identifier, f.Name)
+	}
+	fmt.Fprintf(w, "}\n\n")
+
+	fmt.Fprintf(w, "// validate 按物模型 specs 校验 %s 的各字段\n", typeName)
+	fmt.Fprintf(w, "func (v %s) validate() error {\n", typeName)
+	for _, f := range infos {
+		stmts, err := g.validateStmts("v."+f.goName, f.DataType, f.Identifier, 0)
+		if err != nil {
+			return err
+		}
+		w.WriteString(stmts)
+	}
+	fmt.Fprintf(w, "\treturn nil\n}\n\n")
+	return nil
+}
+
+// validateStmts 生成对表达式 expr 的校验语句，失败时 return error
+func (g *generator) validateStmts(expr string, dt thingmodel.DataType, path string, depth int) (string, error) {
+	var b strings.Builder
+	switch dt.Type {
+	case "int32", "int64", "float", "double":
+		var conds []string
+		if min := dt.Min; min != "" && !isTypeLimit(dt.Type, min) {
+			conds = append(conds, fmt.Sprintf("%s < %s", expr, min))
+		}
+		if max := dt.Max; max != "" && !isTypeLimit(dt.Type, max) {
+			conds = append(conds, fmt.Sprintf("%s > %s", expr, max))
+		}
+		if len(conds) > 0 {
+			g.imports["fmt"] = true
+			fmt.Fprintf(&b, "\tif %s {\n", strings.Join(conds, " || "))
+			fmt.Fprintf(&b, "\t\treturn fmt.Errorf(\"%s: value %%v out of range [%s, %s]\", %s)\n", path, dt.Min, dt.Max, expr)
+			fmt.Fprintf(&b, "\t}\n")
+		}
+	case "enum":
+		var keys []string
+		for k := range dt.Enum {
+			if _, err := strconv.ParseInt(k, 10, 32); err != nil {
+				return "", fmt.Errorf("%s: enum key %q is not an integer", path, k)
+			}
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		g.imports["fmt"] = true
+		fmt.Fprintf(&b, "\tswitch %s {\n\tcase %s:\n\tdefault:\n", expr, strings.Join(keys, ", "))
+		fmt.Fprintf(&b, "\t\treturn fmt.Errorf(\"%s: value %%v is not a valid enum\", %s)\n\t}\n", path, expr)
+	case "string", "text":
+		if dt.Length > 0 {
+			g.imports["fmt"] = true
+			g.imports["unicode/utf8"] = true
+			fmt.Fprintf(&b, "\tif utf8.RuneCountInString(%s) > %d {\n", expr, dt.Length)
+			fmt.Fprintf(&b, "\t\treturn fmt.Errorf(\"%s: text longer than %d\")\n\t}\n", path, dt.Length)
+		}
+	case "struct":
+		g.imports["fmt"] = true
+		fmt.Fprintf(&b, "\tif err := %s.validate(); err != nil {\n", expr)
+		fmt.Fprintf(&b, "\t\treturn fmt.Errorf(\"%s.%%w\", err)\n\t}\n", path)
+	case "array":
+		if dt.Length > 0 {
+			g.imports["fmt"] = true
+			fmt.Fprintf(&b, "\tif len(%s) != %d {\n", expr, dt.Length)
+			fmt.Fprintf(&b, "\t\treturn fmt.Errorf(\"%s: array has %%d elements, want %d\", len(%s))\n\t}\n", path, dt.Length, expr)
+		}
+		elem := fmt.Sprintf("e%d", depth)
+		inner, err := g.validateStmts(elem, *dt.Elem, path+"[]", depth+1)
+		if err != nil {
+			return "", err
+		}
+		if inner != "" {
+			fmt.Fprintf(&b, "\tfor _, %s := range %s {\n%s\t}\n", elem, expr, inner)
+		}
+	case "bool", "date":
+		// 无取值约束
+	default:
+		return "", fmt.Errorf("%s: unsupported data type %q", path, dt.Type)
+	}
+	return b.String(), nil
+}
+
+// isTypeLimit 判断 min/max 是否恰好是类型自身的取值边界 (此时无需生成比较)
+func isTypeLimit(typ, v string) bool {
+	switch typ {
+	case "int32":
+		return v == "-2147483648" || v == "2147483647"
+	case "int64":
+		return v == "-9223372036854775808" || v == "9223372036854775807"
+	}
+	return false
+}
+
+func (g *generator) generate(pkg, source string) ([]byte, error) {
+	m := g.model
+	pid := m.ProductID
+	if pid == "" {
+		return nil, fmt.Errorf("profile.productId is empty")
+	}
+
+	// --- 常量: 产品、属性与事件标识符 ---
+	g.printf("// ThingModelProductID 物模型所属产品 ID (Topic 模板中的占位产品)\n")
+	g.printf("const ThingModelProductID = %q\n\n", pid)
+
+	g.printf("// 属性标识符\nconst (\n")
+	for _, p := range m.Properties {
+		g.printf("\tProp%s = %q // %s\n", goName(p.Identifier), p.Identifier, p.Name)
+	}
+	g.printf(")\n\n")
+
+	if len(m.Events) > 0 {
+		g.printf("// 事件标识符\nconst (\n")
+		for _, e := range m.Events {
+			g.printf("\tEvent%s = %q // %s\n", goName(e.Identifier), e.Identifier, e.Name)
+		}
+		g.printf(")\n\n")
+	}
+
+	if len(m.Services) > 0 {
+		g.printf("// 服务标识符\nconst (\n")
+		for _, s := range m.Services {
+			g.printf("\tService%s = %q // %s\n", goName(s.Identifier), s.Identifier, s.Name)
+		}
+		g.printf(")\n\n")
+	}
+
+	// --- Topic 模板 ---
+	g.printf("// ======================================================================\n")
+	g.printf("// Topic 模板定义\n")
+	g.printf("// ======================================================================\n")
+	g.printf("const (\n")
+	g.printf("\t// 🚀 发布 (设备 -> 云端)\n")
+	for _, t := range publishTopics {
+		g.printf("\t%s = \"$sys/%s/{device-name}/%s\" // %s\n", t.name, pid, t.suffix, t.comment)
+	}
+	g.printf("\n\t// ⬇️ 订阅 (云端 -> 设备)\n")
+	for _, t := range subscribeTopics {
+		g.printf("\t%s = \"$sys/%s/{device-name}/%s\" // %s\n", t.name, pid, t.suffix, t.comment)
+	}
+	for _, s := range m.Services {
+		n := goName(s.Identifier)
+		g.printf("\tService%sInvokeTopicTemplate = \"$sys/%s/{device-name}/%s\" // 订阅: 平台调用服务 %s\n", n, pid, topics.ServiceInvoke(s.Identifier), s.Name)
+		g.printf("\tService%sInvokeReplyTopicTemplate = \"$sys/%s/{device-name}/%s\" // 发布: 回复服务调用 %s\n", n, pid, topics.ServiceInvokeReply(s.Identifier), s.Name)
+	}
+	g.printf(")\n\n")
+
+	// --- 属性集合结构体 ---
+	type propInfo struct {
+		*thingmodel.Property
+		goName string
+		goType string
+	}
+	var props []propInfo
+	for _, p := range m.Properties {
+		n := goName(p.Identifier)
+		t, err := g.goType(p.DataType, n)
+		if err != nil {
+			return nil, err
+		}
+		props = append(props, propInfo{p, n, t})
+	}
+
+	g.printf("// ThingProperties 物模型属性集合 (对应物模型 properties)\n")
+	g.printf("type ThingProperties struct {\n")
+	for _, p := range props {
+		g.printf("\t%s %s `json:%q` // %s (%s)\n", p.goName, p.goType, p.Identifier, p.Name, p.AccessMode)
+	}
+	g.printf("}\n\n")
+
+	// --- 事件结构体 ---
+	for _, e := range m.Events {
+		typeName := goName(e.Identifier) + "Event"
+		if err := g.emitStruct(typeName, fmt.Sprintf("事件 %s (%s) 的输出参数", e.Identifier, e.Name), e.OutputData); err != nil {
+			return nil, err
+		}
+	}
+
+	// --- 属性校验函数 ---
+	for _, p := range props {
+		stmts, err := g.validateStmts("v", p.DataType, p.Identifier, 0)
+		if err != nil {
+			return nil, err
+		}
+		g.printf("// validate%s 校验属性 %s (%s)\n", p.goName, p.Identifier, p.Name)
+		g.printf("func validate%s(v %s) error {\n%s\treturn nil\n}\n\n", p.goName, p.goType, stmts)
+	}
+
+	// --- 标识符列表 ---
+	g.printf("// thingPropertyIDs 全部属性标识符 (物模型顺序)\n")
+	g.printf("var thingPropertyIDs = []string{\n")
+	for _, p := range props {
+		g.printf("\tProp%s,\n", p.goName)
+	}
+	g.printf("}\n\n")
+
+	g.printf("// writablePropertyIDs 可写 (rw) 属性标识符\n")
+	g.printf("var writablePropertyIDs = []string{\n")
+	for _, p := range props {
+		if strings.Contains(p.AccessMode, "w") {
+			g.printf("\tProp%s,\n", p.goName)
+		}
+	}
+	g.printf("}\n\n")
+
+	// --- set 处理 ---
+	g.imports["fmt"] = true
+	g.printf("// setProperty 按标识符写入属性值，raw 为 property/set 中 JSON 解码后的值\n")
+	g.printf("func (p *ThingProperties) setProperty(id string, raw interface{}) error {\n")
+	g.printf("\tswitch id {\n")
+	var readOnly []string
+	for _, p := range props {
+		if !strings.Contains(p.AccessMode, "w") {
+			readOnly = append(readOnly, "Prop"+p.goName)
+			continue
+		}
+		g.printf("\tcase Prop%s:\n", p.goName)
+		g.printf("\t\tvar v %s\n", p.goType)
+		g.printf("\t\tif err := thingModelSpec.DecodeProperty(id, raw, &v); err != nil {\n\t\t\treturn err\n\t\t}\n")
+		g.printf("\t\tif err := validate%s(v); err != nil {\n\t\t\treturn err\n\t\t}\n", p.goName)
+		g.printf("\t\tp.%s = v\n", p.goName)
+	}
+	if len(readOnly) > 0 {
+		g.printf("\tcase %s:\n", strings.Join(readOnly, ", "))
+		g.printf("\t\treturn fmt.Errorf(\"property %%s is read-only\", id)\n")
+	}
+	g.printf("\tdefault:\n\t\treturn fmt.Errorf(\"unknown property: %%s\", id)\n")
+	g.printf("\t}\n\treturn nil\n}\n\n")
+
+	// --- get 处理 ---
+	g.printf("// getProperty 按标识符读取属性值\n")
+	g.printf("func (p *ThingProperties) getProperty(id string) (interface{}, bool) {\n")
+	g.printf("\tswitch id {\n")
+	for _, p := range props {
+		g.printf("\tcase Prop%s:\n\t\treturn p.%s, true\n", p.goName, p.goName)
+	}
+	g.printf("\t}\n\treturn nil, false\n}\n\n")
+
+	// --- 事件参数 ---
+	for _, e := range m.Events {
+		typeName := goName(e.Identifier) + "Event"
+		g.printf("// params 返回事件 %s 的参数表 (标识符 -> 值)\n", e.Identifier)
+		g.printf("func (e %s) params() map[string]interface{} {\n", typeName)
+		g.printf("\treturn map[string]interface{}{\n")
+		for _, f := range e.OutputData {
+			g.printf("\t\t%q: e.%s,\n", f.Identifier, goName(f.Identifier))
+		}
+		g.printf("\t}\n}\n\n")
+	}
+
+	// --- 组装文件 ---
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by tmgen from %s; DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(&out, "package %s\n\n", pkg)
+	if len(g.imports) > 0 {
+		var imps []string
+		for imp := range g.imports {
+			imps = append(imps, imp)
+		}
+		sort.Strings(imps)
+		out.WriteString("import (\n")
+		for _, imp := range imps {
+			fmt.Fprintf(&out, "\t%q\n", imp)
+		}
+		out.WriteString(")\n\n")
+	}
+	out.Write(g.buf.Bytes())
+	out.WriteString("\n")
+	out.Write(g.structs.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return out.Bytes(), fmt.Errorf("format generated source: %w", err)
+	}
+	return src, nil
+}
+
+func main() {
+	in := flag.String("in", "thingmodel.json", "物模型 JSON 文件")
+	out := flag.String("out", "thingmodel_gen.go", "生成的 Go 文件")
+	pkg := flag.String("pkg", "main", "生成文件的包名")
+	flag.Parse()
+
+	m, err := thingmodel.Load(*in)
+	if err != nil {
+		log.Fatalf("读取物模型失败: %v", err)
+	}
+
+	g := &generator{model: m, emitted: map[string]bool{}, imports: map[string]bool{}}
+	src, err := g.generate(*pkg, filepath.Base(*in))
+	if err != nil {
+		log.Fatalf("生成代码失败: %v", err)
+	}
+	if err := os.WriteFile(*out, src, 0o644); err != nil {
+		log.Fatalf("写入 %s 失败: %v", *out, err)
+	}
+	log.Printf("已生成 %s (%d 个属性, %d 个事件, %d 个服务)", *out, len(m.Properties), len(m.Events), len(m.Services))
+}
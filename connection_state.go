@@ -0,0 +1,424 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"qsiot_server/onenet/device"
+)
+
+// ======================================================================
+// 连接状态机与重连策略
+//
+//   connecting --连接并订阅成功--> online --连接丢失--> offline --退避后--> connecting
+//   任意状态 --收到停止信号--> stopped
+//   connecting --初次连接次数耗尽 / 连续订阅失败 / 配置错误--> failed
+//
+// 初次连接与断线重连使用同一套指数退避 + 抖动策略 (不依赖 paho 的自动重连)，
+// 状态变化通过 SubscribeStateEvents 广播给其他组件 (仪表盘、断言等)。
+// ======================================================================
+
+// ConnState 设备连接状态
+type ConnState string
+
+const (
+	StateConnecting ConnState = "connecting"
+	StateOnline     ConnState = "online"
+	StateOffline    ConnState = "offline"
+	StateStopped    ConnState = "stopped"
+	StateFailed     ConnState = "failed" // 单台设备放弃运行，其余设备不受影响
+)
+
+// 订阅重试配置 (可通过命令行参数覆盖)
+var (
+	SubscribeRetries       = 3               // 单个 Topic 订阅失败后的重试次数
+	SubscribeRetryInterval = 1 * time.Second // 订阅重试间隔
+	SubscribeMaxFailures   = 3               // 连续多少次 "连接成功但订阅失败" 后标记为 failed
+)
+
+// subscribeError 连接建立后订阅失败 (由连接循环断开并重连)
+type subscribeError struct {
+	device string
+	failed []string
+}
+
+func (e *subscribeError) Error() string {
+	return fmt.Sprintf("subscribe failed: %s", strings.Join(e.failed, ", "))
+}
+
+// StateEvent 一次状态迁移
+type StateEvent struct {
+	Device  string
+	From    ConnState
+	To      ConnState
+	Time    time.Time
+	Attempt int   // 进入 connecting 时为第几次尝试 (从 1 开始)
+	Err     error // 导致迁移的错误 (连接丢失/连接失败)
+}
+
+func (e StateEvent) String() string {
+	s := fmt.Sprintf("%s: %s -> %s", e.Device, e.From, e.To)
+	if e.Attempt > 0 {
+		s += fmt.Sprintf(" (attempt %d)", e.Attempt)
+	}
+	if e.Err != nil {
+		s += fmt.Sprintf(": %v", e.Err)
+	}
+	return s
+}
+
+// ReconnectPolicy 连接重试策略
+type ReconnectPolicy struct {
+	InitialInterval time.Duration // 首次重试前的等待
+	MaxInterval     time.Duration // 等待时长上限
+	Multiplier      float64       // 每次失败后的放大倍数
+	Jitter          float64       // 随机抖动比例 (0~1)，等待时长在 ±Jitter 范围内浮动
+	MaxAttempts     int           // 初次连接的最大尝试次数，0 表示不限 (断线重连始终不限)
+}
+
+// reconnectPolicy 全局重连策略 (可通过命令行参数覆盖)
+var reconnectPolicy = ReconnectPolicy{
+	InitialInterval: 1 * time.Second,
+	MaxInterval:     2 * time.Minute,
+	Multiplier:      2,
+	Jitter:          0.2,
+}
+
+// Backoff 返回第 attempt 次失败 (从 1 开始) 后的等待时长
+func (p ReconnectPolicy) Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 1
+	}
+	d := float64(p.InitialInterval) * math.Pow(mult, float64(attempt-1))
+	if p.MaxInterval > 0 && d > float64(p.MaxInterval) {
+		d = float64(p.MaxInterval)
+	}
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	if d < 0 {
+		d = 0
+	}
+	return time.Duration(d)
+}
+
+// ======================================================================
+// 状态广播
+// ======================================================================
+
+type stateBroadcaster struct {
+	mu   sync.Mutex
+	subs map[chan StateEvent]struct{}
+}
+
+var stateEvents = &stateBroadcaster{subs: make(map[chan StateEvent]struct{})}
+
+// SubscribeStateEvents 订阅所有设备的状态迁移；订阅者处理过慢时事件会被丢弃。
+// 返回的 cancel 用于取消订阅并关闭通道。
+func SubscribeStateEvents(buffer int) (<-chan StateEvent, func()) {
+	ch := make(chan StateEvent, buffer)
+	stateEvents.mu.Lock()
+	stateEvents.subs[ch] = struct{}{}
+	stateEvents.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			stateEvents.mu.Lock()
+			delete(stateEvents.subs, ch)
+			stateEvents.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (b *stateBroadcaster) publish(ev StateEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// ======================================================================
+// 设备注册表
+// ======================================================================
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]*Device)
+)
+
+// registerDevice 登记运行中的设备 (供 shell、仪表盘等组件查询)
+func registerDevice(d *Device) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[d.Name] = d
+}
+
+// lookupDevice 按名称查找设备
+func lookupDevice(name string) (*Device, bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	d, ok := registry[name]
+	return d, ok
+}
+
+// registeredDevices 返回按名称排序的所有设备
+func registeredDevices() []*Device {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	list := make([]*Device, 0, len(registry))
+	for _, d := range registry {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// ======================================================================
+// Device: 状态与连接循环
+// ======================================================================
+
+// errManualDisconnect 手动断开 (shell 等) 导致的离线
+var errManualDisconnect = errors.New("disconnected manually")
+
+// Disconnect 手动断开设备连接，直到调用 Connect 前不会自动重连
+func (d *Device) Disconnect() { d.sendConnCtl(false) }
+
+// Connect 恢复被手动断开的设备连接
+func (d *Device) Connect() { d.sendConnCtl(true) }
+
+func (d *Device) sendConnCtl(on bool) {
+	select {
+	case d.connCtl <- on:
+	default:
+		// 丢弃尚未处理的旧指令，以最新指令为准
+		select {
+		case <-d.connCtl:
+		default:
+		}
+		select {
+		case d.connCtl <- on:
+		default:
+		}
+	}
+}
+
+// State 返回设备当前连接状态
+func (d *Device) State() ConnState {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	return d.state
+}
+
+// connEpoch 返回当前状态与累计上线次数，两者都不变说明仍是同一个连接
+func (d *Device) connEpoch() (ConnState, int) {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	return d.state, d.onlineGen
+}
+
+// setState 迁移到新状态并广播 (状态未变化时忽略)
+func (d *Device) setState(to ConnState, attempt int, err error) {
+	d.stateMu.Lock()
+	from := d.state
+	if from == to || from == StateStopped || from == StateFailed {
+		d.stateMu.Unlock()
+		return
+	}
+	d.state = to
+	if to == StateOnline {
+		d.onlineGen++
+	}
+	if to == StateStopped || to == StateFailed {
+		close(d.done)
+	}
+	d.stateMu.Unlock()
+
+	ev := StateEvent{Device: d.Name, From: from, To: to, Time: time.Now(), Attempt: attempt, Err: err}
+	if err != nil {
+		d.Stats.noteError(fmt.Sprintf("%s: %v", to, err))
+	}
+	log.Printf("[%s] 🔁 状态: %s", d.Name, ev)
+	stateEvents.publish(ev)
+}
+
+// deviceConn 连接循环管理的设备连接 (MQTT 3.1.1 的 paho 客户端或 MQTT 5 Transport)
+type deviceConn interface {
+	connect() error
+	disconnect()
+}
+
+// pahoConn 以 deviceConn 形式使用 paho (MQTT 3.1.1) 客户端
+type pahoConn struct {
+	client mqtt.Client
+}
+
+func (c pahoConn) connect() error {
+	token := c.client.Connect()
+	token.Wait()
+	return token.Error()
+}
+
+// disconnect 允许 250ms 完成正在发送/接收的数据包
+func (c pahoConn) disconnect() { c.client.Disconnect(250) }
+
+// connectWithRetry 按策略反复连接直到成功；stop 关闭或 maxAttempts 耗尽时返回错误
+func (d *Device) connectWithRetry(conn deviceConn, policy ReconnectPolicy, maxAttempts int, stop <-chan struct{}) error {
+	for attempt := 1; ; attempt++ {
+		d.setState(StateConnecting, attempt, nil)
+		err := conn.connect()
+		if err == nil {
+			return nil
+		}
+		if maxAttempts > 0 && attempt >= maxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		wait := policy.Backoff(attempt)
+		d.setState(StateOffline, 0, err)
+		log.Printf("[%s] 连接失败 (第 %d 次): %v, %v 后重试", d.Name, attempt, err, wait.Round(time.Millisecond))
+		select {
+		case <-stop:
+			return fmt.Errorf("stopped")
+		case <-time.After(wait):
+		}
+	}
+}
+
+// waitConnect 手动断开后等待 Connect 指令；stop 关闭时返回 false
+func (d *Device) waitConnect(stop <-chan struct{}) bool {
+	for {
+		select {
+		case <-stop:
+			return false
+		case on := <-d.connCtl:
+			if on {
+				return true
+			}
+		}
+	}
+}
+
+// subscribeWithRetry 调用 subscribe 订阅单个 Topic，失败时最多重试 SubscribeRetries 次
+func (d *Device) subscribeWithRetry(topic string, subscribe func() error) error {
+	var err error
+	for i := 0; i <= SubscribeRetries; i++ {
+		if i > 0 {
+			log.Printf("[%s] 订阅 %s 失败: %v, %v 后重试 (%d/%d)", d.Name, topic, err, SubscribeRetryInterval, i, SubscribeRetries)
+			select {
+			case <-d.done:
+				return err
+			case <-time.After(SubscribeRetryInterval):
+			}
+		}
+		if err = subscribe(); err == nil || errors.Is(err, device.ErrNotConnected) {
+			return err // 连接已断开时不再重试，由连接循环重连
+		}
+	}
+	return err
+}
+
+// onConnected 连接建立后的初始化: 订阅失败时交给连接循环处理，成功后进入 online 并启动模拟
+func (d *Device) onConnected(lost chan<- error) {
+	if d.enterOnline(lost) {
+		go d.startDeviceSimulation()
+	}
+}
+
+// enterOnline 订阅命令 Topic，成功后进入 online；失败时通知连接循环并返回 false
+func (d *Device) enterOnline(lost chan<- error) bool {
+	if err := d.subscribeForCommands(); err != nil {
+		log.Printf("[%s] 命令订阅失败: %v", d.Name, err)
+		select {
+		case lost <- err:
+		default:
+		}
+		return false
+	}
+	d.setState(StateOnline, 0, nil)
+	return true
+}
+
+// runConnection 维持设备连接: 初次连接 (受 MaxAttempts 限制)，
+// 之后每次连接丢失都按同一策略重连，直到 stop 关闭；
+// 连续 SubscribeMaxFailures 次订阅失败时标记为 failed
+func (d *Device) runConnection(conn deviceConn, lost <-chan error, stop <-chan struct{}) {
+	maxAttempts := reconnectPolicy.MaxAttempts
+	subFailures := 0
+	for {
+		if err := d.connectWithRetry(conn, reconnectPolicy, maxAttempts, stop); err != nil {
+			select {
+			case <-stop:
+				d.setState(StateStopped, 0, nil)
+			default:
+				log.Printf("[%s] 连接 Broker 失败: %v. 设备退出。", d.Name, err)
+				d.setState(StateFailed, 0, err)
+			}
+			return
+		}
+		maxAttempts = 0 // 断线重连不限次数
+
+		// 在线期间等待: 停止信号、手动断开或连接丢失
+	online:
+		for {
+			select {
+			case <-stop:
+				log.Printf("[%s] 正在断开 MQTT 连接...", d.Name)
+				conn.disconnect()
+				log.Printf("[%s] MQTT 连接已断开。", d.Name)
+				d.setState(StateStopped, 0, nil)
+				return
+			case on := <-d.connCtl:
+				if on {
+					continue // 已在线，忽略重复的连接指令
+				}
+				conn.disconnect()
+				d.setState(StateOffline, 0, errManualDisconnect)
+				if !d.waitConnect(stop) {
+					d.setState(StateStopped, 0, nil)
+					return
+				}
+				break online
+			case err := <-lost:
+				var subErr *subscribeError
+				if errors.As(err, &subErr) {
+					conn.disconnect()
+					subFailures++
+					if SubscribeMaxFailures > 0 && subFailures >= SubscribeMaxFailures {
+						log.Printf("[%s] 连续 %d 次订阅失败，设备标记为 failed", d.Name, subFailures)
+						d.setState(StateFailed, 0, err)
+						return
+					}
+				} else {
+					subFailures = 0
+				}
+				d.setState(StateOffline, 0, err)
+				wait := reconnectPolicy.Backoff(subFailures + 1)
+				select {
+				case <-stop:
+					d.setState(StateStopped, 0, nil)
+					return
+				case <-time.After(wait):
+				}
+				break online
+			}
+		}
+	}
+}
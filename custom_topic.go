@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"qsiot_server/onenet/device"
+	"qsiot_server/onenet/topics"
+)
+
+// ======================================================================
+// 自定义 Topic 与透传 (raw) 数据
+//
+// 非物模型设备直接收发二进制载荷。应用数据经 PayloadCodec 编码后发布，
+// 收到的载荷经同一 Codec 解码后交给 RawMessageHandler。
+// 自定义 Topic 模板支持 {product-id} 与 {device-name} 占位符。
+// ======================================================================
+
+const (
+	RawUpTopicTemplate   = topics.Prefix + topics.RawUp   // 发布: 透传数据上行
+	RawDownTopicTemplate = topics.Prefix + topics.RawDown // 订阅: 透传数据下行
+)
+
+// 透传模拟配置 (可通过命令行参数覆盖)
+var (
+	RawUpTopic    = ""         // 上行 Topic 模板，为空时不周期上报透传数据
+	RawDownTopic  = ""         // 下行 Topic 模板，为空时不订阅
+	RawCodecSpec  = "hex"      // 编解码: raw | hex | tlv | script:<命令>
+	RawPayload    = "01020304" // 周期上报的应用数据 (按 Codec 的文本格式书写)
+	RawInterval   = 10 * time.Second
+	RawEcho       = false // 收到下行数据后原样回传到上行 Topic
+	ThingModelOff = false // 关闭物模型上报，仅模拟透传设备
+)
+
+// PayloadCodec 载荷编解码钩子：应用数据 <-> 线上字节
+type PayloadCodec interface {
+	Name() string
+	Encode(data []byte) ([]byte, error)
+	Decode(wire []byte) ([]byte, error)
+}
+
+// RawMessageHandler 处理解码后的自定义 Topic 下行数据
+type RawMessageHandler func(d *Device, topic string, data []byte)
+
+// rawSubscription 记录自定义订阅，重连后重新订阅
+type rawSubscription struct {
+	template string
+	codec    PayloadCodec
+	handler  RawMessageHandler
+}
+
+// newPayloadCodec 按名称创建 Codec
+func newPayloadCodec(spec string) (PayloadCodec, error) {
+	switch {
+	case spec == "" || spec == "raw":
+		return rawCodec{}, nil
+	case spec == "hex":
+		return hexCodec{}, nil
+	case spec == "tlv":
+		return tlvCodec{}, nil
+	case strings.HasPrefix(spec, "script:"):
+		cmd := strings.TrimSpace(strings.TrimPrefix(spec, "script:"))
+		if cmd == "" {
+			return nil, fmt.Errorf("script codec requires a command")
+		}
+		return scriptCodec{command: cmd}, nil
+	}
+	return nil, fmt.Errorf("unknown codec: %s", spec)
+}
+
+// rawCodec 原样透传
+type rawCodec struct{}
+
+func (rawCodec) Name() string                       { return "raw" }
+func (rawCodec) Encode(data []byte) ([]byte, error) { return data, nil }
+func (rawCodec) Decode(wire []byte) ([]byte, error) { return wire, nil }
+
+// hexCodec 应用数据为十六进制文本 (如 "01 0a ff")，线上为对应字节
+type hexCodec struct{}
+
+func (hexCodec) Name() string { return "hex" }
+
+func (hexCodec) Encode(data []byte) ([]byte, error) {
+	s := strings.Map(func(r rune) rune {
+		if r == ' ' || r == ':' || r == '-' || r == '\n' {
+			return -1
+		}
+		return r
+	}, string(data))
+	return hex.DecodeString(s)
+}
+
+func (hexCodec) Decode(wire []byte) ([]byte, error) {
+	return []byte(hex.EncodeToString(wire)), nil
+}
+
+// tlvCodec 应用数据为 "tag:hexvalue,tag:hexvalue" 文本，
+// 线上格式为 1 字节 Tag + 2 字节大端 Length + Value
+type tlvCodec struct{}
+
+func (tlvCodec) Name() string { return "tlv" }
+
+func (tlvCodec) Encode(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, item := range strings.Split(strings.TrimSpace(string(data)), ",") {
+		if item == "" {
+			continue
+		}
+		tagStr, valStr, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok {
+			return nil, fmt.Errorf("tlv item %q: want tag:hexvalue", item)
+		}
+		tag, err := strconv.ParseUint(tagStr, 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("tlv tag %q: %w", tagStr, err)
+		}
+		val, err := hex.DecodeString(valStr)
+		if err != nil {
+			return nil, fmt.Errorf("tlv value %q: %w", valStr, err)
+		}
+		if len(val) > 0xFFFF {
+			return nil, fmt.Errorf("tlv value for tag %d too long", tag)
+		}
+		buf.WriteByte(byte(tag))
+		binary.Write(&buf, binary.BigEndian, uint16(len(val)))
+		buf.Write(val)
+	}
+	return buf.Bytes(), nil
+}
+
+func (tlvCodec) Decode(wire []byte) ([]byte, error) {
+	var items []string
+	for len(wire) > 0 {
+		if len(wire) < 3 {
+			return nil, fmt.Errorf("tlv truncated header")
+		}
+		tag, n := wire[0], int(binary.BigEndian.Uint16(wire[1:3]))
+		if len(wire) < 3+n {
+			return nil, fmt.Errorf("tlv tag %d: truncated value", tag)
+		}
+		items = append(items, fmt.Sprintf("%d:%s", tag, hex.EncodeToString(wire[3:3+n])))
+		wire = wire[3+n:]
+	}
+	return []byte(strings.Join(items, ",")), nil
+}
+
+// scriptCodec 调用外部脚本完成编解码：
+// 执行 `sh -c "<command> encode|decode"`，数据经 stdin 传入、从 stdout 读出
+type scriptCodec struct {
+	command string
+}
+
+func (c scriptCodec) Name() string { return "script" }
+
+func (c scriptCodec) Encode(data []byte) ([]byte, error) { return c.run("encode", data) }
+func (c scriptCodec) Decode(wire []byte) ([]byte, error) { return c.run("decode", wire) }
+
+func (c scriptCodec) run(op string, in []byte) ([]byte, error) {
+	cmd := exec.Command("sh", "-c", c.command+" "+op)
+	cmd.Stdin = bytes.NewReader(in)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("script %s failed: %w (%s)", op, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// ======================================================================
+// Device: 自定义 Topic 收发
+// ======================================================================
+
+// PublishRaw 将应用数据经 Codec 编码后发布到自定义 Topic
+func (d *Device) PublishRaw(template string, data []byte, codec PayloadCodec) error {
+	wire, err := codec.Encode(data)
+	if err != nil {
+		return fmt.Errorf("encode (%s): %w", codec.Name(), err)
+	}
+	topic := getTopic(d.Name, template)
+	if err := d.Client.Publish(device.KindRaw, topic, wire); err != nil {
+		return err
+	}
+	log.Printf("[%s] 📦 透传上报 %d 字节 -> %s (codec: %s)", d.Name, len(wire), topic, codec.Name())
+	return nil
+}
+
+// SubscribeRaw 订阅自定义 Topic，收到的载荷经 Codec 解码后交给 handler；
+// 订阅会被记录，连接恢复后由 subscribeForCommands 重新订阅
+func (d *Device) SubscribeRaw(template string, codec PayloadCodec, handler RawMessageHandler) error {
+	sub := rawSubscription{template: template, codec: codec, handler: handler}
+	d.rawSubsMu.Lock()
+	d.rawSubs = append(d.rawSubs, sub)
+	d.rawSubsMu.Unlock()
+	if d.Client == nil || !d.Client.IsConnected() {
+		return nil
+	}
+	return d.subscribeRaw(sub)
+}
+
+func (d *Device) subscribeRaw(sub rawSubscription) error {
+	topic := getTopic(d.Name, sub.template)
+	return d.Client.Subscribe(topic, func(client mqtt.Client, msg mqtt.Message) {
+		d.recordTraffic(DirectionDown, msg.Topic(), msg.Qos(), msg.Retained(), msg.Payload())
+		data, err := sub.codec.Decode(msg.Payload())
+		if err != nil {
+			log.Printf("[%s] 透传数据解码失败 (%s): %v", d.Name, sub.codec.Name(), err)
+			return
+		}
+		log.Printf("[%s] 📦 透传下行 %d 字节 <- %s: %s", d.Name, len(msg.Payload()), msg.Topic(), data)
+		sub.handler(d, msg.Topic(), data)
+	})
+}
+
+// setupRawSimulation 按命令行配置注册透传模拟 (下行订阅及可选回显)
+func (d *Device) setupRawSimulation() error {
+	if RawDownTopic == "" {
+		return nil
+	}
+	codec, err := newPayloadCodec(RawCodecSpec)
+	if err != nil {
+		return err
+	}
+	return d.SubscribeRaw(RawDownTopic, codec, func(d *Device, topic string, data []byte) {
+		if RawEcho && RawUpTopic != "" {
+			if err := d.PublishRaw(RawUpTopic, data, codec); err != nil {
+				log.Printf("[%s] 透传回显失败: %v", d.Name, err)
+			}
+		}
+	})
+}
+
+// postRawData 周期上报透传数据
+func (d *Device) postRawData() {
+	codec, err := newPayloadCodec(RawCodecSpec)
+	if err != nil {
+		log.Printf("[%s] 透传 Codec 配置错误: %v", d.Name, err)
+		return
+	}
+	if err := d.PublishRaw(RawUpTopic, []byte(RawPayload), codec); err != nil {
+		log.Printf("[%s] 透传上报失败: %v", d.Name, err)
+	}
+}
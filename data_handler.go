@@ -6,52 +6,74 @@ import (
 	"log"
 	"math/rand"
 	"strings"
+	"sync"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"qsiot_server/onenet/device"
+	"qsiot_server/onenet/topics"
 )
 
+//go:generate go run ./cmd/tmgen -in thingmodel.json -out thingmodel_gen.go
+
 // Device 结构体封装了每个设备的 MQTT 客户端、名称及本地状态
 type Device struct {
 	Name   string
-	Client mqtt.Client
+	Client *device.Client // 物模型消息收发 (见 onenet/device)，由 attachClient 绑定到 MQTT 连接
 
-	// --- 本地属性状态 (对应物模型 int32 类型) ---
-	OUT      int32
-	Interval int32
-	Relay    int32
+	// --- 本地属性状态 (由 thingmodel.json 生成的类型化属性集合) ---
+	// propsMu 保护属性与事件上报格式: Runner、下行命令处理、shell 与 Web 仪表盘并发读写，
+	// 外部通过 getProperty / propertySnapshot / eventFormat 读取
+	ThingProperties
+	propsMu sync.Mutex
 
 	// --- 静态属性缓存 (只读属性只需计算一次) ---
 	// 注意：这里缓存的是包装后的属性，用于 property/post
-	StaticProps map[string]interface{}
+	StaticProps map[string]device.TimedValue
+
+	// --- 设备时钟：所有上报时间戳的来源，可通过平台校时 ---
+	Clock *DeviceClock
+
+	// --- 事件上报格式 (默认取 CurrentEventFormat) ---
+	EventFormat EventReportFormat
+
+	// --- 故障注入配置 (nil 表示不注入) ---
+	Faults *FaultProfile
+
+	// --- 自定义 Topic 订阅 (透传数据)，重连后重新订阅 ---
+	rawSubsMu sync.Mutex
+	rawSubs   []rawSubscription
 
 	// --- 控制通道：用于发送信号 (如周期更新) 给 Runner ---
 	controlChan chan int32
+
+	// --- 连接状态机 (见 connection_state.go)，进入 stopped 时关闭 done ---
+	stateMu    sync.Mutex
+	state      ConnState
+	onlineGen  int // 累计上线次数，用于判断连接是否已更换
+	done       chan struct{}
+	connCtl    chan bool // 手动断开/恢复连接指令 (Disconnect/Connect)
+	runnerOnce sync.Once
+
+	// --- 收发统计 (见 device_stats.go) ---
+	Stats DeviceStats
+
+	// --- 上行限流 (见 rate_limit.go) ---
+	limiter *deviceLimiter
+
+	// --- 持久化状态 (见 device_store.go) ---
+	persistMu     sync.Mutex
+	lastReportIDs map[device.Kind]string
+	lastMsgID     int64
+	noPersist     bool // 不读写状态文件 (不受全局 StateDir 影响)
 }
 
-// ======================================================================
-// Topic 模板定义
-// ======================================================================
-const (
-	// 产品ID: 5S34OM4Rc6
-
-	// ======================================================================
-	// 🚀 发布 (设备 -> 云端)
-	// ======================================================================
-	PropertyPostTopicTemplate     = "$sys/5S34OM4Rc6/{device-name}/thing/property/post"      // 发布: 直连设备上报属性
-	EventPostTopicTemplate        = "$sys/5S34OM4Rc6/{device-name}/thing/event/post"         // 发布: 直连设备上报事件
-	PropertySetReplyTopicTemplate = "$sys/5S34OM4Rc6/{device-name}/thing/property/set_reply" // 发布: 直连设备属性设置响应
-	PropertyGetReplyTopicTemplate = "$sys/5S34OM4Rc6/{device-name}/thing/property/get_reply" // 发布: 直连设备回复平台获取设备属性
-	PackPostTopicTemplate         = "$sys/5S34OM4Rc6/{device-name}/thing/pack/post"          // 发布: 直连设备或子设备批量上报属性或事件
-
-	// ======================================================================
-	// ⬇️ 订阅 (云端 -> 设备)
-	// ======================================================================
-	PropertyPostReplyTopicTemplate = "$sys/5S34OM4Rc6/{device-name}/thing/property/post/reply" // 订阅: 直连设备上报属性响应
-	EventPostReplyTopicTemplate    = "$sys/5S34OM4Rc6/{device-name}/thing/event/post/reply"    // 订阅: 直连设备上报事件响应
-	PackPostReplyTopicTemplate     = "$sys/5S34OM4Rc6/{device-name}/thing/pack/post/reply"     // 订阅: 平台回复"设备批量上报属性或事件"
-	PropertySetTopicTemplate       = "$sys/5S34OM4Rc6/{device-name}/thing/property/set"        // 订阅: 设置直连设备属性
-	PropertyGetTopicTemplate       = "$sys/5S34OM4Rc6/{device-name}/thing/property/get"        // 订阅: 平台获取直连设备的属性
+// 属性分组：静态属性只在启动时生成，动态属性每次上报重新采集
+// (Topic 模板与属性标识符常量见 thingmodel_gen.go)
+var (
+	staticPropertyIDs  = []string{PropImsi, PropCellInfo, PropMacs, PropOneNETLBS, PropOneNETLBSWIFI}
+	dynamicPropertyIDs = []string{PropTemperature, PropCsq, PropOUT, PropRelay, PropInterval}
 )
 
 // EventReportFormat 定义事件上报格式
@@ -69,83 +91,172 @@ const (
 )
 
 // CurrentEventFormat 用于在不同格式之间切换测试
-// 保持 FormatBatch 作为当前模式
+// 保持 FormatBatch 作为当前模式 (新设备的默认格式，单台设备可通过 Device.EventFormat 覆盖)
 var CurrentEventFormat = FormatDirect
 
-// initDeviceState 初始化设备状态
+// eventFormatNames 事件格式名称 (用于命令行/交互式 shell)
+var eventFormatNames = map[EventReportFormat]string{
+	FormatDirect:  "direct",
+	FormatWrapped: "wrapped",
+	FormatBatch:   "batch",
+}
+
+func (f EventReportFormat) String() string {
+	if name, ok := eventFormatNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("EventReportFormat(%d)", int(f))
+}
+
+// parseEventFormat 按名称解析事件格式
+func parseEventFormat(name string) (EventReportFormat, error) {
+	for f, n := range eventFormatNames {
+		if n == name {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown event format: %s (want direct, wrapped or batch)", name)
+}
+
+// initDeviceState 初始化设备状态 (从状态文件恢复，并在属性变化时写回)
 func initDeviceState(deviceName string) *Device {
+	return newDeviceState(deviceName, true)
+}
+
+// newDeviceState 初始化设备状态，persist 为 false 时不读写状态文件 (离线回放)
+func newDeviceState(deviceName string, persist bool) *Device {
 	rand.Seed(time.Now().UnixNano())
 	dev := &Device{
-		Name:        deviceName,
-		OUT:         0,
-		Relay:       0,
-		Interval:    10,
+		Name: deviceName,
+		ThingProperties: ThingProperties{
+			OUT:      0,
+			Relay:    0,
+			Interval: 10,
+		},
+		Clock:       NewDeviceClock(ClockInitialSkew, ClockDriftPPM),
+		EventFormat: CurrentEventFormat,
+		Faults:      faultConfig.profileFor(deviceName),
 		controlChan: make(chan int32, 1),
-	}
+		state:       StateOffline,
+		done:        make(chan struct{}),
+		connCtl:     make(chan bool, 1),
+		limiter:     newDeviceLimiter(),
+		noPersist:   !persist,
+	}
+	// 恢复上次运行保存的可写属性与上报 ID
+	dev.restoreState()
 	// 缓存包装后的静态属性 (用于 property/post)
 	dev.StaticProps = dev.generateStaticProperties()
 	return dev
 }
 
 // getTopic 根据设备名和模板获取最终的 Topic 字符串
+// (模板中的物模型产品 ID 与 {product-id} 均替换为当前产品 ID)
 func getTopic(deviceName string, template string) string {
-	s := strings.ReplaceAll(template, "5S34OM4Rc6", ProductID)
-	return strings.ReplaceAll(s, "{device-name}", deviceName)
+	s := strings.ReplaceAll(template, ThingModelProductID, ProductID)
+	return topics.Expand(s, ProductID, deviceName)
+}
+
+// attachClient 在 MQTT 连接上创建设备 Client
+func (d *Device) attachClient(conn mqtt.Client) {
+	d.attachTransport(device.NewMQTTTransport(conn))
+}
+
+// attachTransport 在 Transport 上创建设备 Client: 上行消息按类别使用配置的 QoS 与保留标志，
+// 先经限流与故障注入，实际发出后写入会话录制并登记推送核对
+func (d *Device) attachTransport(t device.Transport) {
+	opts := append(publishOptions(), device.WithMiddleware(d.rateLimit, d.injectFaults, d.recordUplink))
+	d.Client = device.NewTransportClient(t, ProductID, d.Name, opts...)
+}
+
+// recordUplink 发布中间件: 发布成功后记录上行流量 (推送核对在发出前登记，失败时撤销)
+func (d *Device) recordUplink(next device.PublishFunc) device.PublishFunc {
+	return func(kind device.Kind, topic string, payload []byte) error {
+		if deliveries != nil {
+			deliveries.published(d.Name, topic, payload, time.Now())
+		}
+		if err := next(kind, topic, payload); err != nil {
+			if deliveries != nil {
+				deliveries.unpublished(d.Name, topic, payload)
+			}
+			return err
+		}
+		o := d.Client.PublishOptions(kind)
+		d.recordTraffic(DirectionUp, topic, o.QoS, o.Retain, payload)
+		return nil
+	}
 }
 
 // wrapValue 辅助函数：将值包装成 {"value": data} 标准格式 (用于 property/post)
-func wrapValue(data interface{}) map[string]interface{} {
-	return map[string]interface{}{"value": data}
+func wrapValue(data interface{}) device.TimedValue {
+	return device.TimedValue{Value: data}
 }
 
 // ======================================================================
 // 属性数据生成 (Raw vs Wrapped)
 // ======================================================================
 
-// generateRawStaticProperties 模拟生成静态/只读属性数据 (返回原始值，用于 property/get_reply)
+// generateRawStaticProperties 模拟生成静态/只读属性数据 (返回原始值，设备创建时生成一次并缓存于 StaticProps)
+// 数组属性按物模型定长生成: cell_info 为 5 个小区的 LAC/CID，macs 10 个，$OneNET_LBS 为 3 个基站
 func (d *Device) generateRawStaticProperties() map[string]interface{} {
 	// 原始属性值，不进行 wrapValue 包装
-	properties := map[string]interface{}{
-		"imsi": fmt.Sprintf("46000%d", rand.Int31n(999999999)),
-		"cell_info": []string{
-			fmt.Sprintf("LAC:%d", 1024+rand.Int31n(10)),
-			fmt.Sprintf("CID:%d", 2048+rand.Int31n(100)),
-		},
-		"macs": []string{"AA:BB:CC:DD:EE:FF", "11:22:33:44:55:66"},
-
-		// 结构体和数组的原始结构 (int32 类型保持 int32)
-		"$OneNET_LBS": []map[string]interface{}{
-			{
-				"mnc": int32(1), "mcc": int32(460), "lac": int32(1024), "cid": int32(2048),
-				"networkType": int32(2), "ss": int32(80 + rand.Int31n(20)),
-				"signalLength": int32(100), "ta": int32(1), "flag": int32(1),
-			},
-		},
-		"$OneNET_LBS_WIFI": map[string]interface{}{
-			"imsi":     fmt.Sprintf("WIFI_IMSI_%d", rand.Int31n(1000)),
-			"serverip": "", "macs": "", "mmac": "", "smac": "", "idfa": "",
+	props := ThingProperties{
+		Imsi: fmt.Sprintf("46000%d", rand.Int31n(999999999)),
+		OneNETLBSWIFI: OneNETLBSWIFI{
+			Imsi: fmt.Sprintf("WIFI_IMSI_%d", rand.Int31n(1000)),
 		},
 	}
-	return properties
+	for i := int32(0); i < 5; i++ {
+		props.CellInfo = append(props.CellInfo,
+			fmt.Sprintf("LAC:%d", 1024+rand.Int31n(10)),
+			fmt.Sprintf("CID:%d", 2048+i*100+rand.Int31n(100)),
+		)
+	}
+	for i := 0; i < 10; i++ {
+		props.Macs = append(props.Macs, fmt.Sprintf("AA:BB:CC:%02X:%02X:%02X", i, rand.Intn(256), rand.Intn(256)))
+	}
+	for i := int32(0); i < 3; i++ {
+		props.OneNETLBS = append(props.OneNETLBS, OneNETLBSItem{
+			Mnc: 1, Mcc: 460, Lac: 1024, Cid: 2048 + i,
+			NetworkType: 2, Ss: 80 + rand.Int31n(20),
+			SignalLength: 100, Ta: 1, Flag: 1,
+		})
+	}
+	return props.propertyMap(staticPropertyIDs)
 }
 
 // generateRawDynamicProperties 模拟生成动态属性数据 (返回原始值，用于 property/get_reply)
 func (d *Device) generateRawDynamicProperties() map[string]interface{} {
-	// 原始属性值，不进行 wrapValue 包装 (int32 类型保持 int32)
-	properties := map[string]interface{}{
-		"temperature": rand.Int31n(40) + 10,
-		"csq":         rand.Int31n(31),
-		"OUT":         d.OUT,
-		"relay":       d.Relay,
-		"interval":    d.Interval,
+	// 采集传感器数据后按标识符读取 (int32 类型保持 int32)
+	d.propsMu.Lock()
+	defer d.propsMu.Unlock()
+	d.Temperature = rand.Int31n(40) + 10
+	d.Csq = rand.Int31n(31)
+	return d.propertyMap(dynamicPropertyIDs)
+}
+
+// propertyMap 按标识符列表导出属性原始值 (按物模型编码: date 为毫秒时间戳，浮点按 step 精度)
+func (p *ThingProperties) propertyMap(ids []string) map[string]interface{} {
+	properties := make(map[string]interface{}, len(ids))
+	for _, id := range ids {
+		v, ok := p.getProperty(id)
+		if !ok {
+			continue
+		}
+		enc, err := thingModelSpec.EncodeProperty(id, v)
+		if err != nil {
+			log.Printf("属性 %s 编码失败，按原值上报: %v", id, err)
+			enc = v
+		}
+		properties[id] = enc
 	}
 	return properties
 }
 
 // generateStaticProperties 模拟生成静态/只读属性数据 (用于 property/post，需要包装)
-func (d *Device) generateStaticProperties() map[string]interface{} {
+func (d *Device) generateStaticProperties() map[string]device.TimedValue {
 	rawProps := d.generateRawStaticProperties()
-	wrappedProps := make(map[string]interface{})
+	wrappedProps := make(map[string]device.TimedValue)
 	for k, v := range rawProps {
 		wrappedProps[k] = wrapValue(v)
 	}
@@ -153,9 +264,9 @@ func (d *Device) generateStaticProperties() map[string]interface{} {
 }
 
 // generateDynamicProperties 模拟生成动态属性数据 (用于 property/post，需要包装)
-func (d *Device) generateDynamicProperties() map[string]interface{} {
+func (d *Device) generateDynamicProperties() map[string]device.TimedValue {
 	rawProps := d.generateRawDynamicProperties()
-	wrappedProps := make(map[string]interface{})
+	wrappedProps := make(map[string]device.TimedValue)
 	for k, v := range rawProps {
 		wrappedProps[k] = wrapValue(v)
 	}
@@ -169,13 +280,12 @@ func (d *Device) generateDynamicProperties() map[string]interface{} {
 // postDeviceProperty 模拟设备上报属性
 // 🚀 发布到: $sys/5S34OM4Rc6/{device-name}/thing/property/post
 func (d *Device) postDeviceProperty(isFullReport bool) {
-	postTopic := getTopic(d.Name, PropertyPostTopicTemplate)
-	msgID := fmt.Sprintf("%d", time.Now().UnixNano()/1000000)
+	msgID := d.newMessageID()
 
-	var properties map[string]interface{}
+	var properties map[string]device.TimedValue
 
 	if isFullReport {
-		properties = make(map[string]interface{})
+		properties = make(map[string]device.TimedValue)
 		// 静态属性使用缓存的包装值
 		for k, v := range d.StaticProps {
 			properties[k] = v
@@ -188,68 +298,62 @@ func (d *Device) postDeviceProperty(isFullReport bool) {
 	} else {
 		// 定时上报仅使用新生成的动态属性的包装值
 		properties = d.generateDynamicProperties()
-		properties["relay"] = wrapValue(d.Relay)
+		d.propsMu.Lock()
+		properties[PropRelay] = wrapValue(d.Relay)
+		d.propsMu.Unlock()
 		log.Printf("[%s] [定时上报] 仅上报动态属性", d.Name)
 	}
 
 	// 结构: {"params": {"key": {"value": data}}}
-	payloadStruct := map[string]interface{}{
-		"id":      msgID,
-		"version": "1.0",
-		"params":  properties,
-	}
-
-	payloadBytes, _ := json.Marshal(payloadStruct)
-	payload := string(payloadBytes)
-
-	token := d.Client.Publish(postTopic, 1, false, payload)
-
-	if token.Wait() && token.Error() != nil {
-		log.Printf("[%s] 属性上报失败: %v", d.Name, token.Error())
+	if err := d.Client.PostProperties(msgID, properties); err != nil {
+		log.Printf("[%s] 属性上报失败: %v", d.Name, err)
+		d.Stats.noteError(fmt.Sprintf("属性上报失败: %v", err))
 	} else {
 		log.Printf("[%s] ✅ 属性上报成功 (ID: %s)", d.Name, msgID)
+		d.Stats.notePost(time.Now())
+		d.rememberReportID(device.KindPropertyPost, msgID)
 	}
 }
 
-// postDeviceEvent 模拟设备上报事件 (支持3种格式)
-// 🚀 发布到: $sys/5S34OM4Rc6/{device-name}/thing/event/post 或 thing/pack/post
+// postDeviceEvent 模拟设备上报事件 (随机生成告警参数)
 func (d *Device) postDeviceEvent(eventID string) {
-	msgID := fmt.Sprintf("%d", time.Now().UnixNano()/1000000)
-	alarmValue := rand.Int31n(2)
+	// 🚨 关键：事件参数必须是 int32 类型 (0 或 1)
+	params := AlarmEvent{
+		PowerOff: rand.Int31n(2),
+		IN1:      1,
+	}.params()
+	if err := d.postEvent(eventID, params); err != nil {
+		log.Printf("[%s] 🔥 事件上报失败: %v", d.Name, err)
+		d.Stats.noteError(fmt.Sprintf("事件上报失败: %v", err))
+	}
+}
+
+// postEvent 按设备的事件格式上报事件 (支持3种格式)，params 按物模型编码校验
+// 🚀 发布到: $sys/5S34OM4Rc6/{device-name}/thing/event/post 或 thing/pack/post
+func (d *Device) postEvent(eventID string, params map[string]interface{}) error {
+	msgID := d.newMessageID()
 
 	var payload string
 	var postTopic string
 	var formatName string
 
-	// 🚨 关键：事件参数必须是 int32 类型 (0 或 1)
-	rawEventParams := map[string]interface{}{
-		"powerOff":    int32(alarmValue),
-		"overcurrent": int32(0),
-		"smoke":       int32(0),
-		"IN1":         int32(1),
-		"IN2":         int32(0),
+	rawEventParams, err := thingModelSpec.EncodeEvent(eventID, params)
+	if err != nil {
+		return fmt.Errorf("encode event params: %w", err)
 	}
 
-	switch CurrentEventFormat {
+	switch d.eventFormat() {
 	case FormatDirect:
 		// 格式 1：直接格式 - event/post
 		// 修正：事件数据需要嵌套在 "value" 键下，以解决 2409 错误 (required value:identifier:alarm)
 		// 结构: {"id": "...", "version": "1.0", "params": {"alarm": {"value": {...}}}}
 		
 		// 🚨 修正点：将原始参数包在一个以 "value" 为键的 map 中，然后再包在 eventID 下
-		wrappedEventData := map[string]interface{}{
-			"value": rawEventParams,
-		}
-		
-		nestedParams := map[string]interface{}{
-			eventID: wrappedEventData, // 使用修正后的嵌套结构
+		nestedParams := map[string]device.TimedValue{
+			eventID: {Value: rawEventParams}, // 使用修正后的嵌套结构
 		}
 
-		payloadStruct := map[string]interface{}{
-			"id":      msgID,
-			"version": "1.0",
-			"params":  nestedParams,
-		}
+		payloadStruct := device.Request{ID: msgID, Version: device.ProtocolVersion, Params: nestedParams}
 
 		payloadBytes, _ := json.Marshal(payloadStruct)
 		payload = string(payloadBytes)
@@ -258,16 +362,12 @@ func (d *Device) postDeviceEvent(eventID string) {
 
 	case FormatWrapped:
 		// 格式 2：包装格式 - event/post
-		wrappedParams := map[string]interface{}{}
+		wrappedParams := map[string]device.TimedValue{}
 		for k, v := range rawEventParams {
 			wrappedParams[k] = wrapValue(v) // 包装成 {"value": 1}
 		}
 
-		payloadStruct := map[string]interface{}{
-			"id":      msgID,
-			"version": "1.0",
-			"params":  wrappedParams,
-		}
+		payloadStruct := device.Request{ID: msgID, Version: device.ProtocolVersion, Params: wrappedParams}
 
 		payloadBytes, _ := json.Marshal(payloadStruct)
 		payload = string(payloadBytes)
@@ -276,21 +376,17 @@ func (d *Device) postDeviceEvent(eventID string) {
 
 	case FormatBatch:
 		// 格式 3：批量格式 (pack/post topic) - 之前遇到 2307 错误
-		event := map[string]interface{}{
-			"identifier": "alarm",
-			"params":     rawEventParams,
-			"time": time.Now().Unix(), 
+		event := device.PackEvent{
+			Identifier: eventID,
+			Params:     rawEventParams,
+			Time:       d.now().Unix(),
 		}
 
 		// 结构: {"params": {"properties": {}, "events": [...]}}
-		payloadStruct := map[string]interface{}{
-			"id":      msgID,
-			"version": "1.0",
-			"params": map[string]interface{}{
-				"properties": map[string]interface{}{},
-				"events":     []map[string]interface{}{event},
-			},
-		}
+		payloadStruct := device.Request{ID: msgID, Version: device.ProtocolVersion, Params: map[string]interface{}{
+			"properties": map[string]device.TimedValue{},
+			"events":     []device.PackEvent{event},
+		}}
 
 		payloadBytes, _ := json.Marshal(payloadStruct)
 		payload = string(payloadBytes)
@@ -302,13 +398,13 @@ func (d *Device) postDeviceEvent(eventID string) {
 	log.Printf("[%s] Topic: %s", d.Name, postTopic)
 	log.Printf("[%s] Payload: %s", d.Name, payload)
 
-	token := d.Client.Publish(postTopic, 1, false, payload)
-
-	if token.Wait() && token.Error() != nil {
-		log.Printf("[%s] 🔥 事件上报失败: %v", d.Name, token.Error())
-	} else {
-		log.Printf("[%s] 🔥 事件上报尝试成功 (ID: %s)", d.Name, msgID)
+	if err := d.Client.Publish(device.KindEventPost, postTopic, []byte(payload)); err != nil {
+		return err
 	}
+	log.Printf("[%s] 🔥 事件上报尝试成功 (ID: %s)", d.Name, msgID)
+	d.Stats.notePost(time.Now())
+	d.rememberReportID(device.KindEventPost, msgID)
+	return nil
 }
 
 // ======================================================================
@@ -316,65 +412,38 @@ func (d *Device) postDeviceEvent(eventID string) {
 // ======================================================================
 
 // handlePropertyPostReply 处理平台对属性上报的回复
-// ⬇️ 订阅: $sys/5S34OM4Rc6/{device-name}/thing/property/post_reply
+// ⬇️ 订阅: $sys/5S34OM4Rc6/{device-name}/thing/property/post/reply
 func (d *Device) handlePropertyPostReply(payload []byte) {
-	var reply map[string]interface{}
-	if err := json.Unmarshal(payload, &reply); err != nil {
-		log.Printf("[%s] 解析属性上报回复失败: %v", d.Name, err)
-		return
-	}
-
-	id := reply["id"]
-	code, codeOk := reply["code"]
-	msg := reply["msg"]
-
-	if codeOk && code.(float64) == 200 {
-		log.Printf("[%s] ✅ 属性上报已确认 (ID: %v, Code: 200)", d.Name, id)
-	} else {
-		log.Printf("[%s] ❌ 属性上报被拒绝! ID: %v, Code: %v, Msg: %v",
-			d.Name, id, code, msg)
-	}
+	d.handlePostReply("属性上报", payload)
 }
 
 // handleEventPostReply 处理平台对事件上报的回复
-// ⬇️ 订阅: $sys/5S34OM4Rc6/{device-name}/thing/event/post_reply
+// ⬇️ 订阅: $sys/5S34OM4Rc6/{device-name}/thing/event/post/reply
 func (d *Device) handleEventPostReply(payload []byte) {
-	var reply map[string]interface{}
-	if err := json.Unmarshal(payload, &reply); err != nil {
-		log.Printf("[%s] 解析事件上报回复失败: %v", d.Name, err)
-		return
-	}
-
-	id := reply["id"]
-	code, codeOk := reply["code"]
-	msg := reply["msg"]
-
-	if codeOk && code.(float64) == 200 {
-		log.Printf("[%s] ✅ 事件上报已确认 (ID: %v, Code: 200)", d.Name, id)
-	} else {
-		log.Printf("[%s] ❌ 事件上报被拒绝! ID: %v, Code: %v, Msg: %v",
-			d.Name, id, code, msg)
-	}
+	d.handlePostReply("事件上报", payload)
 }
 
 // handlePackPostReply 处理平台对批量上报的回复
-// ⬇️ 订阅: $sys/5S34OM4Rc6/{device-name}/thing/pack/post_reply
+// ⬇️ 订阅: $sys/5S34OM4Rc6/{device-name}/thing/pack/post/reply
 func (d *Device) handlePackPostReply(payload []byte) {
-	var reply map[string]interface{}
-	if err := json.Unmarshal(payload, &reply); err != nil {
-		log.Printf("[%s] 解析批量上报回复失败: %v", d.Name, err)
+	d.handlePostReply("批量上报", payload)
+}
+
+// handlePostReply 记录平台对一次上报的确认或拒绝，what 为上报类别 (用于日志)
+func (d *Device) handlePostReply(what string, payload []byte) {
+	reply, err := device.ParseReply(payload)
+	if err != nil {
+		log.Printf("[%s] 解析%s回复失败: %v", d.Name, what, err)
 		return
 	}
 
-	id := reply["id"]
-	code, codeOk := reply["code"]
-	msg := reply["msg"]
-
-	if codeOk && code.(float64) == 200 {
-		log.Printf("[%s] ✅ 批量上报已确认 (ID: %v, Code: 200)", d.Name, id)
+	d.Stats.noteReply(reply.Code)
+	if reply.OK() {
+		log.Printf("[%s] ✅ %s已确认 (ID: %v, Code: 200)", d.Name, what, reply.ID)
 	} else {
-		log.Printf("[%s] ❌ 批量上报被拒绝! ID: %v, Code: %v, Msg: %v",
-			d.Name, id, code, msg)
+		log.Printf("[%s] ❌ %s被拒绝! ID: %v, Code: %v, Msg: %v",
+			d.Name, what, reply.ID, reply.Code, reply.Msg)
+		d.Stats.noteError(fmt.Sprintf("%s被拒绝: code=%v msg=%v", what, reply.Code, reply.Msg))
 	}
 }
 
@@ -382,12 +451,14 @@ func (d *Device) handlePackPostReply(payload []byte) {
 func createMessageHandler(dev *Device) mqtt.MessageHandler {
 	return func(client mqtt.Client, msg mqtt.Message) {
 		log.Printf("[%s] ⬇️ 收到消息 Topic: %s", dev.Name, msg.Topic())
+		dev.recordTraffic(DirectionDown, msg.Topic(), msg.Qos(), msg.Retained(), msg.Payload())
 
 		setTopic := getTopic(dev.Name, PropertySetTopicTemplate)
 		getTopicVar := getTopic(dev.Name, PropertyGetTopicTemplate)
 		postReplyTopic := getTopic(dev.Name, PropertyPostReplyTopicTemplate)
 		eventReplyTopic := getTopic(dev.Name, EventPostReplyTopicTemplate)
 		packReplyTopic := getTopic(dev.Name, PackPostReplyTopicTemplate)
+		ntpResponseTopic := getTopic(dev.Name, NtpResponseTopicTemplate)
 
 		switch msg.Topic() {
 		case setTopic:
@@ -400,7 +471,13 @@ func createMessageHandler(dev *Device) mqtt.MessageHandler {
 			dev.handleEventPostReply(msg.Payload())
 		case packReplyTopic:
 			dev.handlePackPostReply(msg.Payload())
+		case ntpResponseTopic:
+			dev.handleNtpResponse(msg.Payload())
 		default:
+			if id, ok := serviceInvokeID(msg.Topic()); ok {
+				dev.handleServiceInvoke(id, msg.Payload())
+				return
+			}
 			log.Printf("[%s] 收到未知 Topic 消息，忽略", dev.Name)
 		}
 	}
@@ -409,14 +486,14 @@ func createMessageHandler(dev *Device) mqtt.MessageHandler {
 // handlePropertySet 处理平台下发的属性设置命令
 // ⬇️ 订阅: $sys/5S34OM4Rc6/{device-name}/thing/property/set
 func (d *Device) handlePropertySet(payload []byte) {
-	var req map[string]interface{}
-	if err := json.Unmarshal(payload, &req); err != nil {
+	req, err := device.ParseRequest(payload)
+	if err != nil {
 		log.Printf("[%s] 解析属性设置命令失败: %v", d.Name, err)
 		return
 	}
 
-	msgID := req["id"]
-	params, ok := req["params"].(map[string]interface{})
+	msgID := req.ID
+	params, ok := req.Params.(map[string]interface{})
 	if !ok {
 		log.Printf("[%s] 命令格式错误，缺少 params 字段", d.Name)
 		return
@@ -424,144 +501,330 @@ func (d *Device) handlePropertySet(payload []byte) {
 
 	log.Printf("[%s] 开始设置属性: %v", d.Name, params)
 
+	setErr := d.applyProperties(params)
+
+	code, replyMsg := device.CodeSuccess, "success"
+	if setErr != nil {
+		code, replyMsg = device.CodeBadRequest, setErr.Error()
+		log.Printf("[%s] ❌ 属性设置被拒绝: %v", d.Name, setErr)
+	}
+
+	// 🚀 发布回复到: $sys/5S34OM4Rc6/{device-name}/thing/property/set_reply
+	reply := device.Reply{ID: msgID, Code: code, Msg: replyMsg}
+	if err := d.Client.Reply(device.KindSetReply, topics.PropertySetReply, reply); err != nil {
+		log.Printf("[%s] 属性设置回复失败: %v", d.Name, err)
+	} else {
+		log.Printf("[%s] ⬆️ 已回复属性设置确认, ID: %v, Code: %d", d.Name, msgID, code)
+		if setErr == nil {
+			// 回复后立即上报最新状态
+			d.postDeviceProperty(false)
+		}
+	}
+}
+
+// applyProperties 应用一组属性设置 (平台 property/set 与交互式 shell 共用)：
+// 先在副本上逐项解码和校验，全部通过后再整体生效并持久化 (读改写在 propsMu 内完成，并发设置不会丢失)
+func (d *Device) applyProperties(params map[string]interface{}) error {
+	d.propsMu.Lock()
+	next := d.ThingProperties
 	for k, v := range params {
-		if val, isFloat := v.(float64); isFloat {
-			intValue := int32(val)
-
-			switch k {
-			case "OUT":
-				d.OUT = intValue
-				log.Printf("[%s] 成功设置 OUT = %d", d.Name, intValue)
-			case "relay":
-				d.Relay = intValue
-				log.Printf("[%s] 成功设置 relay = %d", d.Name, intValue)
-			case "interval":
-				d.Interval = intValue
-				log.Printf("[%s] 成功设置上报周期 interval = %d", d.Name, intValue)
-				select {
-				case d.controlChan <- d.Interval:
-					log.Printf("[%s] 已发送周期更新信号: %d秒", d.Name, d.Interval)
-				default:
-				}
-			default:
-				log.Printf("[%s] 忽略未知可写属性: %s", d.Name, k)
-			}
+		if err := next.setProperty(k, v); err != nil {
+			d.propsMu.Unlock()
+			return err
 		}
 	}
+	intervalChanged := next.Interval != d.Interval
+	interval := next.Interval
+	applied := next.propertyMap(keys(params))
+	d.ThingProperties = next
+	d.propsMu.Unlock()
 
-	// 🚀 发布回复到: $sys/5S34OM4Rc6/{device-name}/thing/property/set_reply
-	replyTopic := getTopic(d.Name, PropertySetReplyTopicTemplate)
-	replyPayloadStruct := map[string]interface{}{
-		"id":      msgID,
-		"version": "1.0",
-		"code":    200,
-		"msg":     "success",
+	d.persist()
+	for k := range params {
+		log.Printf("[%s] 成功设置 %s = %v", d.Name, k, applied[k])
+	}
+	if intervalChanged {
+		select {
+		case d.controlChan <- interval:
+			log.Printf("[%s] 已发送周期更新信号: %d秒", d.Name, interval)
+		default:
+		}
 	}
+	return nil
+}
 
-	replyPayloadBytes, _ := json.Marshal(replyPayloadStruct)
+// getProperty 读取一个属性的当前值
+func (d *Device) getProperty(id string) (interface{}, bool) {
+	d.propsMu.Lock()
+	defer d.propsMu.Unlock()
+	return d.ThingProperties.getProperty(id)
+}
 
-	token := d.Client.Publish(replyTopic, 1, false, string(replyPayloadBytes))
-	if token.Wait() && token.Error() != nil {
-		log.Printf("[%s] 属性设置回复失败: %v", d.Name, token.Error())
-	} else {
-		log.Printf("[%s] ⬆️ 已回复属性设置确认, ID: %v", d.Name, msgID)
-		// 回复后立即上报最新状态
-		d.postDeviceProperty(false)
+// propertySnapshot 返回当前属性的副本
+func (d *Device) propertySnapshot() ThingProperties {
+	d.propsMu.Lock()
+	defer d.propsMu.Unlock()
+	return d.ThingProperties
+}
+
+// eventFormat 返回当前事件上报格式
+func (d *Device) eventFormat() EventReportFormat {
+	d.propsMu.Lock()
+	defer d.propsMu.Unlock()
+	return d.EventFormat
+}
+
+// setEventFormat 切换事件上报格式 (shell format 命令)
+func (d *Device) setEventFormat(f EventReportFormat) {
+	d.propsMu.Lock()
+	d.EventFormat = f
+	d.propsMu.Unlock()
+}
+
+// keys 返回 map 的键
+func keys(m map[string]interface{}) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
 	}
+	return out
 }
 
-// handlePropertyGet 处理平台的属性查询命令
+// handlePropertyGet 处理平台的属性查询命令: 只回复 params 中请求的标识符 (为空时回复全部属性)，
+// 请求中含未知标识符时回复 400
 // ⬇️ 订阅: $sys/5S34OM4Rc6/{device-name}/thing/property/get
 func (d *Device) handlePropertyGet(payload []byte) {
-	var req map[string]interface{}
-	if err := json.Unmarshal(payload, &req); err != nil {
+	req, err := device.ParseRequest(payload)
+	if err != nil {
 		log.Printf("[%s] 解析属性获取命令失败: %v", d.Name, err)
 		return
 	}
 
-	msgID := req["id"]
+	msgID := req.ID
+
+	// 🚀 发布回复到: $sys/5S34OM4Rc6/{device-name}/thing/property/get_reply
+	// 结构: {"data": {"key": data}}，使用不含 {"value":...} 包装的原始值
+	reply := device.Reply{ID: msgID, Code: device.CodeSuccess, Msg: "success"}
+	ids, err := propertyGetIDs(req.Params)
+	if err != nil {
+		reply.Code, reply.Msg = device.CodeBadRequest, err.Error()
+		log.Printf("[%s] ❌ 属性查询被拒绝: %v", d.Name, err)
+	} else {
+		reply.Data = d.rawProperties(ids)
+	}
 
-	// 🚨 关键修正：获取未包装的原始属性值 (用于回复平台查询)
-	rawStaticProps := d.generateRawStaticProperties()
-	rawDynamicProps := d.generateRawDynamicProperties()
+	if err := d.Client.Reply(device.KindGetReply, topics.PropertyGetReply, reply); err != nil {
+		log.Printf("[%s] 属性获取回复失败: %v", d.Name, err)
+	} else {
+		log.Printf("[%s] ⬆️ 已回复平台属性查询, ID: %v, Code: %d", d.Name, msgID, reply.Code)
+	}
+}
 
-	allRawProperties := make(map[string]interface{})
-	for k, v := range rawStaticProps {
-		allRawProperties[k] = v
+// propertyGetIDs 解析 property/get 的 params (标识符数组)，为空时返回全部属性
+func propertyGetIDs(params interface{}) ([]string, error) {
+	list, ok := params.([]interface{})
+	if params != nil && !ok {
+		return nil, fmt.Errorf("params must be an array of property identifiers")
+	}
+	if len(list) == 0 {
+		return append(append([]string{}, staticPropertyIDs...), dynamicPropertyIDs...), nil
+	}
+	ids := make([]string, 0, len(list))
+	var unknown []string
+	for _, v := range list {
+		id, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("params must be an array of property identifiers, got %v", v)
+		}
+		if !containsString(staticPropertyIDs, id) && !containsString(dynamicPropertyIDs, id) {
+			unknown = append(unknown, id)
+			continue
+		}
+		ids = append(ids, id)
 	}
-	for k, v := range rawDynamicProps {
-		allRawProperties[k] = v
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown property identifiers: %s", strings.Join(unknown, ", "))
 	}
+	return ids, nil
+}
 
-	// 🚀 发布回复到: $sys/5S34OM4Rc6/{device-name}/thing/property/get_reply
-	// 结构: {"data": {"key": data}}
-	replyTopic := getTopic(d.Name, PropertyGetReplyTopicTemplate)
-	replyPayloadStruct := map[string]interface{}{
-		"id":      msgID,
-		"version": "1.0",
-		"code":    200,
-		"msg":     "success",
-		"data":    allRawProperties, // 使用不含 {"value":...} 包装的原始值
+// rawProperties 按标识符导出属性原始值: 静态属性取缓存的 StaticProps (与属性上报一致)，
+// 动态属性重新采集
+func (d *Device) rawProperties(ids []string) map[string]interface{} {
+	var dynamic map[string]interface{}
+	out := make(map[string]interface{}, len(ids))
+	for _, id := range ids {
+		if v, ok := d.StaticProps[id]; ok {
+			out[id] = v.Value
+			continue
+		}
+		if dynamic == nil {
+			dynamic = d.generateRawDynamicProperties()
+		}
+		if v, ok := dynamic[id]; ok {
+			out[id] = v
+		}
+	}
+	return out
+}
+
+// serviceHandlers 物模型服务的设备端实现 (按服务标识符注册): 输入为按物模型解码后的参数，
+// 返回待编码的输出参数；物模型中定义但未注册实现的服务调用回复 400
+var serviceHandlers = map[string]func(d *Device, in map[string]interface{}) (map[string]interface{}, error){}
+
+// serviceInvokeID 从 thing/service/{identifier}/invoke Topic 中取出物模型服务标识符
+func serviceInvokeID(topic string) (string, bool) {
+	_, _, suffix, ok := topics.Parse(topic)
+	if !ok {
+		return "", false
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(suffix, "thing/service/"), "/invoke")
+	if id == "" || suffix != topics.ServiceInvoke(id) {
+		return "", false
 	}
+	if _, ok := thingModelSpec.Service(id); !ok {
+		return "", false
+	}
+	return id, true
+}
 
-	replyPayloadBytes, err := json.Marshal(replyPayloadStruct)
+// handleServiceInvoke 处理平台的服务调用: 按物模型校验输入参数后执行服务，
+// 以 invoke_reply 回复输出参数；服务未实现或参数不合法时回复 400
+// ⬇️ 订阅: $sys/5S34OM4Rc6/{device-name}/thing/service/{identifier}/invoke
+func (d *Device) handleServiceInvoke(id string, payload []byte) {
+	req, err := device.ParseRequest(payload)
 	if err != nil {
-		log.Printf("[%s] 序列化属性获取回复失败: %v", d.Name, err)
+		log.Printf("[%s] 解析服务调用命令失败: %v", d.Name, err)
 		return
 	}
 
-	log.Printf("[%s] ⬆️ 已回复平台属性查询, ID: %v", d.Name, msgID)
+	msgID := req.ID
+	reply := device.Reply{ID: msgID, Code: device.CodeSuccess, Msg: "success"}
+	out, err := d.invokeService(id, req.Params)
+	if err != nil {
+		reply.Code, reply.Msg = device.CodeBadRequest, err.Error()
+		log.Printf("[%s] ❌ 服务 %s 调用被拒绝: %v", d.Name, id, err)
+	} else {
+		reply.Data = out
+	}
 
-	token := d.Client.Publish(replyTopic, 1, false, string(replyPayloadBytes))
-	if token.Wait() && token.Error() != nil {
-		log.Printf("[%s] 属性获取回复失败: %v", d.Name, token.Error())
+	// 🚀 发布回复到: $sys/5S34OM4Rc6/{device-name}/thing/service/{identifier}/invoke_reply
+	if err := d.Client.Reply(device.KindServiceReply, topics.ServiceInvokeReply(id), reply); err != nil {
+		log.Printf("[%s] 服务 %s 调用回复失败: %v", d.Name, id, err)
+	} else {
+		log.Printf("[%s] ⬆️ 已回复服务 %s 调用, ID: %v, Code: %d", d.Name, id, msgID, reply.Code)
 	}
 }
 
-// subscribeForCommands 订阅所有下行 Topic 和平台回复 Topic
-func (d *Device) subscribeForCommands() {
-	setTopic := getTopic(d.Name, PropertySetTopicTemplate)
-	getTopicVar := getTopic(d.Name, PropertyGetTopicTemplate)
-	postReplyTopic := getTopic(d.Name, PropertyPostReplyTopicTemplate)
-	eventReplyTopic := getTopic(d.Name, EventPostReplyTopicTemplate)
-	packReplyTopic := getTopic(d.Name, PackPostReplyTopicTemplate)
+// invokeService 解码输入参数、执行服务并按物模型编码输出参数
+func (d *Device) invokeService(id string, params interface{}) (map[string]interface{}, error) {
+	run, ok := serviceHandlers[id]
+	if !ok {
+		return nil, fmt.Errorf("service %s is not implemented", id)
+	}
+	raw, ok := params.(map[string]interface{})
+	if params != nil && !ok {
+		return nil, fmt.Errorf("params must be an object of input parameters")
+	}
+	in, err := thingModelSpec.DecodeServiceInput(id, raw)
+	if err != nil {
+		return nil, err
+	}
+	out, err := run(d, in)
+	if err != nil {
+		return nil, err
+	}
+	return thingModelSpec.EncodeServiceOutput(id, out)
+}
 
+// subscribeForCommands 订阅所有下行 Topic 和平台回复 Topic；
+// 单个 Topic 失败时按 SubscribeRetries 重试，仍失败则返回汇总错误 (不影响其他设备)
+func (d *Device) subscribeForCommands() error {
 	handler := createMessageHandler(d)
+	type commandTopic struct {
+		name     string
+		template string
+	}
+	commandTopics := []commandTopic{
+		{"Set", PropertySetTopicTemplate},
+		{"Get", PropertyGetTopicTemplate},
+		{"PostReply", PropertyPostReplyTopicTemplate},
+		{"EventReply", EventPostReplyTopicTemplate},
+		{"PackReply", PackPostReplyTopicTemplate},
+		{"Ntp", NtpResponseTopicTemplate},
+	}
+	// 物模型定义的每个服务各订阅一个 invoke Topic
+	for _, sv := range thingModelSpec.Services {
+		commandTopics = append(commandTopics, commandTopic{"Service:" + sv.Identifier, topics.Prefix + topics.ServiceInvoke(sv.Identifier)})
+	}
+
+	// 每个 Topic 独立重试，全部尝试完后汇总失败项
+	var failed []string
+	for _, t := range commandTopics {
+		topic := getTopic(d.Name, t.template)
+		if err := d.subscribeWithRetry(topic, func() error {
+			return d.Client.Subscribe(topic, handler)
+		}); err != nil {
+			failed = append(failed, fmt.Sprintf("%s(%v)", t.name, err))
+		}
+	}
 
-	tokenSet := d.Client.Subscribe(setTopic, 1, handler)
-	tokenGet := d.Client.Subscribe(getTopicVar, 1, handler)
-	tokenPostReply := d.Client.Subscribe(postReplyTopic, 1, handler)
-	tokenEventReply := d.Client.Subscribe(eventReplyTopic, 1, handler)
-	tokenPackReply := d.Client.Subscribe(packReplyTopic, 1, handler)
-
-	if (tokenSet.Wait() && tokenSet.Error() != nil) || (tokenGet.Wait() && tokenGet.Error() != nil) ||
-		(tokenPostReply.Wait() && tokenPostReply.Error() != nil) || (tokenEventReply.Wait() && tokenEventReply.Error() != nil) ||
-		(tokenPackReply.Wait() && tokenPackReply.Error() != nil) {
-		log.Fatalf("[%s] 命令订阅失败: Set(%v), Get(%v), PostReply(%v), EventReply(%v), PackReply(%v)",
-			d.Name, tokenSet.Error(), tokenGet.Error(), tokenPostReply.Error(), tokenEventReply.Error(), tokenPackReply.Error())
-	} else {
-		log.Printf("[%s] 🔑 成功订阅所有 Topic (属性设置、属性查询、各种回复)", d.Name)
+	// 自定义 Topic (透传) 订阅
+	d.rawSubsMu.Lock()
+	rawSubs := append([]rawSubscription(nil), d.rawSubs...)
+	d.rawSubsMu.Unlock()
+	for _, sub := range rawSubs {
+		sub := sub
+		if err := d.subscribeWithRetry(getTopic(d.Name, sub.template), func() error {
+			return d.subscribeRaw(sub)
+		}); err != nil {
+			failed = append(failed, fmt.Sprintf("%s(%v)", sub.template, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return &subscribeError{device: d.Name, failed: failed}
 	}
+	log.Printf("[%s] 🔑 成功订阅所有 Topic (属性设置、属性查询、服务调用、各种回复、时间同步)", d.Name)
+	return nil
 }
 
 // startDeviceSimulation 启动设备的主循环
 func (d *Device) startDeviceSimulation() {
-	log.Printf("设备 [%s] 开始运行 (事件格式模式: %v)", d.Name, CurrentEventFormat)
+	log.Printf("设备 [%s] 开始运行 (事件格式模式: %v)", d.Name, d.eventFormat())
 
 	if d.Client.IsConnected() {
-		// 首次连接，全量上报属性
-		d.postDeviceProperty(true)
+		// 连接后先校时，再全量上报属性
+		d.requestTimeSync()
+		if !ThingModelOff {
+			d.postDeviceProperty(true)
+		}
 	}
 
-	go d.runRunner()
+	// Runner 每个设备只启动一次，重连后继续复用，设备停止时退出
+	d.runnerOnce.Do(func() { go d.runRunner() })
 }
 
 // runRunner 负责处理定时上报和周期更新逻辑
 func (d *Device) runRunner() {
-	currentInterval := d.Interval
+	currentInterval := d.propertySnapshot().Interval
 	ticker := time.NewTicker(time.Duration(currentInterval) * time.Second)
 	// 假设事件每 20 秒上报一次
 	eventTicker := time.NewTicker(20 * time.Second)
+	// 透传数据周期上报 (配置了上行 Topic 时启用)
+	var rawTick <-chan time.Time
+	if RawUpTopic != "" && RawInterval > 0 {
+		rawTicker := time.NewTicker(RawInterval)
+		defer rawTicker.Stop()
+		rawTick = rawTicker.C
+	}
+	// 周期校时 (TimeSyncInterval 为 0 时不启用)
+	var syncTick <-chan time.Time
+	if TimeSyncInterval > 0 {
+		syncTicker := time.NewTicker(TimeSyncInterval)
+		defer syncTicker.Stop()
+		syncTick = syncTicker.C
+	}
 
 	log.Printf("[%s] Runner 启动，属性上报周期: %d秒，事件上报周期: 20秒", d.Name, currentInterval)
 
@@ -573,16 +836,29 @@ func (d *Device) runRunner() {
 
 	for {
 		select {
+		case <-d.done:
+			return
+
 		case <-ticker.C:
-			if d.Client.IsConnected() {
+			if d.Client.IsConnected() && !ThingModelOff {
 				d.postDeviceProperty(false) // 定时上报动态属性
 			}
 
 		case <-eventTicker.C:
-			if d.Client.IsConnected() {
+			if d.Client.IsConnected() && !ThingModelOff {
 				d.postDeviceEvent("alarm") // 上报事件
 			}
 
+		case <-rawTick:
+			if d.Client.IsConnected() {
+				d.postRawData() // 透传上报
+			}
+
+		case <-syncTick:
+			if d.Client.IsConnected() {
+				d.requestTimeSync()
+			}
+
 		case newInterval := <-d.controlChan:
 			if newInterval > 0 && newInterval != currentInterval {
 				currentInterval = newInterval
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testProductID = "testpid"
+
+// useTestConfig 替换产品配置并关闭状态持久化，测试结束后恢复
+func useTestConfig(t *testing.T) {
+	t.Helper()
+	pid, stateDir := ProductID, StateDir
+	ProductID, StateDir = testProductID, ""
+	t.Cleanup(func() { ProductID, StateDir = pid, stateDir })
+}
+
+// newOfflineDevice 创建订阅了全部命令 Topic 的离线设备
+func newOfflineDevice(t *testing.T, name string) (*Device, *offlineClient) {
+	t.Helper()
+	d := initDeviceState(name)
+	client := newOfflineClient()
+	d.attachClient(client)
+	if err := d.subscribeForCommands(); err != nil {
+		t.Fatalf("subscribeForCommands: %v", err)
+	}
+	return d, client
+}
+
+// published 返回发布到 topic 的消息载荷 (按 JSON 解码)
+func published(t *testing.T, recs []TrafficRecord, topic string) []map[string]interface{} {
+	t.Helper()
+	var out []map[string]interface{}
+	for _, rec := range recs {
+		if rec.Topic != topic {
+			continue
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(rec.Payload), &m); err != nil {
+			t.Fatalf("%s: invalid payload %s: %v", topic, rec.Payload, err)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestGetTopic(t *testing.T) {
+	useTestConfig(t)
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{PropertySetTopicTemplate, "$sys/testpid/dev1/thing/property/set"},
+		{PropertyPostReplyTopicTemplate, "$sys/testpid/dev1/thing/property/post/reply"},
+		{NtpRequestTopicTemplate, "$sys/testpid/dev1/thing/ntp/request"},
+		{"$sys/{product-id}/{device-name}/custom/up", "$sys/testpid/dev1/custom/up"},
+		{"{device-name}/{device-name}", "dev1/dev1"},
+		{"static/topic", "static/topic"},
+	}
+	for _, tt := range tests {
+		if got := getTopic("dev1", tt.template); got != tt.want {
+			t.Errorf("getTopic(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestHandlePropertySetReply(t *testing.T) {
+	useTestConfig(t)
+	tests := []struct {
+		name      string
+		params    string
+		wantCode  float64
+		wantRelay int32
+		wantPost  bool
+	}{
+		{"valid", `{"relay": 1}`, 200, 1, true},
+		{"out of range", `{"relay": 2}`, 400, 0, false},
+		{"unknown property", `{"nope": 1}`, 400, 0, false},
+		{"not writable", `{"temperature": 20}`, 400, 0, false},
+		{"partial failure applies nothing", `{"relay": 1, "interval": -5}`, 400, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, client := newOfflineDevice(t, "dev1")
+			setTopic := getTopic(d.Name, PropertySetTopicTemplate)
+			client.deliver(setTopic, 1, []byte(`{"id":"42","version":"1.0","params":`+tt.params+`}`))
+
+			recs := client.takePublished()
+			replies := published(t, recs, getTopic(d.Name, PropertySetReplyTopicTemplate))
+			if len(replies) != 1 {
+				t.Fatalf("got %d set replies, want 1", len(replies))
+			}
+			r := replies[0]
+			if r["id"] != "42" || r["code"] != tt.wantCode {
+				t.Errorf("reply = %v, want id 42 code %v", r, tt.wantCode)
+			}
+			if d.Relay != tt.wantRelay {
+				t.Errorf("relay = %d, want %d", d.Relay, tt.wantRelay)
+			}
+			posts := published(t, recs, getTopic(d.Name, PropertyPostTopicTemplate))
+			if (len(posts) > 0) != tt.wantPost {
+				t.Errorf("got %d property posts, want post=%v", len(posts), tt.wantPost)
+			}
+		})
+	}
+}
+
+func TestHandlePropertySetMalformed(t *testing.T) {
+	useTestConfig(t)
+	d, client := newOfflineDevice(t, "dev1")
+	setTopic := getTopic(d.Name, PropertySetTopicTemplate)
+	for _, payload := range []string{`not json`, `{"id":"1"}`} {
+		client.deliver(setTopic, 1, []byte(payload))
+		if recs := client.takePublished(); len(recs) != 0 {
+			t.Errorf("%s: expected no reply, got %v", payload, recs)
+		}
+	}
+}
+
+func TestApplyPropertiesConcurrent(t *testing.T) {
+	useTestConfig(t)
+	d, _ := newOfflineDevice(t, "dev1")
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			d.applyProperties(map[string]interface{}{PropRelay: 1})
+		}()
+		go func() {
+			defer wg.Done()
+			d.applyProperties(map[string]interface{}{PropInterval: 30})
+		}()
+	}
+	wg.Wait()
+	if p := d.propertySnapshot(); p.Relay != 1 || p.Interval != 30 {
+		t.Errorf("relay=%d interval=%d after concurrent sets, want 1/30", p.Relay, p.Interval)
+	}
+}
+
+func TestHandlePropertyGetReply(t *testing.T) {
+	useTestConfig(t)
+	d, client := newOfflineDevice(t, "dev1")
+	d.Relay = 1
+	client.deliver(getTopic(d.Name, PropertyGetTopicTemplate), 1, []byte(`{"id":"7","version":"1.0","params":["relay"]}`))
+
+	replies := published(t, client.takePublished(), getTopic(d.Name, PropertyGetReplyTopicTemplate))
+	if len(replies) != 1 {
+		t.Fatalf("got %d get replies, want 1", len(replies))
+	}
+	r := replies[0]
+	if r["id"] != "7" || r["code"] != float64(200) {
+		t.Errorf("reply = %v", r)
+	}
+	data, ok := r["data"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("reply data = %v", r["data"])
+	}
+	if data[PropRelay] != float64(1) {
+		t.Errorf("data.relay = %v, want 1 (unwrapped)", data[PropRelay])
+	}
+}
+
+func TestHandlePropertyGetIdentifiers(t *testing.T) {
+	useTestConfig(t)
+	d, client := newOfflineDevice(t, "dev1")
+	get := func(params string) map[string]interface{} {
+		t.Helper()
+		client.deliver(getTopic(d.Name, PropertyGetTopicTemplate), 1, []byte(`{"id":"1","version":"1.0","params":`+params+`}`))
+		replies := published(t, client.takePublished(), getTopic(d.Name, PropertyGetReplyTopicTemplate))
+		if len(replies) != 1 {
+			t.Fatalf("params %s: got %d get replies, want 1", params, len(replies))
+		}
+		return replies[0]
+	}
+
+	// 只回复请求的标识符，静态属性与缓存 (属性上报) 一致
+	r := get(`["imsi","relay"]`)
+	data, _ := r["data"].(map[string]interface{})
+	if r["code"] != float64(200) || len(data) != 2 {
+		t.Fatalf("reply = %v", r)
+	}
+	if data[PropImsi] != d.StaticProps[PropImsi].Value {
+		t.Errorf("imsi = %v, want cached %v", data[PropImsi], d.StaticProps[PropImsi].Value)
+	}
+	first, _ := get(`["cell_info"]`)["data"].(map[string]interface{})
+	second, _ := get(`["cell_info"]`)["data"].(map[string]interface{})
+	if first[PropCellInfo] == nil || !reflect.DeepEqual(first, second) {
+		t.Errorf("cell_info changed between gets: %v, %v", first, second)
+	}
+
+	// 空列表回复全部属性
+	if data, _ := get(`[]`)["data"].(map[string]interface{}); len(data) != len(staticPropertyIDs)+len(dynamicPropertyIDs) {
+		t.Errorf("get all returned %d properties", len(data))
+	}
+
+	// 未知标识符或格式错误: 400
+	for _, params := range []string{`["relay","nope"]`, `{"relay":1}`, `[1]`} {
+		if r := get(params); r["code"] != float64(400) || r["data"] != nil {
+			t.Errorf("params %s: reply = %v", params, r)
+		}
+	}
+	if r := get(`["relay","nope"]`); !strings.Contains(r["msg"].(string), "nope") {
+		t.Errorf("unknown identifier not reported: %v", r["msg"])
+	}
+}
+
+func TestHandlePostReplies(t *testing.T) {
+	useTestConfig(t)
+	templates := []string{PropertyPostReplyTopicTemplate, EventPostReplyTopicTemplate, PackPostReplyTopicTemplate}
+	for _, tmpl := range templates {
+		d, client := newOfflineDevice(t, "dev1")
+		topic := getTopic(d.Name, tmpl)
+
+		client.deliver(topic, 1, []byte(`{"id":"1","code":200,"msg":"success"}`))
+		if st := d.Stats.Snapshot(); st.LastReplyCode != 200 || len(st.Errors) != 0 {
+			t.Errorf("%s: after 200 reply stats = %+v", topic, st)
+		}
+
+		client.deliver(topic, 1, []byte(`{"id":"2","code":2409,"msg":"bad"}`))
+		st := d.Stats.Snapshot()
+		if st.LastReplyCode != 2409 || len(st.Errors) != 1 {
+			t.Errorf("%s: after rejected reply stats = %+v", topic, st)
+		}
+		if recs := client.takePublished(); len(recs) != 0 {
+			t.Errorf("%s: replies must not trigger publishes, got %v", topic, recs)
+		}
+	}
+}
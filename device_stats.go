@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ======================================================================
+// 设备运行统计 (供仪表盘展示)
+// ======================================================================
+
+// maxRecentErrors 每台设备保留的最近错误条数
+const maxRecentErrors = 5
+
+// DeviceError 一条最近错误
+type DeviceError struct {
+	Time time.Time
+	Msg  string
+}
+
+// DeviceStats 设备收发统计
+type DeviceStats struct {
+	mu            sync.Mutex
+	up, down      int64
+	lastPost      time.Time
+	lastReplyCode int
+	lastReplyTime time.Time
+	reasonCodes   map[string]int64
+	throttled     int64 // 因限流排队的上行消息数
+	rateDropped   int64 // 因限流丢弃的上行消息数
+	errors        []DeviceError
+}
+
+// StatsSnapshot 统计快照
+type StatsSnapshot struct {
+	Up, Down      int64
+	LastPost      time.Time
+	LastReplyCode int
+	LastReplyTime time.Time
+	ReasonCodes   map[string]int64 // MQTT 5 非成功原因码计数 ("PUBACK 0x97" -> 次数)
+	Throttled     int64            // 因限流排队的上行消息数
+	RateDropped   int64            // 因限流丢弃的上行消息数
+	Errors        []DeviceError
+}
+
+// countTraffic 累计一条上行/下行消息
+func (s *DeviceStats) countTraffic(dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if dir == DirectionUp {
+		s.up++
+	} else {
+		s.down++
+	}
+}
+
+// notePost 记录一次成功的属性/事件上报
+func (s *DeviceStats) notePost(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastPost = t
+}
+
+// noteReply 记录平台回复的 code
+func (s *DeviceStats) noteReply(code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastReplyCode = code
+	s.lastReplyTime = time.Now()
+}
+
+// noteReasonCode 累计一次 MQTT 5 非成功原因码
+func (s *DeviceStats) noteReasonCode(packet string, code byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reasonCodes == nil {
+		s.reasonCodes = make(map[string]int64)
+	}
+	s.reasonCodes[fmt.Sprintf("%s 0x%02X", packet, code)]++
+}
+
+// noteThrottled 累计一条因限流排队的上行消息
+func (s *DeviceStats) noteThrottled() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.throttled++
+}
+
+// noteRateDropped 累计一条因限流丢弃的上行消息
+func (s *DeviceStats) noteRateDropped() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateDropped++
+}
+
+// noteError 记录一条错误 (只保留最近 maxRecentErrors 条)
+func (s *DeviceStats) noteError(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors = append(s.errors, DeviceError{Time: time.Now(), Msg: msg})
+	if len(s.errors) > maxRecentErrors {
+		s.errors = s.errors[len(s.errors)-maxRecentErrors:]
+	}
+}
+
+// Snapshot 返回统计快照
+func (s *DeviceStats) Snapshot() StatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var reasonCodes map[string]int64
+	if len(s.reasonCodes) > 0 {
+		reasonCodes = make(map[string]int64, len(s.reasonCodes))
+		for k, v := range s.reasonCodes {
+			reasonCodes[k] = v
+		}
+	}
+	return StatsSnapshot{
+		Up:            s.up,
+		Down:          s.down,
+		LastPost:      s.lastPost,
+		LastReplyCode: s.lastReplyCode,
+		LastReplyTime: s.lastReplyTime,
+		ReasonCodes:   reasonCodes,
+		Throttled:     s.throttled,
+		RateDropped:   s.rateDropped,
+		Errors:        append([]DeviceError(nil), s.errors...),
+	}
+}
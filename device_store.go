@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"qsiot_server/onenet/device"
+)
+
+// ======================================================================
+// 设备状态持久化
+//
+// 真实设备掉电后仍记得可写属性 (OUT/relay/interval 等)。每台设备在
+// StateDir 下保存一个 JSON 文件:
+//
+//	{
+//	  "properties": {"OUT": 1, "interval": 30, "relay": 0},
+//	  "last_report_ids": {"property_post": "1792338045059", "event_post": "1792338045170"},
+//	  "updated_at": "2026-10-18T15:40:45+08:00"
+//	}
+//
+// initDeviceState 启动时加载，property/set 成功及上报成功后写回。
+// 消息 ID 在重启后保持单调递增 (不小于已保存的最大 ID)。
+// ======================================================================
+
+// 持久化配置 (可通过命令行参数覆盖)
+var (
+	StateDir   = "state" // 状态文件目录，为空时不持久化
+	FreshStart = false   // 忽略已保存的状态，以默认值启动
+)
+
+// persistedState 设备状态文件内容
+type persistedState struct {
+	Properties    map[string]interface{} `json:"properties"`
+	LastReportIDs map[device.Kind]string `json:"last_report_ids,omitempty"`
+	UpdatedAt     time.Time              `json:"updated_at"`
+}
+
+func stateFilePath(deviceName string) string {
+	return filepath.Join(StateDir, deviceName+".json")
+}
+
+// loadPersistedState 读取设备状态文件，文件不存在时返回 nil, nil
+func loadPersistedState(deviceName string) (*persistedState, error) {
+	data, err := os.ReadFile(stateFilePath(deviceName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st persistedState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", stateFilePath(deviceName), err)
+	}
+	return &st, nil
+}
+
+// restoreState 把已保存的可写属性与上报 ID 应用到设备 (逐项校验，非法值跳过)
+func (d *Device) restoreState() {
+	if d.noPersist || StateDir == "" || FreshStart {
+		return
+	}
+	st, err := loadPersistedState(d.Name)
+	if err != nil {
+		log.Printf("[%s] 读取持久化状态失败，使用默认值: %v", d.Name, err)
+		return
+	}
+	if st == nil {
+		return
+	}
+
+	for _, id := range writablePropertyIDs {
+		raw, ok := st.Properties[id]
+		if !ok {
+			continue
+		}
+		if err := d.setProperty(id, raw); err != nil {
+			log.Printf("[%s] 忽略已保存的属性 %s: %v", d.Name, id, err)
+		}
+	}
+	d.lastReportIDs = make(map[device.Kind]string, len(st.LastReportIDs))
+	for kind, id := range st.LastReportIDs {
+		d.lastReportIDs[kind] = id
+		if n, err := strconv.ParseInt(id, 10, 64); err == nil && n > d.lastMsgID {
+			d.lastMsgID = n
+		}
+	}
+	log.Printf("[%s] 💾 已恢复持久化状态 (OUT=%d, relay=%d, interval=%d)", d.Name, d.OUT, d.Relay, d.Interval)
+}
+
+// saveState 将可写属性与最近上报 ID 写入状态文件 (先写临时文件再改名，避免写坏)
+func (d *Device) saveState() error {
+	if d.noPersist || StateDir == "" {
+		return nil
+	}
+	d.persistMu.Lock()
+	defer d.persistMu.Unlock()
+
+	st := persistedState{
+		Properties:    make(map[string]interface{}, len(writablePropertyIDs)),
+		LastReportIDs: make(map[device.Kind]string, len(d.lastReportIDs)),
+		UpdatedAt:     time.Now(),
+	}
+	for _, id := range writablePropertyIDs {
+		if v, ok := d.getProperty(id); ok {
+			st.Properties[id] = v
+		}
+	}
+	for kind, id := range d.lastReportIDs {
+		st.LastReportIDs[kind] = id
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(StateDir, 0o755); err != nil {
+		return err
+	}
+	path := stateFilePath(d.Name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// persist 保存状态，失败时仅记录日志
+func (d *Device) persist() {
+	if err := d.saveState(); err != nil {
+		log.Printf("[%s] 保存持久化状态失败: %v", d.Name, err)
+	}
+}
+
+// rememberReportID 记录某类上报最近一次成功发布的消息 ID 并写回状态文件
+func (d *Device) rememberReportID(kind device.Kind, id string) {
+	d.persistMu.Lock()
+	if d.lastReportIDs == nil {
+		d.lastReportIDs = make(map[device.Kind]string)
+	}
+	d.lastReportIDs[kind] = id
+	d.persistMu.Unlock()
+	d.persist()
+}
+
+// nextMessageID 返回不小于 ms 且大于上一次 ID 的消息 ID (跨重启单调递增)
+func (d *Device) nextMessageID(ms int64) int64 {
+	for {
+		last := atomic.LoadInt64(&d.lastMsgID)
+		id := ms
+		if id <= last {
+			id = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&d.lastMsgID, last, id) {
+			return id
+		}
+	}
+}
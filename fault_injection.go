@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"qsiot_server/onenet/device"
+)
+
+// ======================================================================
+// 故障注入
+//
+// 作为设备 Client 的发布中间件 (Device.injectFaults) 按概率注入故障，用于加固云端消费者:
+//   drop            丢弃本次发布
+//   delay           延迟 delay_min ~ delay_max 后再发布 (异步)
+//   duplicate       以相同 id 重复发布一次
+//   out_of_range    将 params 中的一个数值改为超出物模型取值范围
+//   wrong_type      将 params 中的一个取值改为错误类型 (字符串)
+//   truncate        截断 JSON 载荷
+//   skip_set_reply  不发送 property/set_reply
+//
+// 配置文件 (-faults faults.json):
+//
+//	{
+//	  "default": {"drop": 0.05},
+//	  "devices": {"866560088910415": {"delay": 0.2, "delay_min": "500ms", "delay_max": "3s"}}
+//	}
+// ======================================================================
+
+// FaultProfile 单个设备的故障注入概率 (0~1)
+type FaultProfile struct {
+	Drop         float64  `json:"drop"`
+	Delay        float64  `json:"delay"`
+	DelayMin     Duration `json:"delay_min"`
+	DelayMax     Duration `json:"delay_max"`
+	Duplicate    float64  `json:"duplicate"`
+	OutOfRange   float64  `json:"out_of_range"`
+	WrongType    float64  `json:"wrong_type"`
+	Truncate     float64  `json:"truncate"`
+	SkipSetReply float64  `json:"skip_set_reply"`
+}
+
+// FaultConfig 故障注入配置文件
+type FaultConfig struct {
+	Default *FaultProfile            `json:"default"`
+	Devices map[string]*FaultProfile `json:"devices"`
+}
+
+// faultConfig 全局故障配置 (-faults 启用)，为 nil 时不注入
+var faultConfig *FaultConfig
+
+// Duration 支持 "500ms" 形式的 JSON 时长
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var ms int64
+		if err := json.Unmarshal(b, &ms); err != nil {
+			return fmt.Errorf("duration must be a string like \"500ms\" or milliseconds")
+		}
+		*d = Duration(time.Duration(ms) * time.Millisecond)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadFaultConfig 读取故障注入配置文件
+func LoadFaultConfig(path string) (*FaultConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg FaultConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// profileFor 返回设备的故障配置 (设备级配置优先于 default)
+func (c *FaultConfig) profileFor(deviceName string) *FaultProfile {
+	if c == nil {
+		return nil
+	}
+	if p, ok := c.Devices[deviceName]; ok {
+		return p
+	}
+	return c.Default
+}
+
+func chance(p float64) bool {
+	return p > 0 && rand.Float64() < p
+}
+
+// faultPlan 一次发布要施加的故障
+type faultPlan struct {
+	drop      bool
+	delay     time.Duration
+	duplicate bool
+	payload   []byte
+	applied   []string
+}
+
+// plan 根据配置为一次发布抽取故障，并返回 (可能被篡改的) 载荷
+func (p *FaultProfile) plan(kind device.Kind, payload []byte) faultPlan {
+	fp := faultPlan{payload: payload}
+	if p == nil {
+		return fp
+	}
+	if kind == device.KindSetReply && chance(p.SkipSetReply) {
+		fp.drop = true
+		fp.applied = append(fp.applied, "skip_set_reply")
+		return fp
+	}
+	if chance(p.Drop) {
+		fp.drop = true
+		fp.applied = append(fp.applied, "drop")
+		return fp
+	}
+	if kind == device.KindPropertyPost || kind == device.KindEventPost {
+		if chance(p.OutOfRange) {
+			if out, id, ok := mutateParam(fp.payload, outOfRangeValue); ok {
+				fp.payload = out
+				fp.applied = append(fp.applied, "out_of_range:"+id)
+			}
+		}
+		if chance(p.WrongType) {
+			if out, id, ok := mutateParam(fp.payload, wrongTypeValue); ok {
+				fp.payload = out
+				fp.applied = append(fp.applied, "wrong_type:"+id)
+			}
+		}
+	}
+	if kind != device.KindRaw && chance(p.Truncate) && len(fp.payload) > 1 {
+		fp.payload = fp.payload[:1+rand.Intn(len(fp.payload)-1)]
+		fp.applied = append(fp.applied, "truncate")
+	}
+	if chance(p.Duplicate) {
+		fp.duplicate = true
+		fp.applied = append(fp.applied, "duplicate")
+	}
+	if chance(p.Delay) {
+		min, max := time.Duration(p.DelayMin), time.Duration(p.DelayMax)
+		if max < min {
+			max = min
+		}
+		fp.delay = min
+		if max > min {
+			fp.delay += time.Duration(rand.Int63n(int64(max - min)))
+		}
+		fp.applied = append(fp.applied, "delay:"+fp.delay.String())
+	}
+	return fp
+}
+
+// mutateParam 在载荷 params 下随机挑选一个数值叶子节点，用 mutate 替换其取值
+func mutateParam(payload []byte, mutate func(id string, v float64) interface{}) ([]byte, string, bool) {
+	var msg map[string]interface{}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return payload, "", false
+	}
+	params, ok := msg["params"]
+	if !ok {
+		return payload, "", false
+	}
+
+	// 收集所有数值叶子 (记录其父容器与键，便于原地修改)
+	type leaf struct {
+		id     string
+		parent map[string]interface{}
+		key    string
+		value  float64
+	}
+	var leaves []leaf
+	var walk func(id string, node interface{})
+	walk = func(id string, node interface{}) {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(n))
+			for k := range n {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				childID := id
+				if k != "value" && k != "time" {
+					childID = k
+				}
+				if f, ok := n[k].(float64); ok && k != "time" {
+					leaves = append(leaves, leaf{childID, n, k, f})
+					continue
+				}
+				walk(childID, n[k])
+			}
+		case []interface{}:
+			for _, e := range n {
+				walk(id, e)
+			}
+		}
+	}
+	walk("", params)
+	if len(leaves) == 0 {
+		return payload, "", false
+	}
+
+	l := leaves[rand.Intn(len(leaves))]
+	l.parent[l.key] = mutate(l.id, l.value)
+	out, err := json.Marshal(msg)
+	if err != nil {
+		return payload, "", false
+	}
+	return out, l.id, true
+}
+
+// outOfRangeValue 返回超出物模型 max 的取值 (未知标识符时超出 int32 范围)
+func outOfRangeValue(id string, v float64) interface{} {
+	if p, ok := thingModelSpec.Property(id); ok {
+		if max, err := strconv.ParseFloat(p.DataType.Max, 64); err == nil {
+			return max + 1
+		}
+	}
+	for _, e := range thingModelSpec.Events {
+		if f, ok := e.Param(id); ok {
+			if max, err := strconv.ParseFloat(f.DataType.Max, 64); err == nil {
+				return max + 1
+			}
+		}
+	}
+	return float64(1 << 31)
+}
+
+// wrongTypeValue 把数值替换为字符串
+func wrongTypeValue(id string, v float64) interface{} {
+	return strconv.FormatFloat(v, 'f', -1, 64) + "x"
+}
+
+// injectFaults 发布中间件: 按设备的故障配置丢弃、延迟、重复或篡改上行消息 (丢弃或延迟时立即返回 nil)
+func (d *Device) injectFaults(next device.PublishFunc) device.PublishFunc {
+	return func(kind device.Kind, topic string, payload []byte) error {
+		fp := d.Faults.plan(kind, payload)
+		if len(fp.applied) > 0 {
+			log.Printf("[%s] 💥 故障注入 (%s): %v", d.Name, kind, fp.applied)
+		}
+		if fp.drop {
+			return nil
+		}
+
+		deliver := func() error {
+			if err := next(kind, topic, fp.payload); err != nil {
+				return err
+			}
+			if fp.duplicate {
+				return next(kind, topic, fp.payload)
+			}
+			return nil
+		}
+
+		if fp.delay > 0 {
+			state, gen := d.connEpoch()
+			go func() {
+				timer := time.NewTimer(fp.delay)
+				defer timer.Stop()
+				select {
+				case <-timer.C:
+				case <-d.done:
+					log.Printf("[%s] 💥 设备已停止，放弃延迟的 %s 消息", d.Name, kind)
+					return
+				}
+				// 延迟期间连接断开或已重连: 不在其他连接上补发
+				if s, g := d.connEpoch(); s != state || g != gen {
+					log.Printf("[%s] 💥 连接已断开，放弃延迟的 %s 消息", d.Name, kind)
+					return
+				}
+				if err := deliver(); err != nil {
+					log.Printf("[%s] 延迟发布失败 %s: %v", d.Name, topic, err)
+				}
+			}()
+			return nil
+		}
+		return deliver()
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"qsiot_server/onenet/device"
+)
+
+// 延迟发布在设备停止或连接变化后放弃，不在新连接上补发
+func TestInjectFaultsDelayAbandoned(t *testing.T) {
+	profile := &FaultProfile{Delay: 1, DelayMin: Duration(100 * time.Millisecond)}
+	d := &Device{Name: "fault-delay", Faults: profile, state: StateOnline, done: make(chan struct{})}
+	var mu sync.Mutex
+	sent := 0
+	publish := d.injectFaults(func(device.Kind, string, []byte) error {
+		mu.Lock()
+		sent++
+		mu.Unlock()
+		return nil
+	})
+	sentCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return sent
+	}
+
+	// 连接未变化: 延迟后正常发出
+	if err := publish(device.KindPropertyPost, "t", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "delayed publish sent", func() bool { return sentCount() == 1 })
+
+	// 延迟期间断线并重连
+	if err := publish(device.KindPropertyPost, "t", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	d.setState(StateOffline, 0, nil)
+	d.setState(StateOnline, 0, nil)
+
+	// 延迟期间设备停止
+	if err := publish(device.KindPropertyPost, "t", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	d.setState(StateStopped, 0, nil)
+
+	time.Sleep(300 * time.Millisecond)
+	if n := sentCount(); n != 1 {
+		t.Errorf("sent %d messages, want 1 (delayed ones abandoned)", n)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"qsiot_server/onenet/device"
+)
+
+// ======================================================================
+// HTTP 接入 (低功耗设备)
+//
+// -transport http 时设备不建立 MQTT 连接: 上报与回复经 onenet/device 的
+// HTTPTransport 逐条 POST (Token 鉴权与 MQTT 相同)，平台回复随响应返回，
+// 下行命令 (property/set、property/get) 按 HTTPPollInterval 周期拉取。
+// 上层的上报、命令处理、故障注入与录制逻辑与 MQTT 接入完全一致。
+// ======================================================================
+
+// 接入方式配置 (可通过命令行参数覆盖)
+var (
+	DeviceTransport  = "mqtt"          // mqtt | http
+	HTTPURL          = ""              // HTTP 接入地址
+	HTTPPollInterval = 5 * time.Second // 下行命令拉取间隔
+)
+
+// checkTransport 校验接入方式配置
+func checkTransport() error {
+	switch DeviceTransport {
+	case "mqtt":
+		return nil
+	case "http":
+		if HTTPURL == "" {
+			return fmt.Errorf("-transport http requires -http-url")
+		}
+		if HTTPPollInterval <= 0 {
+			return fmt.Errorf("-http-poll must be positive")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown transport: %s (want mqtt or http)", DeviceTransport)
+}
+
+// runHTTP 以 HTTP 接入运行设备: 登记下行处理、启动模拟并周期拉取命令，直到 stop 关闭
+func (d *Device) runHTTP(stop <-chan struct{}) {
+	tr := device.NewHTTPTransport(HTTPURL, connectConfig(d.Name, deviceAccessKey(d.Name)))
+	d.attachTransport(tr)
+	if err := d.subscribeForCommands(); err != nil {
+		log.Printf("[%s] 命令订阅失败: %v. 设备退出。", d.Name, err)
+		d.setState(StateFailed, 0, err)
+		return
+	}
+	log.Printf("[%s] 🌐 使用 HTTP 接入: %s (命令拉取间隔 %v)", d.Name, HTTPURL, HTTPPollInterval)
+	d.setState(StateOnline, 0, nil)
+	go d.startDeviceSimulation()
+
+	ticker := time.NewTicker(HTTPPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			d.setState(StateStopped, 0, nil)
+			return
+		case <-ticker.C:
+			n, err := tr.Poll()
+			if err != nil {
+				log.Printf("[%s] 拉取下行命令失败: %v", d.Name, err)
+				d.Stats.noteError(fmt.Sprintf("拉取下行命令失败: %v", err))
+			} else if n > 0 {
+				log.Printf("[%s] ⬇️ 拉取到 %d 条下行命令", d.Name, n)
+			}
+		}
+	}
+}
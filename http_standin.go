@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"qsiot_server/onenet/auth"
+	"qsiot_server/onenet/device"
+	"qsiot_server/onenet/topics"
+)
+
+// ======================================================================
+// OneNET HTTP 接入平台模拟
+//
+// 与 MQTT 平台模拟 (onenet_standin.go) 相同的回复规则，按 onenet/device 的 HTTP 接入约定提供:
+//   POST /thing/property/post  -> property/post/reply (按物模型校验)
+//   POST /thing/event/post     -> event/post/reply
+//   POST /thing/pack/post      -> pack/post/reply
+//   POST /thing/ntp/request    -> ntp/response
+//   POST 其他 Topic 后缀        -> 204 (set_reply、get_reply、透传等，仅记录)
+//   GET  /commands             -> 取走该设备排队的下行消息 (Enqueue)
+// 设备由请求头 token 中的 res 识别；key 非空时校验 Token 签名与有效期。
+// ======================================================================
+
+// HTTPStandIn HTTP 接入平台模拟
+type HTTPStandIn struct {
+	key string // 产品 Access Key，为空时不校验签名
+
+	// OnMessage 收到上行消息后调用 (可选，供测试与日志)
+	OnMessage func(deviceName, suffix string, payload []byte)
+
+	mu     sync.Mutex
+	queues map[string][]device.HTTPCommand
+}
+
+// NewHTTPStandIn 创建 HTTP 平台模拟，key 为校验 Token 使用的产品 Access Key
+func NewHTTPStandIn(key string) *HTTPStandIn {
+	return &HTTPStandIn{key: key, queues: make(map[string][]device.HTTPCommand)}
+}
+
+// Enqueue 为设备排队一条下行消息 (suffix 如 topics.PropertySet)，设备下次拉取时取走
+func (s *HTTPStandIn) Enqueue(deviceName, suffix string, payload []byte) {
+	s.mu.Lock()
+	s.queues[deviceName] = append(s.queues[deviceName], device.HTTPCommand{Topic: suffix, Payload: payload})
+	s.mu.Unlock()
+}
+
+func (s *HTTPStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.authenticate(r)
+	if !ok {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+
+	if r.Method == http.MethodGet && path == device.HTTPCommandsPath {
+		s.mu.Lock()
+		cmds := s.queues[name]
+		delete(s.queues, name)
+		s.mu.Unlock()
+		if cmds == nil {
+			cmds = []device.HTTPCommand{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cmds)
+		return
+	}
+	if r.Method != http.MethodPost || !strings.HasPrefix(path, "thing/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.OnMessage != nil {
+		s.OnMessage(name, path, payload)
+	}
+
+	var out []byte
+	switch path {
+	case topics.PropertyPost:
+		out = postReply(payload, validatePropertyPost)
+	case topics.EventPost:
+		out = postReply(payload, validateEventPost)
+	case topics.PackPost:
+		out = postReply(payload, nil)
+	case topics.NtpRequest:
+		out = ntpReply(payload, time.Now())
+	}
+	if out == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// authenticate 从 Token 的 res (products/{pid}/devices/{name}) 识别设备
+func (s *HTTPStandIn) authenticate(r *http.Request) (string, bool) {
+	token := r.Header.Get(device.HTTPTokenHeader)
+	f, err := auth.ParseToken(token)
+	if err == nil && s.key != "" {
+		f, err = auth.Verify(token, s.key, time.Now())
+	}
+	if err != nil {
+		log.Printf("[平台/HTTP] ⛔ 拒绝请求 %s %s: %v", r.Method, r.URL.Path, err)
+		return "", false
+	}
+	parts := strings.Split(f.Res, "/")
+	if len(parts) != 4 || parts[0] != "products" || parts[2] != "devices" || parts[3] == "" {
+		log.Printf("[平台/HTTP] ⛔ 拒绝请求 %s %s: not a device token (res=%s)", r.Method, r.URL.Path, f.Res)
+		return "", false
+	}
+	return parts[3], true
+}
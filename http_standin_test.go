@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"qsiot_server/onenet/topics"
+)
+
+// startHTTPPlatform 启动 HTTP 接入平台模拟，并把设备切换为 HTTP 接入
+func startHTTPPlatform(t *testing.T) *HTTPStandIn {
+	t.Helper()
+	startTestPlatform(t) // 复用测试产品、密钥与 StateDir 配置
+	standIn := NewHTTPStandIn(AccessKey)
+	srv := httptest.NewServer(standIn)
+	t.Cleanup(srv.Close)
+
+	transport, url, poll := DeviceTransport, HTTPURL, HTTPPollInterval
+	DeviceTransport, HTTPURL, HTTPPollInterval = "http", srv.URL, 50*time.Millisecond
+	t.Cleanup(func() { DeviceTransport, HTTPURL, HTTPPollInterval = transport, url, poll })
+	return standIn
+}
+
+func TestDeviceOverHTTP(t *testing.T) {
+	standIn := startHTTPPlatform(t)
+	replies := make(chan map[string]interface{}, 16)
+	standIn.OnMessage = func(deviceName, suffix string, payload []byte) {
+		var m map[string]interface{}
+		if suffix == topics.PropertySetReply && json.Unmarshal(payload, &m) == nil {
+			replies <- m
+		}
+	}
+
+	const name = "http-dev"
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go runDeviceWithStop(name, &wg, stop)
+	stopped := false
+	defer func() {
+		if !stopped {
+			close(stop)
+			wg.Wait()
+		}
+	}()
+
+	waitFor(t, name+" online", func() bool {
+		d, ok := lookupDevice(name)
+		return ok && d.State() == StateOnline
+	})
+	// 属性上报的回复随 HTTP 响应返回
+	waitFor(t, name+" property post acknowledged", func() bool {
+		d, _ := lookupDevice(name)
+		return d.Stats.Snapshot().LastReplyCode == 200
+	})
+
+	// 下行命令经拉取送达，set_reply 以 POST 上行
+	standIn.Enqueue(name, topics.PropertySet, []byte(`{"id":"http-set","version":"1.0","params":{"relay":1}}`))
+	select {
+	case r := <-replies:
+		if r["id"] != "http-set" || r["code"] != float64(200) {
+			t.Errorf("set reply = %v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no property set reply over HTTP")
+	}
+
+	close(stop)
+	wg.Wait()
+	stopped = true
+	d, _ := lookupDevice(name)
+	if d.State() != StateStopped {
+		t.Errorf("state after stop = %s", d.State())
+	}
+	if d.Relay != 1 {
+		t.Errorf("relay = %d, want 1", d.Relay)
+	}
+}
+
+func TestHTTPStandInRejectsBadToken(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPStandIn("KuF3NT/jUBJ62LNBB/A8XZA9CqS3Cu79B/ABmfA1UCw="))
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL + "/commands")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 401 {
+		t.Errorf("status without token = %d, want 401", resp.StatusCode)
+	}
+}
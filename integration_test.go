@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"qsiot_server/onenet/device"
+	"qsiot_server/onenet/topics"
+)
+
+// cloudReply 设备发给平台的一条回复 (set_reply/get_reply)
+type cloudReply struct {
+	device, action string
+	payload        map[string]interface{}
+}
+
+// startTestPlatform 启动进程内 Broker + OneNET 平台模拟，并把设备连接配置指向它
+func startTestPlatform(t *testing.T) (*LocalBroker, *OneNETStandIn, <-chan cloudReply) {
+	t.Helper()
+	b := NewLocalBroker()
+	if err := b.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	standIn := NewOneNETStandIn(b)
+
+	replies := make(chan cloudReply, 64)
+	onPublish := b.OnPublish
+	b.OnPublish = func(clientID, topic string, payload []byte) {
+		onPublish(clientID, topic, payload)
+		_, device, action, ok := topics.Parse(topic)
+		if !ok || !strings.HasSuffix(action, "_reply") {
+			return
+		}
+		var m map[string]interface{}
+		if json.Unmarshal(payload, &m) == nil {
+			replies <- cloudReply{device, action, m}
+		}
+	}
+
+	pid, key, url, stateDir := ProductID, AccessKey, BrokerURL, StateDir
+	ProductID, AccessKey, BrokerURL, StateDir = testProductID, "KuF3NT/jUBJ62LNBB/A8XZA9CqS3Cu79B/ABmfA1UCw=", b.URL(), t.TempDir()
+	t.Cleanup(func() { ProductID, AccessKey, BrokerURL, StateDir = pid, key, url, stateDir })
+	return b, standIn, replies
+}
+
+// waitReply 等待设备对某条命令 (按 id 匹配) 的回复
+func waitReply(t *testing.T, replies <-chan cloudReply, device, action, id string) map[string]interface{} {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case r := <-replies:
+			if r.device == device && r.action == action && r.payload["id"] == id {
+				return r.payload
+			}
+		case <-timeout:
+			t.Fatalf("[%s] no %s for id %s", device, action, id)
+		}
+	}
+}
+
+// waitFor 轮询等待条件成立
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestDevicesAgainstLocalBroker(t *testing.T) {
+	b, standIn, replies := startTestPlatform(t)
+	names := []string{"itest-dev-1", "itest-dev-2"}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go runDeviceWithStop(name, &wg, stop)
+	}
+	stopped := false
+	stopAll := func() {
+		if !stopped {
+			stopped = true
+			close(stop)
+			wg.Wait()
+		}
+	}
+	defer stopAll()
+
+	for _, name := range names {
+		name := name
+		// 连接 + 订阅完成后进入 online；全量上报得到平台 200 回复
+		waitFor(t, name+" online", func() bool {
+			d, ok := lookupDevice(name)
+			return ok && d.State() == StateOnline
+		})
+		waitFor(t, name+" property post acknowledged", func() bool {
+			d, _ := lookupDevice(name)
+			return d.Stats.Snapshot().LastReplyCode == 200
+		})
+		if s, ok := standIn.Session(name); !ok || !s.Online || s.Messages == 0 {
+			t.Errorf("%s: platform session = %+v", name, s)
+		}
+	}
+
+	for i, name := range names {
+		d, _ := lookupDevice(name)
+		setTopic := getTopic(name, PropertySetTopicTemplate)
+
+		// 合法设置: 回复 200 并生效
+		b.Publish(setTopic, []byte(`{"id":"set-ok","version":"1.0","params":{"relay":1,"interval":`+
+			[]string{"15", "20"}[i]+`}}`), 1, false)
+		if r := waitReply(t, replies, name, topics.PropertySetReply, "set-ok"); r["code"] != float64(200) {
+			t.Errorf("%s: set reply = %v", name, r)
+		}
+
+		// 非法设置: 回复 400，属性保持不变
+		b.Publish(setTopic, []byte(`{"id":"set-bad","version":"1.0","params":{"relay":2}}`), 1, false)
+		if r := waitReply(t, replies, name, topics.PropertySetReply, "set-bad"); r["code"] != float64(400) {
+			t.Errorf("%s: invalid set reply = %v", name, r)
+		}
+
+		// 查询: 返回设置后的值
+		b.Publish(getTopic(name, PropertyGetTopicTemplate), []byte(`{"id":"get-1","version":"1.0","params":["relay","interval"]}`), 1, false)
+		r := waitReply(t, replies, name, topics.PropertyGetReply, "get-1")
+		data, _ := r["data"].(map[string]interface{})
+		if r["code"] != float64(200) || data["relay"] != float64(1) {
+			t.Errorf("%s: get reply = %v", name, r)
+		}
+		if want := []int32{15, 20}[i]; d.Relay != 1 || d.Interval != want {
+			t.Errorf("%s: relay=%d interval=%d, want 1/%d", name, d.Relay, d.Interval, want)
+		}
+	}
+
+	stopAll()
+	for _, name := range names {
+		if d, _ := lookupDevice(name); d.State() != StateStopped {
+			t.Errorf("%s: state after stop = %s", name, d.State())
+		}
+		// 设置已持久化
+		st, err := loadPersistedState(name)
+		if err != nil || st == nil || st.Properties["relay"] != float64(1) {
+			t.Errorf("%s: persisted state = %+v, %v", name, st, err)
+		}
+	}
+	waitFor(t, "platform sees devices offline", func() bool {
+		for _, name := range names {
+			if s, _ := standIn.Session(name); s.Online {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// 同 ClientID 重新连接顶替旧会话: 旧连接结束不应把设备标记为离线
+func TestSessionTakeoverKeepsOnline(t *testing.T) {
+	_, standIn, _ := startTestPlatform(t)
+	const name = "takeover-dev"
+	connect := func() *device.MQTT5Transport {
+		t.Helper()
+		tr := device.NewMQTT5Transport(connectConfig(name, AccessKey), device.MQTT5Options{})
+		if err := tr.Connect(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return tr
+	}
+	first := connect()
+	defer first.Disconnect()
+	second := connect()
+	defer second.Disconnect()
+
+	waitFor(t, "old connection dropped", func() bool { return !first.IsConnected() })
+	time.Sleep(100 * time.Millisecond) // 旧连接的读循环退出
+	if ds, _ := standIn.Session(name); !ds.Online || ds.Disconnects != 0 {
+		t.Errorf("session after takeover = %+v, want online with no disconnects", ds)
+	}
+
+	second.Disconnect()
+	waitFor(t, "offline after disconnect", func() bool {
+		ds, _ := standIn.Session(name)
+		return !ds.Online
+	})
+}
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"$sys/pid/dev/thing/property/set", "$sys/pid/dev/thing/property/set", true},
+		{"$sys/pid/+/thing/property/set", "$sys/pid/dev/thing/property/set", true},
+		{"$sys/pid/dev/#", "$sys/pid/dev/thing/property/set", true},
+		{"$sys/pid/dev/+", "$sys/pid/dev/thing/property/set", false},
+		{"a/#", "a", true},
+		{"#", "a/b", true},
+		{"+/b", "a/b", true},
+		// 首级通配符不匹配 $ 开头的 Topic
+		{"#", "$sys/pid/dev/thing/property/post", false},
+		{"+/pid/dev/thing/property/post", "$sys/pid/dev/thing/property/post", false},
+		{"+/#", "$SYS/broker/uptime", false},
+	}
+	for _, tt := range tests {
+		if got := topicMatches(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("topicMatches(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,937 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ======================================================================
+// 本地 MQTT Broker (MQTT 3.1.1 / 5 最小实现)
+//
+// 用于在没有云平台的情况下测试设备: 支持 CONNECT/PUBLISH (QoS 0/1/2)/
+// SUBSCRIBE/UNSUBSCRIBE/PINGREQ/DISCONNECT、通配符订阅、保留消息与
+// 同 ClientID 会话顶替。OneNET 平台行为由 onenet_standin.go 通过钩子模拟。
+//
+// MQTT 5 客户端额外支持: Topic 别名 (上限 brokerTopicAliasMaximum)、消息属性
+// (过期时间、响应 Topic、关联数据、用户属性) 的转发，以及 CONNACK/SUBACK/DISCONNECT
+// 中的原因码 (踢下线 0x98、会话顶替 0x8E、Broker 关闭 0x8B)。会话不跨连接保留。
+// ======================================================================
+
+// MQTT 控制报文类型
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetPubrec      = 5
+	packetPubrel      = 6
+	packetPubcomp     = 7
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetUnsubscribe = 10
+	packetUnsuback    = 11
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+)
+
+// CONNACK 返回码
+const (
+	connackAccepted          = 0
+	connackBadProtocol       = 1
+	connackBadCredentials    = 4
+	connackNotAuthorized     = 5
+	subackFailure            = 0x80
+	maxRemainingLength       = 268435455
+	brokerDefaultWriteWindow = 10 * time.Second
+)
+
+// MQTT 5 原因码
+const (
+	reasonSuccess            = 0x00
+	reasonNoSubscription     = 0x11
+	reasonMalformedPacket    = 0x81
+	reasonProtocolError      = 0x82
+	reasonBadCredentials     = 0x86
+	reasonNotAuthorized      = 0x87
+	reasonServerShuttingDown = 0x8B
+	reasonSessionTakenOver   = 0x8E
+	reasonTopicAliasInvalid  = 0x94
+	reasonAdministrative     = 0x98
+)
+
+// MQTT 5 属性标识符
+const (
+	propPayloadFormat     = 0x01
+	propMessageExpiry     = 0x02
+	propContentType       = 0x03
+	propResponseTopic     = 0x08
+	propCorrelationData   = 0x09
+	propSubscriptionID    = 0x0B
+	propSessionExpiry     = 0x11
+	propReasonString      = 0x1F
+	propTopicAliasMaximum = 0x22
+	propTopicAlias        = 0x23
+	propUserProperty      = 0x26
+)
+
+// brokerTopicAliasMaximum CONNACK 中告知 MQTT 5 客户端的 Topic 别名上限
+const brokerTopicAliasMaximum = 16
+
+// UserProperty MQTT 5 用户属性
+type UserProperty struct {
+	Key, Value string
+}
+
+// MessageProperties MQTT 5 消息属性，由 Broker 转发给 MQTT 5 订阅者 (MQTT 3.1.1 订阅者收不到)
+type MessageProperties struct {
+	MessageExpiry   uint32 // 秒，0 表示不过期
+	ContentType     string
+	ResponseTopic   string
+	CorrelationData []byte
+	User            []UserProperty
+}
+
+func (m *MessageProperties) empty() bool {
+	return m == nil || (m.MessageExpiry == 0 && m.ContentType == "" && m.ResponseTopic == "" &&
+		len(m.CorrelationData) == 0 && len(m.User) == 0)
+}
+
+// retainedMessage 一条保留消息
+type retainedMessage struct {
+	payload []byte
+	props   *MessageProperties
+	stored  time.Time
+}
+
+// live 返回保留消息投递时的属性 (过期时间扣除已保存时长)，已过期时返回 false
+func (m retainedMessage) live(now time.Time) (*MessageProperties, bool) {
+	if m.props == nil || m.props.MessageExpiry == 0 {
+		return m.props, true
+	}
+	elapsed := uint32(now.Sub(m.stored) / time.Second)
+	if elapsed >= m.props.MessageExpiry {
+		return nil, false
+	}
+	props := *m.props
+	props.MessageExpiry -= elapsed
+	return &props, true
+}
+
+// LocalBroker 本地 MQTT Broker
+type LocalBroker struct {
+	// Authenticate 校验连接凭据，返回 false 时拒绝连接 (nil 表示全部放行)
+	Authenticate func(clientID, username, password string) bool
+	// AuthorizeSubscribe 校验订阅权限，返回 false 时在 SUBACK 中拒绝该过滤器 (nil 表示全部放行)
+	AuthorizeSubscribe func(clientID, filter string) bool
+	// OnConnect / OnDisconnect 设备上下线通知
+	OnConnect    func(clientID, username string)
+	OnDisconnect func(clientID string)
+	// OnPublish 收到客户端发布的消息 (在转发给订阅者之前调用)
+	OnPublish func(clientID, topic string, payload []byte)
+	// OnPublishProperties MQTT 5 客户端发布的消息带有属性时调用 (在 OnPublish 之后)
+	OnPublishProperties func(clientID, topic string, props MessageProperties)
+
+	mu       sync.Mutex
+	ln       net.Listener
+	clients  map[string]*brokerClient
+	retained map[string]retainedMessage
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// brokerClient 一个已连接的客户端会话
+type brokerClient struct {
+	broker   *LocalBroker
+	conn     net.Conn
+	id       string
+	username string
+	version  byte              // 协议级别: 3/4 (MQTT 3.1/3.1.1) 或 5
+	aliases  map[uint16]string // 客户端定义的 Topic 别名 (仅读循环访问)
+
+	writeMu sync.Mutex
+	w       *bufio.Writer
+
+	mu       sync.Mutex
+	subs     map[string]byte // topic filter -> 授予的 QoS
+	nextID   uint16
+	closed   bool
+	closeErr error
+}
+
+// NewLocalBroker 创建本地 Broker
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{
+		clients:  make(map[string]*brokerClient),
+		retained: make(map[string]retainedMessage),
+	}
+}
+
+// Listen 在 addr 上监听并在后台接受连接
+func (b *LocalBroker) Listen(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.ln = ln
+	b.mu.Unlock()
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			b.wg.Add(1)
+			go func() {
+				defer b.wg.Done()
+				b.serve(conn)
+			}()
+		}
+	}()
+	return nil
+}
+
+// Addr 返回监听地址
+func (b *LocalBroker) Addr() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ln == nil {
+		return ""
+	}
+	return b.ln.Addr().String()
+}
+
+// URL 返回客户端使用的 Broker URL (tcp://host:port)
+func (b *LocalBroker) URL() string {
+	return "tcp://" + b.Addr()
+}
+
+// Close 停止监听并断开所有客户端
+func (b *LocalBroker) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	ln := b.ln
+	clients := make([]*brokerClient, 0, len(b.clients))
+	for _, c := range b.clients {
+		clients = append(clients, c)
+	}
+	b.mu.Unlock()
+
+	var err error
+	if ln != nil {
+		err = ln.Close()
+	}
+	for _, c := range clients {
+		c.sendDisconnect(reasonServerShuttingDown, "")
+		c.close(errors.New("broker shutting down"))
+	}
+	b.wg.Wait()
+	return err
+}
+
+// Connected 判断客户端当前是否在线
+func (b *LocalBroker) Connected(clientID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.clients[clientID]
+	return ok
+}
+
+// ClientIDs 返回当前在线的客户端
+func (b *LocalBroker) ClientIDs() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ids := make([]string, 0, len(b.clients))
+	for id := range b.clients {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Kick 强制断开指定客户端
+func (b *LocalBroker) Kick(clientID string) bool {
+	b.mu.Lock()
+	c, ok := b.clients[clientID]
+	b.mu.Unlock()
+	if ok {
+		c.sendDisconnect(reasonAdministrative, "kicked")
+		c.close(errors.New("kicked"))
+	}
+	return ok
+}
+
+// Publish 由 Broker 自身 (如平台模拟) 向订阅者发布消息
+func (b *LocalBroker) Publish(topic string, payload []byte, qos byte, retain bool) {
+	b.route(topic, payload, qos, retain, nil)
+}
+
+// PublishWithProperties 发布带 MQTT 5 属性的消息 (如携带响应 Topic 的请求)
+func (b *LocalBroker) PublishWithProperties(topic string, payload []byte, qos byte, retain bool, props MessageProperties) {
+	b.route(topic, payload, qos, retain, &props)
+}
+
+// route 将消息转发给所有匹配的订阅者
+func (b *LocalBroker) route(topic string, payload []byte, qos byte, retain bool, props *MessageProperties) {
+	if props.empty() {
+		props = nil
+	}
+	b.mu.Lock()
+	if retain {
+		if len(payload) == 0 {
+			delete(b.retained, topic)
+		} else {
+			b.retained[topic] = retainedMessage{append([]byte(nil), payload...), props, time.Now()}
+		}
+	}
+	clients := make([]*brokerClient, 0, len(b.clients))
+	for _, c := range b.clients {
+		clients = append(clients, c)
+	}
+	b.mu.Unlock()
+
+	for _, c := range clients {
+		if granted, ok := c.matchQoS(topic); ok {
+			if granted > qos {
+				granted = qos
+			}
+			c.sendPublish(topic, payload, granted, false, props)
+		}
+	}
+}
+
+// serve 处理单个连接的完整生命周期
+func (b *LocalBroker) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	typ, _, body, err := readPacket(r)
+	if err != nil || typ != packetConnect {
+		conn.Close()
+		return
+	}
+	cp, rc := parseConnect(body)
+	c := &brokerClient{broker: b, conn: conn, w: bufio.NewWriter(conn), subs: make(map[string]byte),
+		id: cp.clientID, username: cp.username, version: cp.version}
+	if rc == connackAccepted && b.Authenticate != nil && !b.Authenticate(c.id, c.username, cp.password) {
+		rc = connackBadCredentials
+		if c.version == 5 {
+			rc = reasonBadCredentials
+		}
+	}
+	if err := c.writePacket(packetConnack<<4, c.connack(rc)); err != nil || rc != connackAccepted {
+		conn.Close()
+		return
+	}
+
+	// 同 ClientID 的旧会话被顶替
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		conn.Close()
+		return
+	}
+	old := b.clients[c.id]
+	b.clients[c.id] = c
+	b.mu.Unlock()
+	if old != nil {
+		old.sendDisconnect(reasonSessionTakenOver, "")
+		old.close(errors.New("session taken over"))
+	}
+	if b.OnConnect != nil {
+		b.OnConnect(c.id, c.username)
+	}
+
+	err = c.readLoop(r, cp.keepAlive)
+	c.close(err)
+
+	// 已被顶替的旧连接不再代表该客户端，不触发 OnDisconnect
+	b.mu.Lock()
+	current := b.clients[c.id] == c
+	if current {
+		delete(b.clients, c.id)
+	}
+	b.mu.Unlock()
+	if current && b.OnDisconnect != nil {
+		b.OnDisconnect(c.id)
+	}
+}
+
+// connectPacket CONNECT 报文中 Broker 使用的字段
+type connectPacket struct {
+	version   byte
+	keepAlive time.Duration
+	clientID  string
+	username  string
+	password  string
+}
+
+// parseConnect 解析 CONNECT 报文，返回报文字段和 CONNACK 返回码 (按协议版本)
+func parseConnect(body []byte) (connectPacket, byte) {
+	var cp connectPacket
+	p := packetReader{buf: body}
+	proto := p.str()
+	cp.version = p.byte()
+	flags := p.byte()
+	cp.keepAlive = time.Duration(p.uint16()) * time.Second
+	if p.err != nil || (proto != "MQTT" && proto != "MQIsdp") || (cp.version != 3 && cp.version != 4 && cp.version != 5) {
+		cp.version = 4 // 以 MQTT 3.1.1 格式拒绝
+		return cp, connackBadProtocol
+	}
+	if cp.version == 5 {
+		p.properties() // 会话不跨连接保留，Session Expiry 等连接属性不影响行为
+	}
+	cp.clientID = p.str()
+	if flags&0x04 != 0 { // will
+		if cp.version == 5 {
+			p.properties()
+		}
+		p.str()
+		p.str()
+	}
+	if flags&0x80 != 0 {
+		cp.username = p.str()
+	}
+	if flags&0x40 != 0 {
+		cp.password = p.str()
+	}
+	if p.err != nil {
+		if cp.version == 5 {
+			return cp, reasonMalformedPacket
+		}
+		return cp, connackBadProtocol
+	}
+	return cp, connackAccepted
+}
+
+// connack 构造 CONNACK 报文体，MQTT 5 附带 Topic 别名上限 (成功时) 或原因说明 (失败时)
+func (c *brokerClient) connack(rc byte) []byte {
+	body := []byte{0, rc}
+	if c.version != 5 {
+		return body
+	}
+	var props []byte
+	switch rc {
+	case reasonSuccess:
+		props = append(props, propTopicAliasMaximum)
+		props = binary.BigEndian.AppendUint16(props, brokerTopicAliasMaximum)
+	case reasonBadCredentials:
+		props = appendString(append(props, propReasonString), "invalid token")
+	}
+	return append(appendVarint(body, len(props)), props...)
+}
+
+// sendDisconnect 向 MQTT 5 客户端发送带原因码的 DISCONNECT (MQTT 3.1.1 客户端直接断开，不发送)
+func (c *brokerClient) sendDisconnect(reason byte, reasonString string) {
+	if c.version != 5 {
+		return
+	}
+	var props []byte
+	if reasonString != "" {
+		props = appendString(append(props, propReasonString), reasonString)
+	}
+	c.writePacket(packetDisconnect<<4, append(appendVarint([]byte{reason}, len(props)), props...))
+}
+
+func (c *brokerClient) readLoop(r *bufio.Reader, keepAlive time.Duration) error {
+	for {
+		if keepAlive > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		} else {
+			c.conn.SetReadDeadline(time.Time{})
+		}
+		typ, flags, body, err := readPacket(r)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case packetPublish:
+			if err := c.handlePublish(flags, body); err != nil {
+				return err
+			}
+		case packetPubrel:
+			if len(body) >= 2 {
+				c.writePacket(packetPubcomp<<4, body[:2])
+			}
+		case packetSubscribe:
+			if err := c.handleSubscribe(body); err != nil {
+				return err
+			}
+		case packetUnsubscribe:
+			if err := c.handleUnsubscribe(body); err != nil {
+				return err
+			}
+		case packetPingreq:
+			c.writePacket(packetPingresp<<4, nil)
+		case packetDisconnect:
+			return nil
+		case packetPuback, packetPubrec, packetPubcomp:
+			if typ == packetPubrec && len(body) >= 2 {
+				c.writePacket(packetPubrel<<4|0x02, body[:2])
+			}
+		default:
+			return fmt.Errorf("unexpected packet type %d", typ)
+		}
+	}
+}
+
+func (c *brokerClient) handlePublish(flags byte, body []byte) error {
+	qos := (flags >> 1) & 0x03
+	retain := flags&0x01 != 0
+	p := packetReader{buf: body}
+	topic := p.str()
+	var id uint16
+	if qos > 0 {
+		id = p.uint16()
+	}
+	var props mqttProperties
+	if c.version == 5 {
+		props = p.properties()
+	}
+	if p.err != nil || qos > 2 {
+		return fmt.Errorf("malformed PUBLISH")
+	}
+	payload := append([]byte(nil), p.rest()...)
+
+	// Topic 别名: 带 Topic 时 (重新) 定义别名，Topic 为空时按别名还原
+	if alias := props.topicAlias; alias != 0 {
+		if alias > brokerTopicAliasMaximum {
+			c.sendDisconnect(reasonTopicAliasInvalid, "")
+			return fmt.Errorf("topic alias %d exceeds maximum %d", alias, brokerTopicAliasMaximum)
+		}
+		if c.aliases == nil {
+			c.aliases = make(map[uint16]string)
+		}
+		if topic != "" {
+			c.aliases[alias] = topic
+		} else if topic = c.aliases[alias]; topic == "" {
+			c.sendDisconnect(reasonProtocolError, "unknown topic alias")
+			return fmt.Errorf("unknown topic alias %d", alias)
+		}
+	}
+
+	switch qos {
+	case 1:
+		c.writePacket(packetPuback<<4, binary.BigEndian.AppendUint16(nil, id))
+	case 2:
+		c.writePacket(packetPubrec<<4, binary.BigEndian.AppendUint16(nil, id))
+	}
+	if c.broker.OnPublish != nil {
+		c.broker.OnPublish(c.id, topic, payload)
+	}
+	if !props.msg.empty() && c.broker.OnPublishProperties != nil {
+		c.broker.OnPublishProperties(c.id, topic, props.msg)
+	}
+	c.broker.route(topic, payload, qos, retain, &props.msg)
+	return nil
+}
+
+func (c *brokerClient) handleSubscribe(body []byte) error {
+	p := packetReader{buf: body}
+	id := p.uint16()
+	if c.version == 5 {
+		p.properties()
+	}
+	var filters []string
+	var granted []byte
+	for p.remaining() > 0 {
+		filter := p.str()
+		qos := p.byte() & 0x03 // MQTT 5 订阅选项的其余位 (No Local 等) 不支持，忽略
+		if p.err != nil {
+			return fmt.Errorf("malformed SUBSCRIBE")
+		}
+		if qos > 1 {
+			qos = 1
+		}
+		if c.broker.AuthorizeSubscribe != nil && !c.broker.AuthorizeSubscribe(c.id, filter) {
+			qos = subackFailure
+			if c.version == 5 {
+				qos = reasonNotAuthorized
+			}
+		}
+		filters = append(filters, filter)
+		granted = append(granted, qos)
+	}
+	c.mu.Lock()
+	for i, f := range filters {
+		if granted[i] < subackFailure {
+			c.subs[f] = granted[i]
+		}
+	}
+	c.mu.Unlock()
+	ack := binary.BigEndian.AppendUint16(nil, id)
+	if c.version == 5 {
+		ack = append(ack, 0) // 无属性
+	}
+	if err := c.writePacket(packetSuback<<4, append(ack, granted...)); err != nil {
+		return err
+	}
+
+	// 投递匹配的保留消息 (跳过已过期的)
+	c.broker.mu.Lock()
+	type retainedMsg struct {
+		topic   string
+		payload []byte
+		props   *MessageProperties
+	}
+	var msgs []retainedMsg
+	now := time.Now()
+	for topic, m := range c.broker.retained {
+		props, ok := m.live(now)
+		if !ok {
+			delete(c.broker.retained, topic)
+			continue
+		}
+		for _, f := range filters {
+			if topicMatches(f, topic) {
+				msgs = append(msgs, retainedMsg{topic, m.payload, props})
+				break
+			}
+		}
+	}
+	c.broker.mu.Unlock()
+	for _, m := range msgs {
+		if qos, ok := c.matchQoS(m.topic); ok {
+			c.sendPublish(m.topic, m.payload, qos, true, m.props)
+		}
+	}
+	return nil
+}
+
+func (c *brokerClient) handleUnsubscribe(body []byte) error {
+	p := packetReader{buf: body}
+	id := p.uint16()
+	if c.version == 5 {
+		p.properties()
+	}
+	ack := binary.BigEndian.AppendUint16(nil, id)
+	if c.version == 5 {
+		ack = append(ack, 0) // 无属性，其后为每个过滤器的原因码
+	}
+	c.mu.Lock()
+	for p.remaining() > 0 && p.err == nil {
+		filter := p.str()
+		if _, ok := c.subs[filter]; ok || c.version != 5 {
+			delete(c.subs, filter)
+			if c.version == 5 {
+				ack = append(ack, reasonSuccess)
+			}
+		} else {
+			ack = append(ack, reasonNoSubscription)
+		}
+	}
+	c.mu.Unlock()
+	if p.err != nil {
+		return fmt.Errorf("malformed UNSUBSCRIBE")
+	}
+	return c.writePacket(packetUnsuback<<4, ack)
+}
+
+// matchQoS 返回客户端对 topic 的最高订阅 QoS
+func (c *brokerClient) matchQoS(topic string) (byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	best, found := byte(0), false
+	for f, qos := range c.subs {
+		if topicMatches(f, topic) {
+			if !found || qos > best {
+				best = qos
+			}
+			found = true
+		}
+	}
+	return best, found
+}
+
+func (c *brokerClient) sendPublish(topic string, payload []byte, qos byte, retain bool, props *MessageProperties) {
+	body := appendString(nil, topic)
+	if qos > 0 {
+		c.mu.Lock()
+		c.nextID++
+		if c.nextID == 0 {
+			c.nextID = 1
+		}
+		id := c.nextID
+		c.mu.Unlock()
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	if c.version == 5 {
+		body = appendMessageProperties(body, props)
+	}
+	body = append(body, payload...)
+	header := byte(packetPublish<<4) | qos<<1
+	if retain {
+		header |= 0x01
+	}
+	if err := c.writePacket(header, body); err != nil {
+		c.close(err)
+	}
+}
+
+func (c *brokerClient) writePacket(header byte, body []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(brokerDefaultWriteWindow))
+	if err := writePacket(c.w, header, body); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+func (c *brokerClient) close(err error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	c.closeErr = err
+	c.mu.Unlock()
+	c.conn.Close()
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		log.Printf("[broker] 客户端 %s 断开: %v", c.id, err)
+	}
+}
+
+// ======================================================================
+// 报文编解码
+// ======================================================================
+
+// readPacket 读取一个完整的 MQTT 报文
+func readPacket(r *bufio.Reader) (typ, flags byte, body []byte, err error) {
+	h, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		n += int(b&0x7F) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, 0, nil, fmt.Errorf("malformed remaining length")
+		}
+		mult *= 128
+	}
+	body = make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return h >> 4, h & 0x0F, body, nil
+}
+
+// writePacket 写出一个 MQTT 报文
+func writePacket(w io.Writer, header byte, body []byte) error {
+	if len(body) > maxRemainingLength {
+		return fmt.Errorf("packet too large")
+	}
+	buf := appendVarint([]byte{header}, len(body))
+	_, err := w.Write(append(buf, body...))
+	return err
+}
+
+// appendVarint 追加变长整数 (剩余长度、属性长度)
+func appendVarint(b []byte, n int) []byte {
+	for {
+		c := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// appendMessageProperties 追加 PUBLISH 的 MQTT 5 属性段 (props 为 nil 时为空属性)
+func appendMessageProperties(b []byte, m *MessageProperties) []byte {
+	var props []byte
+	if m != nil {
+		if m.MessageExpiry > 0 {
+			props = binary.BigEndian.AppendUint32(append(props, propMessageExpiry), m.MessageExpiry)
+		}
+		if m.ContentType != "" {
+			props = appendString(append(props, propContentType), m.ContentType)
+		}
+		if m.ResponseTopic != "" {
+			props = appendString(append(props, propResponseTopic), m.ResponseTopic)
+		}
+		if len(m.CorrelationData) > 0 {
+			props = appendString(append(props, propCorrelationData), string(m.CorrelationData))
+		}
+		for _, u := range m.User {
+			props = appendString(appendString(append(props, propUserProperty), u.Key), u.Value)
+		}
+	}
+	return append(appendVarint(b, len(props)), props...)
+}
+
+// packetReader 顺序读取报文字段，出错后后续读取均返回零值
+type packetReader struct {
+	buf []byte
+	err error
+}
+
+func (p *packetReader) remaining() int { return len(p.buf) }
+
+func (p *packetReader) byte() byte {
+	if p.err != nil || len(p.buf) < 1 {
+		p.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	b := p.buf[0]
+	p.buf = p.buf[1:]
+	return b
+}
+
+func (p *packetReader) uint16() uint16 {
+	if p.err != nil || len(p.buf) < 2 {
+		p.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := binary.BigEndian.Uint16(p.buf)
+	p.buf = p.buf[2:]
+	return v
+}
+
+func (p *packetReader) uint32() uint32 {
+	if p.err != nil || len(p.buf) < 4 {
+		p.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := binary.BigEndian.Uint32(p.buf)
+	p.buf = p.buf[4:]
+	return v
+}
+
+func (p *packetReader) varint() int {
+	n, mult := 0, 1
+	for i := 0; i < 4; i++ {
+		b := p.byte()
+		n += int(b&0x7F) * mult
+		if b&0x80 == 0 {
+			return n
+		}
+		mult *= 128
+	}
+	if p.err == nil {
+		p.err = fmt.Errorf("malformed variable byte integer")
+	}
+	return 0
+}
+
+func (p *packetReader) str() string {
+	n := int(p.uint16())
+	if p.err != nil || len(p.buf) < n {
+		p.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	s := string(p.buf[:n])
+	p.buf = p.buf[n:]
+	return s
+}
+
+func (p *packetReader) rest() []byte {
+	b := p.buf
+	p.buf = nil
+	return b
+}
+
+// mqttProperties 解析出的 MQTT 5 属性 (只保留 Broker 使用的部分)
+type mqttProperties struct {
+	topicAlias uint16
+	msg        MessageProperties
+}
+
+// properties 读取 MQTT 5 属性段，不使用的属性按类型跳过
+func (p *packetReader) properties() mqttProperties {
+	var props mqttProperties
+	n := p.varint()
+	if p.err != nil || n > len(p.buf) {
+		p.err = io.ErrUnexpectedEOF
+		return props
+	}
+	q := packetReader{buf: p.buf[:n]}
+	p.buf = p.buf[n:]
+	for q.remaining() > 0 && q.err == nil {
+		switch id := q.byte(); id {
+		case propMessageExpiry:
+			props.msg.MessageExpiry = q.uint32()
+		case propContentType:
+			props.msg.ContentType = q.str()
+		case propResponseTopic:
+			props.msg.ResponseTopic = q.str()
+		case propCorrelationData:
+			props.msg.CorrelationData = []byte(q.str())
+		case propTopicAlias:
+			props.topicAlias = q.uint16()
+		case propUserProperty:
+			key := q.str()
+			props.msg.User = append(props.msg.User, UserProperty{key, q.str()})
+		case propPayloadFormat, 0x17, 0x19, 0x24, 0x25, 0x28, 0x29, 0x2A: // 单字节属性
+			q.byte()
+		case 0x13, 0x21, propTopicAliasMaximum: // 双字节整数属性
+			q.uint16()
+		case propSessionExpiry, 0x18, 0x27: // 四字节整数属性
+			q.uint32()
+		case propSubscriptionID:
+			q.varint()
+		case 0x12, 0x15, 0x16, 0x1A, 0x1C, propReasonString: // 字符串 / 二进制属性
+			q.str()
+		default:
+			q.err = fmt.Errorf("unknown property 0x%02x", id)
+		}
+	}
+	if q.err != nil {
+		p.err = q.err
+	}
+	return props
+}
+
+// topicMatches 判断 Topic 是否匹配订阅过滤器 (支持 + 与 #)；
+// 首级为通配符的过滤器不匹配以 $ 开头的 Topic (如 $sys/...，MQTT 3.1.1 §4.7.2)
+func topicMatches(filter, topic string) bool {
+	if filter == topic {
+		return true
+	}
+	fs := strings.Split(filter, "/")
+	ts := strings.Split(topic, "/")
+	if (fs[0] == "#" || fs[0] == "+") && strings.HasPrefix(topic, "$") {
+		return false
+	}
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) {
+			return false
+		}
+		if f != "+" && f != ts[i] {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"flag"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"sync"
 	"time"
@@ -18,10 +21,199 @@ const (
 )
 
 func main() {
+	// 子命令
+	shellMode := false
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			os.Exit(runReplayCommand(os.Args[2:]))
+		case "broker":
+			os.Exit(runBrokerCommand(os.Args[2:]))
+		case "token":
+			os.Exit(runTokenCommand(os.Args[2:]))
+		case "shell":
+			// shell 模式: 参数与正常运行相同，额外在终端读取交互命令
+			shellMode = true
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		case "run":
+			// run 与直接运行相同，常与 -assert 配合: run -assert scenario.yaml
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+	}
+
+	// 平台连接参数
+	flag.StringVar(&BrokerURL, "broker", BrokerURL, "Broker URL (如 tcp://127.0.0.1:1883 连接本地 Broker)")
+	flag.StringVar(&ProductID, "product-id", ProductID, "产品ID")
+	flag.StringVar(&AccessKey, "access-key", AccessKey, "产品的 Access Key")
+	flag.StringVar(&StandInRegistrationKey, "standin-register-key", StandInRegistrationKey, "平台模拟 (broker 子命令) 的注册密钥，非空时本地无密钥的设备先向平台模拟注册 (真实 OneNET 不支持)")
+	devicesFlag := flag.String("devices", "", "逗号分隔的设备名列表，为空时使用内置设备")
+	flag.StringVar(&DeviceTransport, "transport", DeviceTransport, "接入方式: mqtt | http")
+	flag.StringVar(&HTTPURL, "http-url", HTTPURL, "HTTP 接入地址 (如 http://127.0.0.1:8081)")
+	flag.DurationVar(&HTTPPollInterval, "http-poll", HTTPPollInterval, "HTTP 接入时拉取下行命令的间隔")
+	flag.BoolVar(&UseMQTT5, "mqtt5", UseMQTT5, "使用 MQTT 5 接入 (paho.golang)")
+	flag.DurationVar(&SessionExpiry, "session-expiry", SessionExpiry, "MQTT 5 会话过期间隔，0 表示断开即清除")
+	flag.DurationVar(&MessageExpiry, "message-expiry", MessageExpiry, "MQTT 5 上行消息过期间隔，0 表示不过期")
+	flag.IntVar(&TopicAliases, "topic-aliases", TopicAliases, "MQTT 5 最多使用的 Topic 别名数 (另受 Broker 上限约束)，0 表示不使用")
+	flag.StringVar(&UserProperties, "user-properties", UserProperties, "MQTT 5 用户属性 (如 fw=1.2.0,site=lab)，附加到 CONNECT 与每条上行消息")
+	flag.IntVar(&PropertyPostQoS, "property-qos", PropertyPostQoS, "属性上报的 QoS (0 或 1)")
+	flag.IntVar(&EventPostQoS, "event-qos", EventPostQoS, "事件上报 (含批量上报) 的 QoS (0 或 1)")
+	flag.IntVar(&ReplyQoS, "reply-qos", ReplyQoS, "属性设置/获取及服务调用回复的 QoS (0 或 1)")
+	flag.IntVar(&SubscribeQoS, "subscribe-qos", SubscribeQoS, "订阅下行 Topic 的 QoS (0 或 1)")
+	flag.StringVar(&RetainKinds, "retain", RetainKinds, "带保留标志发布的消息类别 (逗号分隔: property,event,reply)")
+	flag.Float64Var(&RateLimit, "rate", RateLimit, "每台设备每秒最多上行消息数，0 表示不限制")
+	flag.IntVar(&RateBurst, "rate-burst", RateBurst, "每台设备的突发容量 (令牌桶大小)")
+	flag.Float64Var(&GlobalRateLimit, "global-rate", GlobalRateLimit, "全体设备合计每秒最多上行消息数，0 表示不限制")
+	flag.IntVar(&GlobalRateBurst, "global-rate-burst", GlobalRateBurst, "全体设备的突发容量")
+	flag.StringVar(&RatePolicy, "rate-policy", RatePolicy, "超出配额时的处理: queue (排队延后发送) | drop (丢弃)")
+	flag.IntVar(&RateQueueSize, "rate-queue", RateQueueSize, "queue 策略下每台设备最多排队的消息数")
+	flag.StringVar(&PushListen, "push-listen", PushListen, "启动数据推送接收并监听该地址 (如 127.0.0.1:8090)，核对上报是否送达应用")
+	flag.StringVar(&PushToken, "push-token", PushToken, "数据推送配置中的 token (校验签名)")
+	flag.StringVar(&PushAESKey, "push-aes-key", PushAESKey, "数据推送配置中的 AES Key，为空表示明文推送")
+	flag.DurationVar(&PushLateAfter, "push-late", PushLateAfter, "发布后超过该时长才收到推送计为迟到")
+	flag.DurationVar(&PushMissingAfter, "push-missing", PushMissingAfter, "发布后超过该时长仍未收到推送计为缺失")
+	flag.DurationVar(&PushReportInterval, "push-report", PushReportInterval, "推送核对结果写入日志的间隔")
+	flag.BoolVar(&CleanSession, "clean-session", CleanSession, "以清除会话方式连接；false 时使用持久会话，未确认的消息保存在 -state-dir 下")
+
+	// 设备状态持久化
+	flag.StringVar(&StateDir, "state-dir", StateDir, "设备状态 (可写属性、最近上报 ID) 保存目录，为空不持久化")
+	flag.BoolVar(&FreshStart, "fresh", FreshStart, "忽略已保存的设备状态，以默认值启动")
+
+	// 会话录制
+	recordFile := flag.String("record", "", "将所有设备收发的 MQTT 消息录制到指定文件 (JSON Lines)")
+
+	// 故障注入
+	faultFile := flag.String("faults", "", "故障注入配置文件 (JSON)，按设备配置丢包、延迟、重复、畸形载荷等")
+
+	// 设备时钟模拟参数
+	flag.Float64Var(&ClockDriftPPM, "clock-drift-ppm", ClockDriftPPM, "模拟设备时钟漂移 (ppm，正数表示走快)")
+	flag.DurationVar(&ClockInitialSkew, "clock-skew", ClockInitialSkew, "模拟设备上电时的时钟偏差 (如 -30s)")
+	flag.DurationVar(&TimeSyncInterval, "ntp-interval", TimeSyncInterval, "周期校时间隔，0 表示仅连接时校时")
+
+	// 自定义 Topic / 透传模拟参数
+	flag.StringVar(&RawUpTopic, "raw-up", RawUpTopic, "透传上行 Topic 模板 (支持 {product-id}/{device-name})，为空不上报")
+	flag.StringVar(&RawDownTopic, "raw-down", RawDownTopic, "透传下行 Topic 模板，为空不订阅")
+	flag.StringVar(&RawCodecSpec, "raw-codec", RawCodecSpec, "透传编解码: raw | hex | tlv | script:<命令>")
+	flag.StringVar(&RawPayload, "raw-payload", RawPayload, "周期上报的透传应用数据 (按 codec 的文本格式)")
+	flag.DurationVar(&RawInterval, "raw-interval", RawInterval, "透传数据上报周期")
+	flag.BoolVar(&RawEcho, "raw-echo", RawEcho, "收到下行透传数据后回传到上行 Topic")
+	flag.BoolVar(&ThingModelOff, "no-thing-model", ThingModelOff, "关闭物模型属性/事件上报，仅模拟透传设备")
+
+	// 网络条件模拟参数
+	flag.DurationVar(&netConditions.Latency, "net-latency", 0, "模拟网络单向时延")
+	flag.DurationVar(&netConditions.Jitter, "net-jitter", 0, "模拟网络时延抖动 (在 latency 基础上随机增加 0~jitter)")
+	flag.IntVar(&netConditions.Bandwidth, "net-bandwidth", 0, "模拟带宽上限 (字节/秒)，0 表示不限速")
+	flag.DurationVar(&netConditions.FlapEvery, "net-flap-every", 0, "周期性断线间隔，0 表示不启用")
+	flag.DurationVar(&netConditions.FlapDown, "net-flap-down", 5*time.Second, "每次周期性断线后链路不可用的时长")
+	flag.DurationVar(&netConditions.OutageAfter, "net-outage-after", 0, "启动后多久发生一次长时间断网")
+	flag.DurationVar(&netConditions.OutageFor, "net-outage-for", 0, "长时间断网持续时长，0 表示不启用")
+	flag.DurationVar(&stormConfig.Every, "storm-every", 0, "全体设备断线风暴周期，0 表示不启用")
+	flag.DurationVar(&stormConfig.Outage, "storm-outage", 10*time.Second, "风暴断网持续时长")
+	flag.DurationVar(&stormConfig.BackoffMin, "storm-backoff-min", stormConfig.BackoffMin, "风暴恢复后重连退避的初始上限")
+	flag.DurationVar(&stormConfig.BackoffMax, "storm-backoff-max", stormConfig.BackoffMax, "风暴恢复后重连退避的最大上限")
+
+	// 重连策略参数
+	flag.DurationVar(&reconnectPolicy.InitialInterval, "retry-initial", reconnectPolicy.InitialInterval, "连接失败后首次重试前的等待")
+	flag.DurationVar(&reconnectPolicy.MaxInterval, "retry-max", reconnectPolicy.MaxInterval, "重试等待时长上限")
+	flag.Float64Var(&reconnectPolicy.Multiplier, "retry-multiplier", reconnectPolicy.Multiplier, "每次失败后等待时长的放大倍数")
+	flag.Float64Var(&reconnectPolicy.Jitter, "retry-jitter", reconnectPolicy.Jitter, "重试等待的随机抖动比例 (0~1)")
+	flag.IntVar(&reconnectPolicy.MaxAttempts, "retry-max-attempts", reconnectPolicy.MaxAttempts, "初次连接最大尝试次数，0 表示不限")
+	flag.DurationVar(&ConnectTimeout, "connect-timeout", ConnectTimeout, "单次连接超时")
+	flag.IntVar(&SubscribeRetries, "subscribe-retries", SubscribeRetries, "单个 Topic 订阅失败后的重试次数")
+	flag.DurationVar(&SubscribeRetryInterval, "subscribe-retry-interval", SubscribeRetryInterval, "订阅重试间隔")
+	flag.IntVar(&SubscribeMaxFailures, "subscribe-max-failures", SubscribeMaxFailures, "连续多少次连接后订阅失败即标记设备为 failed，0 表示不限")
+	// 终端仪表盘
+	tuiMode := flag.Bool("tui", false, "以终端仪表盘显示设备状态 (日志显示在仪表盘下方)")
+	logFile := flag.String("log-file", "", "同时将日志写入指定文件 (常与 -tui 配合)")
+	flag.DurationVar(&TUIRefresh, "tui-refresh", TUIRefresh, "仪表盘刷新间隔")
+	// Web 仪表盘
+	flag.StringVar(&WebAddr, "web", WebAddr, "启用 Web 仪表盘并监听该地址 (如 127.0.0.1:8080)")
+	// 断言模式
+	flag.StringVar(&AssertFile, "assert", AssertFile, "按场景文件在进程内的本地 Broker 上运行设备并检查期望，输出报告后退出 (失败时退出码非 0)")
+	flag.Parse()
+
+	if *tuiMode && shellMode {
+		log.Fatalf("-tui 不能与 shell 模式同时使用")
+	}
+	if AssertFile != "" && (*tuiMode || shellMode || WebAddr != "") {
+		log.Fatalf("-assert 不能与 -tui、-web 或 shell 模式同时使用")
+	}
+	if err := checkTransport(); err != nil {
+		log.Fatalf("接入方式配置错误: %v", err)
+	}
+	if err := checkMQTT5(); err != nil {
+		log.Fatalf("MQTT 5 配置错误: %v", err)
+	}
+	if err := checkPublishQoS(); err != nil {
+		log.Fatalf("QoS 配置错误: %v", err)
+	}
+	if err := checkRateLimits(); err != nil {
+		log.Fatalf("限流配置错误: %v", err)
+	}
+	configureRateLimits()
+	if !CleanSession && StateDir == "" {
+		log.Printf("⚠️ 持久会话未配置 -state-dir，未确认的消息仅保存在内存中，进程重启后丢失")
+	}
+	var logWriter *os.File
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatalf("打开日志文件失败: %v", err)
+		}
+		defer f.Close()
+		logWriter = f
+		if !*tuiMode {
+			log.SetOutput(io.MultiWriter(os.Stderr, f))
+		}
+	}
+
+	if *recordFile != "" {
+		rec, err := OpenSessionRecorder(*recordFile)
+		if err != nil {
+			log.Fatalf("打开录制文件失败: %v", err)
+		}
+		sessionRecorder = rec
+		defer rec.Close()
+		log.Printf("会话录制已启用: %s", *recordFile)
+	}
+
+	if *faultFile != "" {
+		cfg, err := LoadFaultConfig(*faultFile)
+		if err != nil {
+			log.Fatalf("加载故障注入配置失败: %v", err)
+		}
+		faultConfig = cfg
+		log.Printf("故障注入已启用: %s", *faultFile)
+	}
+
+	if AssertFile != "" {
+		code := runScenarioFile(AssertFile)
+		if sessionRecorder != nil {
+			sessionRecorder.Close() // os.Exit 不执行 defer
+		}
+		os.Exit(code)
+	}
+
+	stopPush := func() {}
+	if PushListen != "" {
+		stop, err := startPushReceiver(PushListen)
+		if err != nil {
+			log.Fatalf("启动推送接收失败: %v", err)
+		}
+		stopPush = stop
+	}
+
 	log.Printf("==== OneNET Go 多设备模拟器启动 ====")
 	log.Printf("产品ID: %s", ProductID)
 
 	deviceNames := []string{DeviceName1, DeviceName2}
+	if *devicesFlag != "" {
+		deviceNames = nil
+		for _, name := range strings.Split(*devicesFlag, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				deviceNames = append(deviceNames, name)
+			}
+		}
+	}
 	
 	var wg sync.WaitGroup // 用于等待所有设备协程结束
     
@@ -42,9 +234,36 @@ func main() {
     // 监听 Ctrl+C (SIGINT) 和 kill (SIGTERM) 信号
     signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
     
-    // 2. 阻塞直到接收到信号
-    sig := <-quit
-    log.Printf("==== 收到系统信号: %v, 正在执行优雅退出... ====", sig)
+    shellDone := make(chan struct{})
+    if shellMode {
+        go runShell(shellDone)
+    }
+    stopWeb := func() {}
+    if WebAddr != "" {
+        stop, err := startWebDashboard(WebAddr)
+        if err != nil {
+            log.Fatalf("启动 Web 仪表盘失败: %v", err)
+        }
+        stopWeb = stop
+    }
+    stopDashboard := func() {}
+    if *tuiMode {
+        if logWriter != nil {
+            stopDashboard = startDashboard(logWriter)
+        } else {
+            stopDashboard = startDashboard(nil)
+        }
+    }
+
+    // 2. 阻塞直到接收到信号 (shell 模式下也可由用户退出 shell 触发)
+    select {
+    case sig := <-quit:
+        log.Printf("==== 收到系统信号: %v, 正在执行优雅退出... ====", sig)
+    case <-shellDone:
+        log.Printf("==== shell 已退出, 正在执行优雅退出... ====")
+    }
+    stopDashboard()
+    stopWeb()
     
     // 3. 关闭全局停止信号通道，通知所有设备协程退出
     close(stopSig)
@@ -65,6 +284,7 @@ func main() {
     case <-time.After(waitTimeout):
         log.Printf("⚠️ 警告: 超时 %v，强制退出程序。", waitTimeout)
     }
+    stopPush()
 
 	log.Printf("==== OneNET Go 多设备模拟器退出完成 ====")
 }
@@ -74,45 +294,62 @@ func runDeviceWithStop(name string, wg *sync.WaitGroup, stop <-chan struct{}) {
     // 确保无论如何都通知 WaitGroup 退出
     defer wg.Done() 
 
-    // 1. 获取 MQTT 连接配置
-    opts := getConnectOptions(name)
-    
     // 创建 Device 实例 (包含本地状态和静态属性)
     dev := initDeviceState(name) 
+    registerDevice(dev)
+    if err := dev.setupRawSimulation(); err != nil {
+        log.Printf("[%s] 透传模拟配置错误: %v. 设备退出。", name, err)
+        dev.setState(StateFailed, 0, err)
+        return
+    }
+
+    // 1. 向平台模拟动态注册 (已开启且本地无设备密钥时)，再获取 MQTT 连接配置 (失败时仅该设备退出，其余设备继续运行)
+    if err := ensureRegistered(name); err != nil {
+        log.Printf("[%s] 动态注册失败: %v. 设备退出。", name, err)
+        dev.setState(StateFailed, 0, err)
+        return
+    }
+    if DeviceTransport == "http" {
+        dev.runHTTP(stop)
+        return
+    }
+    if UseMQTT5 {
+        dev.runMQTT5(stop)
+        return
+    }
+    opts, err := getConnectOptions(name)
+    if err != nil {
+        log.Printf("[%s] 连接配置错误: %v. 设备退出。", name, err)
+        dev.setState(StateFailed, 0, err)
+        return
+    }
+    
+    // 网络条件模拟 (未启用时为 nil)
+    if netem := newNetEmulator(name); netem != nil {
+        netem.attach(opts, stop)
+    }
+
+    // 设置连接丢失回调：通知连接循环按重连策略重连
+    lost := make(chan error, 1)
 
     // 设置连接成功回调：所有业务逻辑都在连接成功后执行
     opts.SetOnConnectHandler(func(client mqtt.Client) {
         log.Printf("[%s] MQTT 连接成功!", name)
         
-        // 1. 设置 Client
-        dev.Client = client 
-        
-        // 2. 订阅该设备专属的命令 Topic
-        dev.subscribeForCommands() 
-
-        // 3. 启动设备模拟的主循环 (包含动态上报和周期管理)
-        go dev.startDeviceSimulation() 
+        // 订阅该设备专属的命令 Topic，成功后启动设备模拟的主循环 (包含动态上报和周期管理)
+        dev.onConnected(lost)
     })
     
-    // 设置连接丢失回调 
     opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
         log.Printf("[%s] MQTT 连接丢失: %v. 尝试重连...", name, err)
+        select {
+        case lost <- err:
+        default:
+        }
     })
 
-    // 2. 创建并连接客户端
+    // 2. 创建客户端，按重连策略连接并维持连接，直到收到停止信号
     client := mqtt.NewClient(opts)
-    if token := client.Connect(); token.Wait() && token.Error() != nil {
-        log.Printf("[%s] 连接 Broker 失败: %v. 设备退出。", name, token.Error())
-        return // 连接失败，退出协程
-    }
-    
-    // 3. 阻塞协程，等待停止信号
-    select {
-    case <-stop:
-        // 收到停止信号，执行优雅断开
-        log.Printf("[%s] 正在断开 MQTT 连接...", name)
-        // Disconnect(250) 允许 250ms 完成正在发送/接收的数据包
-        client.Disconnect(250) 
-        log.Printf("[%s] MQTT 连接已断开。", name)
-    }
-}
\ No newline at end of file
+    dev.attachClient(client)
+    dev.runConnection(pahoConn{client}, lost, stop)
+}
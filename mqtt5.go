@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"qsiot_server/onenet/device"
+)
+
+// ======================================================================
+// MQTT 5 接入
+//
+// -mqtt5 时设备经 onenet/device 的 MQTT5Transport (paho.golang) 接入，连接循环、
+// 重连策略、订阅与模拟逻辑与 MQTT 3.1.1 相同。Broker 返回的非成功原因码
+// (CONNACK/PUBACK/SUBACK/DISCONNECT) 写入日志，并按 "报文 0x原因码" 计入设备统计。
+// ======================================================================
+
+// MQTT 5 配置 (可通过命令行参数覆盖)
+var (
+	UseMQTT5       = false
+	SessionExpiry  = time.Duration(0) // 会话过期间隔
+	MessageExpiry  = time.Duration(0) // 上行消息过期间隔
+	TopicAliases   = 8                // 最多使用的 Topic 别名数
+	UserProperties = ""               // 用户属性 k=v,k2=v2
+)
+
+// checkMQTT5 校验 MQTT 5 配置
+func checkMQTT5() error {
+	if !UseMQTT5 {
+		return nil
+	}
+	if DeviceTransport != "mqtt" {
+		return fmt.Errorf("-mqtt5 requires -transport mqtt")
+	}
+	if TopicAliases < 0 || TopicAliases > 65535 {
+		return fmt.Errorf("-topic-aliases must be within 0..65535")
+	}
+	_, err := parseUserProperties(UserProperties)
+	return err
+}
+
+// parseUserProperties 解析 "k=v,k2=v2" 形式的用户属性
+func parseUserProperties(s string) (map[string]string, error) {
+	props := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid user property %q (want key=value)", kv)
+		}
+		props[k] = v
+	}
+	return props, nil
+}
+
+// mqtt5Conn 以 deviceConn 形式使用 MQTT 5 Transport，连接成功后在后台执行 onConnect
+type mqtt5Conn struct {
+	t         *device.MQTT5Transport
+	onConnect func()
+	wg        sync.WaitGroup
+}
+
+func (c *mqtt5Conn) connect() error {
+	if err := c.t.Connect(context.Background()); err != nil {
+		return err
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.onConnect()
+	}()
+	return nil
+}
+
+// disconnect 断开连接并等待连接初始化 (订阅、校时与首次上报) 结束，断开后其中的发布会立即失败
+func (c *mqtt5Conn) disconnect() {
+	c.t.Disconnect()
+	c.wg.Wait()
+}
+
+// runMQTT5 以 MQTT 5 接入运行设备，直到 stop 关闭
+func (d *Device) runMQTT5(stop <-chan struct{}) {
+	props, err := parseUserProperties(UserProperties)
+	if err != nil {
+		log.Printf("[%s] MQTT 5 配置错误: %v. 设备退出。", d.Name, err)
+		d.setState(StateFailed, 0, err)
+		return
+	}
+	cfg := connectConfig(d.Name, deviceAccessKey(d.Name))
+
+	lost := make(chan error, 1)
+	opts := device.MQTT5Options{
+		SessionExpiry:  SessionExpiry,
+		MessageExpiry:  MessageExpiry,
+		TopicAliases:   uint16(TopicAliases),
+		UserProperties: props,
+		OnReasonCode: func(packet string, code byte, reason string) {
+			log.Printf("[%s] ⚠️ MQTT 5 %s 原因码 0x%02X (%s) %s", d.Name, packet, code, device.ReasonName(code), reason)
+			d.Stats.noteReasonCode(packet, code)
+		},
+		OnConnectionLost: func(err error) {
+			log.Printf("[%s] MQTT 连接丢失: %v. 尝试重连...", d.Name, err)
+			select {
+			case lost <- err:
+			default:
+			}
+		},
+	}
+	// 网络条件模拟 (未启用时为 nil)
+	if netem := newNetEmulator(d.Name); netem != nil {
+		opts.Dial = func(ctx context.Context, uri *url.URL) (net.Conn, error) {
+			return netem.dial(uri, mqtt.ClientOptions{ConnectTimeout: cfg.ConnectTimeout, TLSConfig: cfg.TLSConfig})
+		}
+		netem.start(stop)
+	}
+
+	t := device.NewMQTT5Transport(cfg, opts)
+	d.attachTransport(t)
+	log.Printf("[%s] 📡 使用 MQTT 5 接入 (会话过期 %v，消息过期 %v，Topic 别名上限 %d)", d.Name, SessionExpiry, MessageExpiry, TopicAliases)
+	d.runConnection(&mqtt5Conn{t: t, onConnect: func() {
+		log.Printf("[%s] MQTT 5 连接成功!", d.Name)
+		if d.enterOnline(lost) {
+			d.startDeviceSimulation()
+		}
+	}}, lost, stop)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"qsiot_server/onenet/device"
+	"qsiot_server/onenet/topics"
+)
+
+// useMQTT5 让测试设备以 MQTT 5 接入
+func useMQTT5(t *testing.T) {
+	t.Helper()
+	use, msgExpiry, aliases, props := UseMQTT5, MessageExpiry, TopicAliases, UserProperties
+	UseMQTT5, MessageExpiry, TopicAliases, UserProperties = true, 30*time.Second, 4, "fw=1.2.0"
+	t.Cleanup(func() { UseMQTT5, MessageExpiry, TopicAliases, UserProperties = use, msgExpiry, aliases, props })
+}
+
+// publishedMessage Broker 收到的一条带属性的消息
+type publishedMessage struct {
+	topic string
+	props MessageProperties
+}
+
+func TestDeviceOverMQTT5(t *testing.T) {
+	b, _, _ := startTestPlatform(t)
+	useMQTT5(t)
+
+	var mu sync.Mutex
+	var published []publishedMessage
+	b.OnPublishProperties = func(clientID, topic string, props MessageProperties) {
+		mu.Lock()
+		published = append(published, publishedMessage{topic, props})
+		mu.Unlock()
+	}
+	received := func(topic string) []MessageProperties {
+		mu.Lock()
+		defer mu.Unlock()
+		var out []MessageProperties
+		for _, m := range published {
+			if m.topic == topic {
+				out = append(out, m.props)
+			}
+		}
+		return out
+	}
+
+	const name = "mqtt5-dev"
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go runDeviceWithStop(name, &wg, stop)
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+	waitFor(t, name+" online", func() bool {
+		d, ok := lookupDevice(name)
+		return ok && d.State() == StateOnline
+	})
+	d, _ := lookupDevice(name)
+	waitFor(t, name+" property post acknowledged", func() bool {
+		return d.Stats.Snapshot().LastReplyCode == 200
+	})
+
+	// 再次上报: 第二次起只携带 Topic 别名，Broker 仍能还原 Topic
+	postTopic := getTopic(name, PropertyPostTopicTemplate)
+	d.postDeviceProperty(false)
+	waitFor(t, "second property post", func() bool { return len(received(postTopic)) >= 2 })
+	for _, p := range received(postTopic) {
+		if p.MessageExpiry != 30 || len(p.User) != 1 || p.User[0] != (UserProperty{"fw", "1.2.0"}) {
+			t.Errorf("property post properties = %+v", p)
+		}
+	}
+
+	// 请求携带响应 Topic: set_reply 发往该 Topic 并带回关联数据
+	b.PublishWithProperties(getTopic(name, PropertySetTopicTemplate),
+		[]byte(`{"id":"v5-set","version":"1.0","params":{"relay":1}}`), 1, false,
+		MessageProperties{ResponseTopic: "app/replies/" + name, CorrelationData: []byte("req-1")})
+	waitFor(t, "set reply on response topic", func() bool { return len(received("app/replies/"+name)) == 1 })
+	if p := received("app/replies/" + name)[0]; string(p.CorrelationData) != "req-1" {
+		t.Errorf("correlation data = %q, want req-1", p.CorrelationData)
+	}
+
+	// 被 Broker 踢下线: DISCONNECT 原因码计入统计，随后自动重连
+	b.Kick(name)
+	waitFor(t, "DISCONNECT reason code counted", func() bool {
+		return d.Stats.Snapshot().ReasonCodes["DISCONNECT 0x98"] == 1
+	})
+	waitFor(t, name+" back online", func() bool { return b.Connected(name) && d.State() == StateOnline })
+}
+
+func TestMQTT5ConnectRejected(t *testing.T) {
+	_, standIn, _ := startTestPlatform(t)
+	useRegistration(t, standIn) // 平台校验 Token，产品 Access Key 无效
+
+	var codes []byte
+	tr := device.NewMQTT5Transport(connectConfig("v5-bad", AccessKey), device.MQTT5Options{
+		OnReasonCode: func(packet string, code byte, reason string) { codes = append(codes, code) },
+	})
+	err := tr.Connect(context.Background())
+	var rc *device.ReasonCodeError
+	if !errors.As(err, &rc) || rc.Packet != "CONNACK" || rc.Code != 0x86 || rc.Reason != "invalid token" {
+		t.Fatalf("Connect error = %v, want CONNACK 0x86", err)
+	}
+	if len(codes) != 1 || codes[0] != 0x86 {
+		t.Errorf("reported reason codes = %v", codes)
+	}
+	if tr.IsConnected() {
+		t.Error("transport connected after rejection")
+	}
+	if err := tr.Publish(topics.Device(ProductID, "v5-bad", topics.PropertyPost), 1, false, []byte("{}")); !errors.Is(err, device.ErrNotConnected) {
+		t.Errorf("Publish while disconnected = %v, want ErrNotConnected", err)
+	}
+}
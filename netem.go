@@ -0,0 +1,372 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// ======================================================================
+// 网络条件模拟
+//
+// 通过 paho 的 CustomOpenConnectionFn 包装底层 net.Conn:
+//   latency / jitter  每个读写方向附加的时延
+//   bandwidth         带宽上限 (字节/秒)
+//   flap              周期性断线，断线后链路保持不可用 flap_down
+//   outage            启动后 outage_after 发生一次长时间断网，持续 outage_for
+//   storm             全体设备同时断线 (重连风暴)，恢复后每台设备按指数退避 +
+//                     抖动延迟重连，避免所有设备同一时刻涌入
+// 断网期间拨号直接失败，由 MQTT 客户端的自动重连逻辑继续重试。
+// ======================================================================
+
+// NetworkConditions 单设备网络条件
+type NetworkConditions struct {
+	Latency     time.Duration
+	Jitter      time.Duration
+	Bandwidth   int // 字节/秒，0 表示不限速
+	FlapEvery   time.Duration
+	FlapDown    time.Duration
+	OutageAfter time.Duration
+	OutageFor   time.Duration
+}
+
+// StormConfig 全体设备断线风暴配置
+type StormConfig struct {
+	Every      time.Duration // 风暴周期，0 表示不启用
+	Outage     time.Duration // 断网持续时间
+	BackoffMin time.Duration // 恢复后首次重连的退避上限
+	BackoffMax time.Duration // 退避上限的最大值 (每次失败翻倍)
+}
+
+// 网络模拟配置 (可通过命令行参数覆盖)
+var (
+	netConditions NetworkConditions
+	stormConfig   = StormConfig{BackoffMin: 1 * time.Second, BackoffMax: 30 * time.Second}
+)
+
+func (c NetworkConditions) enabled() bool {
+	return c.Latency > 0 || c.Jitter > 0 || c.Bandwidth > 0 || c.FlapEvery > 0 || c.OutageFor > 0
+}
+
+// ======================================================================
+// 风暴协调器 (全局共享)
+// ======================================================================
+
+// netStorm 在所有设备之间协调断线风暴：第一台设备加入时启动风暴循环，
+// 最后一台设备离开时停止 (不依赖任何单台设备的生命周期)
+type netStorm struct {
+	mu        sync.Mutex
+	downUntil time.Time
+	members   map[*netEmulator]struct{}
+	done      chan struct{} // 风暴循环运行中时非 nil，关闭即停止
+}
+
+var fleetStorm = &netStorm{members: make(map[*netEmulator]struct{})}
+
+// join 注册设备，风暴循环未运行时启动
+func (s *netStorm) join(e *netEmulator, cfg StormConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.members[e] = struct{}{}
+	if s.done != nil || cfg.Every <= 0 {
+		return
+	}
+	s.done = make(chan struct{})
+	go s.run(cfg.Every, cfg.Outage, s.done)
+}
+
+// leave 注销设备，最后一台设备离开时停止风暴循环
+func (s *netStorm) leave(e *netEmulator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.members, e)
+	if len(s.members) == 0 && s.done != nil {
+		close(s.done)
+		s.done = nil
+	}
+}
+
+func (s *netStorm) run(every, outage time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.trigger(outage)
+		}
+	}
+}
+
+// trigger 立即断开所有设备，并在 outage 内拒绝拨号
+func (s *netStorm) trigger(outage time.Duration) {
+	s.mu.Lock()
+	s.downUntil = time.Now().Add(outage)
+	members := make([]*netEmulator, 0, len(s.members))
+	for e := range s.members {
+		members = append(members, e)
+	}
+	s.mu.Unlock()
+
+	log.Printf("🌩️ 重连风暴: 断开 %d 台设备, 断网 %v", len(members), outage)
+	for _, e := range members {
+		e.mu.Lock()
+		e.stormHit = true
+		e.mu.Unlock()
+		e.dropAll()
+	}
+}
+
+func (s *netStorm) down() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Now().Before(s.downUntil)
+}
+
+// ======================================================================
+// 单设备模拟器
+// ======================================================================
+
+// netEmulator 单设备的网络模拟
+type netEmulator struct {
+	name  string
+	cond  NetworkConditions
+	storm *netStorm
+
+	mu        sync.Mutex
+	conns     map[*emulatedConn]struct{}
+	downUntil time.Time
+	stormHit  bool // 经历风暴后尚未成功重连
+	attempts  int  // 风暴后的重连次数 (用于退避)
+}
+
+// newNetEmulator 按全局配置创建模拟器，未启用任何模拟时返回 nil
+func newNetEmulator(name string) *netEmulator {
+	if !netConditions.enabled() && stormConfig.Every <= 0 {
+		return nil
+	}
+	return &netEmulator{
+		name:  name,
+		cond:  netConditions,
+		storm: fleetStorm,
+		conns: make(map[*emulatedConn]struct{}),
+	}
+}
+
+// attach 将模拟器接入 MQTT 连接选项，并在后台驱动断线计划直到 stop 关闭
+func (e *netEmulator) attach(opts *mqtt.ClientOptions, stop <-chan struct{}) {
+	opts.SetCustomOpenConnectionFn(e.dial)
+	e.start(stop)
+}
+
+// start 加入断线风暴，并在后台驱动断线计划直到 stop 关闭 (拨号由调用方接入)
+func (e *netEmulator) start(stop <-chan struct{}) {
+	e.storm.join(e, stormConfig)
+	go e.run(stop)
+}
+
+// run 驱动周期断线与一次性长时间断网
+func (e *netEmulator) run(stop <-chan struct{}) {
+	defer e.storm.leave(e)
+
+	var flap <-chan time.Time
+	if e.cond.FlapEvery > 0 {
+		t := time.NewTicker(e.cond.FlapEvery)
+		defer t.Stop()
+		flap = t.C
+	}
+	var outage <-chan time.Time
+	if e.cond.OutageFor > 0 {
+		outage = time.After(e.cond.OutageAfter)
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-flap:
+			log.Printf("[%s] 📶 网络抖断 %v", e.name, e.cond.FlapDown)
+			e.cut(e.cond.FlapDown)
+		case <-outage:
+			log.Printf("[%s] 📵 长时间断网 %v", e.name, e.cond.OutageFor)
+			e.cut(e.cond.OutageFor)
+		}
+	}
+}
+
+// cut 断开当前连接，并在 d 内拒绝拨号
+func (e *netEmulator) cut(d time.Duration) {
+	e.mu.Lock()
+	if until := time.Now().Add(d); until.After(e.downUntil) {
+		e.downUntil = until
+	}
+	e.mu.Unlock()
+	e.dropAll()
+}
+
+func (e *netEmulator) dropAll() {
+	e.mu.Lock()
+	conns := make([]*emulatedConn, 0, len(e.conns))
+	for c := range e.conns {
+		conns = append(conns, c)
+	}
+	e.mu.Unlock()
+	for _, c := range conns {
+		c.reset()
+	}
+}
+
+// stormBackoff 风暴恢复后第 attempt 次重连前的等待: [0, min(max, min*2^attempt)) 全抖动
+func stormBackoff(cfg StormConfig, attempt int) time.Duration {
+	ceil := cfg.BackoffMin
+	for i := 0; i < attempt && ceil < cfg.BackoffMax; i++ {
+		ceil *= 2
+	}
+	if ceil > cfg.BackoffMax {
+		ceil = cfg.BackoffMax
+	}
+	if ceil <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceil)))
+}
+
+// dial 实现 mqtt.OpenConnectionFunc
+func (e *netEmulator) dial(uri *url.URL, options mqtt.ClientOptions) (net.Conn, error) {
+	e.mu.Lock()
+	down := time.Now().Before(e.downUntil)
+	stormHit, attempt := e.stormHit, e.attempts
+	e.mu.Unlock()
+
+	if down || e.storm.down() {
+		if stormHit {
+			e.mu.Lock()
+			e.attempts++
+			e.mu.Unlock()
+		}
+		return nil, fmt.Errorf("network unreachable (emulated outage)")
+	}
+	if stormHit {
+		wait := stormBackoff(stormConfig, attempt)
+		log.Printf("[%s] 🌩️ 风暴恢复，退避 %v 后重连 (第 %d 次)", e.name, wait.Round(time.Millisecond), attempt+1)
+		time.Sleep(wait)
+	}
+
+	raw, err := dialBroker(uri, options)
+	if err != nil {
+		return nil, err
+	}
+	c := &emulatedConn{Conn: raw, em: e}
+	e.mu.Lock()
+	e.conns[c] = struct{}{}
+	e.stormHit, e.attempts = false, 0
+	e.mu.Unlock()
+	return c, nil
+}
+
+// dialBroker 按 URL scheme 建立 TCP/TLS 连接 (与 paho 内置拨号行为一致)
+func dialBroker(uri *url.URL, options mqtt.ClientOptions) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: options.ConnectTimeout}
+	host := uri.Host
+	switch uri.Scheme {
+	case "tcp", "mqtt":
+		if uri.Port() == "" {
+			host = net.JoinHostPort(uri.Hostname(), "1883")
+		}
+		return dialer.Dial("tcp", host)
+	case "ssl", "tls", "mqtts", "mqtt+ssl", "tcps":
+		if uri.Port() == "" {
+			host = net.JoinHostPort(uri.Hostname(), "8883")
+		}
+		cfg := options.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		return tls.DialWithDialer(dialer, "tcp", host, cfg)
+	}
+	return nil, fmt.Errorf("network emulation does not support scheme %q", uri.Scheme)
+}
+
+// ======================================================================
+// emulatedConn: 附加时延与带宽限制的连接
+// ======================================================================
+
+// errEmulatedReset 模拟断线后读写返回的错误。
+// 注意不能是 net.ErrClosed: paho 会把 "use of closed network connection" 视为主动关闭而不触发重连。
+var errEmulatedReset = errors.New("connection reset by peer (emulated network drop)")
+
+type emulatedConn struct {
+	net.Conn
+	em *netEmulator
+
+	closeOnce sync.Once
+	mu        sync.Mutex
+	broken    bool
+}
+
+// delay 按时延/抖动和带宽计算传输 n 字节的附加等待
+func (c *emulatedConn) delay(n int) time.Duration {
+	cond := c.em.cond
+	d := cond.Latency
+	if cond.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(cond.Jitter)))
+	}
+	if cond.Bandwidth > 0 && n > 0 {
+		d += time.Duration(int64(n) * int64(time.Second) / int64(cond.Bandwidth))
+	}
+	return d
+}
+
+func (c *emulatedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		time.Sleep(c.delay(n))
+	}
+	return n, c.mapErr(err)
+}
+
+func (c *emulatedConn) Write(b []byte) (int, error) {
+	time.Sleep(c.delay(len(b)))
+	n, err := c.Conn.Write(b)
+	return n, c.mapErr(err)
+}
+
+// reset 模拟链路中断: 关闭底层连接，之后的读写返回 errEmulatedReset
+func (c *emulatedConn) reset() {
+	c.mu.Lock()
+	c.broken = true
+	c.mu.Unlock()
+	c.Close()
+}
+
+func (c *emulatedConn) mapErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.broken {
+		return errEmulatedReset
+	}
+	return err
+}
+
+func (c *emulatedConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.em.mu.Lock()
+		delete(c.em.conns, c)
+		c.em.mu.Unlock()
+		err = c.Conn.Close()
+	})
+	return err
+}
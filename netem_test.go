@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 风暴循环随成员而非单台设备的生命周期启停
+func TestNetStormOutlivesFirstMember(t *testing.T) {
+	s := &netStorm{members: make(map[*netEmulator]struct{})}
+	cfg := StormConfig{Every: 20 * time.Millisecond, Outage: time.Millisecond}
+	first := &netEmulator{name: "first", storm: s, conns: make(map[*emulatedConn]struct{})}
+	second := &netEmulator{name: "second", storm: s, conns: make(map[*emulatedConn]struct{})}
+	s.join(first, cfg)
+	s.join(second, cfg)
+	s.leave(first)
+
+	waitFor(t, "storm hits remaining member", func() bool {
+		second.mu.Lock()
+		defer second.mu.Unlock()
+		return second.stormHit
+	})
+
+	s.leave(second)
+	s.mu.Lock()
+	running := s.done != nil
+	s.mu.Unlock()
+	if running {
+		t.Error("storm loop still running after last member left")
+	}
+}
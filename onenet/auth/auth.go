@@ -0,0 +1,164 @@
+// Package auth 实现 OneNET 设备接入的 Token 鉴权算法。
+//
+// Token 形如 version=2018-10-31&res=products%2F{pid}%2Fdevices%2F{name}&et=...&method=sha1&sign=...，
+// 其中 sign = base64(HMAC-method(base64decode(key), "et\nmethod\nres\nversion"))。
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version 当前 Token 算法版本
+const Version = "2018-10-31"
+
+// 签名方法
+const (
+	MethodMD5    = "md5"
+	MethodSHA1   = "sha1"
+	MethodSHA256 = "sha256"
+)
+
+// DeviceResource 设备级鉴权的 res
+func DeviceResource(productID, deviceName string) string {
+	return fmt.Sprintf("products/%s/devices/%s", productID, deviceName)
+}
+
+// ProductResource 产品级鉴权的 res
+func ProductResource(productID string) string {
+	return "products/" + productID
+}
+
+// Sign 计算 OneNET 平台要求的签名 (Sign)，key 为 base64 编码的 access key
+func Sign(key, stringForSignature, method string) (string, error) {
+	// 1. Key 参与计算前应先进行 base64decode
+	rawKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("base64 decode key failed: %w", err)
+	}
+
+	// 2. 选择 HMAC 算法的 Hash 构造函数
+	var hmacNew func() hash.Hash
+	switch strings.ToLower(method) {
+	case MethodMD5:
+		hmacNew = md5.New
+	case MethodSHA1:
+		hmacNew = sha1.New
+	case MethodSHA256:
+		hmacNew = sha256.New
+	default:
+		return "", fmt.Errorf("unsupported signature method: %s", method)
+	}
+
+	// 3. 计算 HMAC 签名并进行 Base64 编码
+	h := hmac.New(hmacNew, rawKey)
+	h.Write([]byte(stringForSignature))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// Token 按资源 res 与过期时间 et (Unix 秒) 构造完整的 Token 字符串 (MQTT Password)
+func Token(res string, et int64, key, method, version string) (string, error) {
+	// 1. 构造 StringForSignature (et, method, res, version 顺序，以 '\n' 分隔)
+	stringForSignature := fmt.Sprintf("%d\n%s\n%s\n%s", et, method, res, version)
+
+	// 2. 计算签名 sign
+	sign, err := Sign(key, stringForSignature, method)
+	if err != nil {
+		return "", err
+	}
+
+	// 3. 按参数名排序、URL 编码并连接
+	params := map[string]string{
+		"version": version,
+		"res":     res,
+		"et":      fmt.Sprintf("%d", et),
+		"method":  method,
+		"sign":    sign,
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	encoded := make([]string, 0, len(keys))
+	for _, k := range keys {
+		encoded = append(encoded, k+"="+url.QueryEscape(params[k]))
+	}
+	return strings.Join(encoded, "&"), nil
+}
+
+// 校验失败的原因
+var (
+	ErrSignatureMismatch = errors.New("signature mismatch")
+	ErrExpired           = errors.New("token expired")
+)
+
+// TokenFields Token 解码后的各字段
+type TokenFields struct {
+	Version string
+	Res     string
+	ET      int64 // 过期时间 (Unix 秒)
+	Method  string
+	Sign    string
+}
+
+// Expiry 过期时间
+func (f TokenFields) Expiry() time.Time {
+	return time.Unix(f.ET, 0)
+}
+
+// ParseToken 解码 Token 字符串 (不校验签名)
+func ParseToken(token string) (TokenFields, error) {
+	q, err := url.ParseQuery(token)
+	if err != nil {
+		return TokenFields{}, fmt.Errorf("parse token: %w", err)
+	}
+	for _, name := range []string{"version", "res", "et", "method", "sign"} {
+		if q.Get(name) == "" {
+			return TokenFields{}, fmt.Errorf("parse token: missing %s", name)
+		}
+	}
+	f := TokenFields{
+		Version: q.Get("version"),
+		Res:     q.Get("res"),
+		Method:  q.Get("method"),
+		Sign:    q.Get("sign"),
+	}
+	if f.ET, err = strconv.ParseInt(q.Get("et"), 10, 64); err != nil {
+		return TokenFields{}, fmt.Errorf("parse token: invalid et: %w", err)
+	}
+	return f, nil
+}
+
+// Verify 用 key 校验 Token 的签名与有效期 (以 now 为当前时间)，返回解码后的字段；
+// 签名不符返回 ErrSignatureMismatch，已过期返回 ErrExpired
+func Verify(token, key string, now time.Time) (TokenFields, error) {
+	f, err := ParseToken(token)
+	if err != nil {
+		return f, err
+	}
+	stringForSignature := fmt.Sprintf("%d\n%s\n%s\n%s", f.ET, f.Method, f.Res, f.Version)
+	want, err := Sign(key, stringForSignature, f.Method)
+	if err != nil {
+		return f, err
+	}
+	if !hmac.Equal([]byte(want), []byte(f.Sign)) {
+		return f, ErrSignatureMismatch
+	}
+	if !now.Before(f.Expiry()) {
+		return f, ErrExpired
+	}
+	return f, nil
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 2202 / RFC 4231 的 HMAC 测试向量 ("Hi There")，key 与结果均为 base64
+func TestSign(t *testing.T) {
+	tests := []struct {
+		method string
+		key    string
+		want   string
+	}{
+		{"md5", "CwsLCwsLCwsLCwsLCwsLCw==", "kpRyejY4uxwT9I74FYv8nQ=="},
+		{"sha1", "CwsLCwsLCwsLCwsLCwsLCwsLCws=", "thcxhlUFcmTii8C2+zeMjvFGvgA="},
+		{"sha256", "CwsLCwsLCwsLCwsLCwsLCwsLCws=", "sDRMYdjbOFNcqK/OrwvxK4gdwgDJgz2nJuk3bC4yz/c="},
+		{"SHA1", "CwsLCwsLCwsLCwsLCwsLCwsLCws=", "thcxhlUFcmTii8C2+zeMjvFGvgA="},
+	}
+	for _, tt := range tests {
+		got, err := Sign(tt.key, "Hi There", tt.method)
+		if err != nil {
+			t.Errorf("Sign(%s): %v", tt.method, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Sign(%s) = %s, want %s", tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestSignErrors(t *testing.T) {
+	if _, err := Sign("not base64!", "x", "sha1"); err == nil {
+		t.Error("invalid base64 key: expected error")
+	}
+	if _, err := Sign("CwsLCw==", "x", "sha512"); err == nil {
+		t.Error("unsupported method: expected error")
+	}
+}
+
+// OneNET Token 算法文档示例，version/res/et/method/key 原样使用 (产品级 res)，
+// 文档给出的待签名串为 "1537255523\nsha1\nproducts/123123\n2018-10-31"；
+// wantSign 为该串以示例 key 计算的 HMAC-SHA1 (Sign 由 TestSign 以 RFC 2202 向量校验)
+func TestToken(t *testing.T) {
+	const (
+		key     = "KuF3NT/jUBJ62LNBB/A8XZA9CqS3Cu79B/ABmfA1UCw="
+		res     = "products/123123"
+		et      = 1537255523
+		wantSign = "lsaPSiiGvEFFjXu5WU7a6IkScqE="
+	)
+	got, err := Token(res, et, key, MethodSHA1, Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "et=1537255523&method=sha1&res=products%2F123123&sign=lsaPSiiGvEFFjXu5WU7a6IkScqE%3D&version=2018-10-31"
+	if got != want {
+		t.Errorf("Token\n got: %s\nwant: %s", got, want)
+	}
+	if sign, _ := Sign(key, "1537255523\nsha1\nproducts/123123\n2018-10-31", MethodSHA1); sign != wantSign {
+		t.Errorf("Sign(documented string) = %s, want %s", sign, wantSign)
+	}
+}
+
+func TestResources(t *testing.T) {
+	if got := DeviceResource("123123", "sensor1"); got != "products/123123/devices/sensor1" {
+		t.Errorf("DeviceResource = %q", got)
+	}
+	if got := ProductResource("123123"); got != "products/123123" {
+		t.Errorf("ProductResource = %q", got)
+	}
+}
+
+func TestParseToken(t *testing.T) {
+	const token = "version=2018-10-31&res=products%2F123123&et=1537255523&method=sha1&sign=lsaPSiiGvEFFjXu5WU7a6IkScqE%3D"
+	f, err := ParseToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := TokenFields{Version: Version, Res: "products/123123", ET: 1537255523, Method: MethodSHA1, Sign: "lsaPSiiGvEFFjXu5WU7a6IkScqE="}
+	if f != want {
+		t.Errorf("ParseToken = %+v, want %+v", f, want)
+	}
+	for _, bad := range []string{"", "et=1&method=sha1&res=r&version=v", "et=soon&method=sha1&res=r&sign=s&version=v", "%zz"} {
+		if _, err := ParseToken(bad); err == nil {
+			t.Errorf("ParseToken(%q): expected error", bad)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	const key = "KuF3NT/jUBJ62LNBB/A8XZA9CqS3Cu79B/ABmfA1UCw="
+	now := time.Unix(1700000000, 0)
+	token, err := Token(DeviceResource("pid", "dev1"), now.Add(time.Hour).Unix(), key, MethodSHA256, Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Verify(token, key, now)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if f.Res != "products/pid/devices/dev1" || f.Method != MethodSHA256 {
+		t.Errorf("fields = %+v", f)
+	}
+	if _, err := Verify(token, key, now.Add(2*time.Hour)); !errors.Is(err, ErrExpired) {
+		t.Errorf("expired token: err = %v", err)
+	}
+	if _, err := Verify(token, "CwsLCwsLCwsLCwsLCwsLCw==", now); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("wrong key: err = %v", err)
+	}
+	tampered := strings.Replace(token, "dev1", "dev2", 1)
+	if _, err := Verify(tampered, key, now); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("tampered res: err = %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+// Package device 是 OneNET 物模型设备端 SDK: 接入配置 (Token 鉴权的 MQTT 连接选项)、
+// 物模型消息格式，以及在 Transport (MQTT 连接或 HTTP 接入) 之上按 $sys Topic
+// 收发属性、事件与回复的 Client。
+//
+//	cfg := device.NewConfig(productID, deviceName, key, device.WithBroker("ssl://host:8883"))
+//	opts, _ := cfg.MQTTOptions()
+//	conn := mqtt.NewClient(opts)
+//	conn.Connect().Wait()
+//	c := device.NewClient(conn, productID, deviceName)
+//	c.PostProperties(id, map[string]device.TimedValue{"temperature": {Value: 25}})
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"qsiot_server/onenet/topics"
+)
+
+// Kind 上行消息类别，供中间件按类别处理 (故障注入、限流、统计等)
+type Kind string
+
+const (
+	KindPropertyPost Kind = "property_post"
+	KindEventPost    Kind = "event_post"
+	KindSetReply     Kind = "set_reply"
+	KindGetReply     Kind = "get_reply"
+	KindServiceReply Kind = "service_reply"
+	KindNtpRequest   Kind = "ntp_request"
+	KindRaw          Kind = "raw"
+)
+
+// PublishFunc 发布一条上行消息
+type PublishFunc func(kind Kind, topic string, payload []byte) error
+
+// Middleware 包装发布函数，可在发布前后修改、延迟、丢弃或记录消息
+type Middleware func(next PublishFunc) PublishFunc
+
+// ErrSubscribeRejected Broker 在 SUBACK 中拒绝订阅 (0x80)
+var ErrSubscribeRejected = errors.New("rejected by broker")
+
+// PublishOptions 一类上行消息的 QoS 与保留标志
+type PublishOptions struct {
+	QoS    byte
+	Retain bool
+}
+
+// Client 在 Transport 之上收发单个设备的物模型消息
+type Client struct {
+	transport  Transport
+	productID  string
+	deviceName string
+	qos        byte
+	subQoS     *byte                   // 订阅 QoS，nil 时同 qos
+	kinds      map[Kind]PublishOptions // 按类别覆盖的发布选项
+	middleware []Middleware
+	publish    PublishFunc
+}
+
+// ClientOption 修改 Client 的选项
+type ClientOption func(*Client)
+
+// WithQoS 设置发布与订阅的 QoS (默认 1)
+func WithQoS(qos byte) ClientOption {
+	return func(c *Client) { c.qos = qos }
+}
+
+// WithPublishOptions 设置某一类上行消息的 QoS 与保留标志 (未设置的类别使用 WithQoS、不保留)
+func WithPublishOptions(kind Kind, o PublishOptions) ClientOption {
+	return func(c *Client) {
+		if c.kinds == nil {
+			c.kinds = make(map[Kind]PublishOptions)
+		}
+		c.kinds[kind] = o
+	}
+}
+
+// WithSubscribeQoS 单独设置订阅的 QoS (默认同 WithQoS)
+func WithSubscribeQoS(qos byte) ClientOption {
+	return func(c *Client) { c.subQoS = &qos }
+}
+
+// WithMiddleware 添加发布中间件，先添加的位于外层 (先执行)
+func WithMiddleware(mw ...Middleware) ClientOption {
+	return func(c *Client) { c.middleware = append(c.middleware, mw...) }
+}
+
+// NewClient 基于已创建的 MQTT 连接 (paho 或兼容实现) 创建设备 Client
+func NewClient(conn mqtt.Client, productID, deviceName string, opts ...ClientOption) *Client {
+	return NewTransportClient(NewMQTTTransport(conn), productID, deviceName, opts...)
+}
+
+// NewTransportClient 基于任意 Transport (如 HTTPTransport) 创建设备 Client
+func NewTransportClient(t Transport, productID, deviceName string, opts ...ClientOption) *Client {
+	c := &Client{transport: t, productID: productID, deviceName: deviceName, qos: 1}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.publish = c.send
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		c.publish = c.middleware[i](c.publish)
+	}
+	return c
+}
+
+// ProductID 产品 ID
+func (c *Client) ProductID() string { return c.productID }
+
+// DeviceName 设备名
+func (c *Client) DeviceName() string { return c.deviceName }
+
+// Transport 返回底层 Transport
+func (c *Client) Transport() Transport { return c.transport }
+
+// IsConnected 底层连接是否在线
+func (c *Client) IsConnected() bool { return c.transport.IsConnected() }
+
+// Topic 返回本设备的完整 Topic ($sys/{pid}/{device}/{suffix})
+func (c *Client) Topic(suffix string) string {
+	return topics.Device(c.productID, c.deviceName, suffix)
+}
+
+// PublishOptions 返回 kind 类上行消息发布时使用的 QoS 与保留标志
+func (c *Client) PublishOptions(kind Kind) PublishOptions {
+	if o, ok := c.kinds[kind]; ok {
+		return o
+	}
+	return PublishOptions{QoS: c.qos}
+}
+
+// send 直接经 Transport 发布 (中间件链的末端)
+func (c *Client) send(kind Kind, topic string, payload []byte) error {
+	o := c.PublishOptions(kind)
+	return c.transport.Publish(topic, o.QoS, o.Retain, payload)
+}
+
+// Publish 经中间件发布一条上行消息
+func (c *Client) Publish(kind Kind, topic string, payload []byte) error {
+	return c.publish(kind, topic, payload)
+}
+
+// PublishJSON 将 v 编码为 JSON 后发布
+func (c *Client) PublishJSON(kind Kind, topic string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", kind, err)
+	}
+	return c.Publish(kind, topic, payload)
+}
+
+// PostProperties 上报属性 (thing/property/post)
+func (c *Client) PostProperties(id string, props map[string]TimedValue) error {
+	req := Request{ID: id, Version: ProtocolVersion, Params: props}
+	return c.PublishJSON(KindPropertyPost, c.Topic(topics.PropertyPost), req)
+}
+
+// PostEvents 上报事件 (thing/event/post)，events 为 事件标识符 -> 输出参数
+func (c *Client) PostEvents(id string, events map[string]TimedValue) error {
+	req := Request{ID: id, Version: ProtocolVersion, Params: events}
+	return c.PublishJSON(KindEventPost, c.Topic(topics.EventPost), req)
+}
+
+// PostPack 批量上报属性与事件 (thing/pack/post)
+func (c *Client) PostPack(id string, props map[string]TimedValue, events []PackEvent) error {
+	if props == nil {
+		props = map[string]TimedValue{}
+	}
+	req := Request{ID: id, Version: ProtocolVersion, Params: map[string]interface{}{
+		"properties": props,
+		"events":     events,
+	}}
+	return c.PublishJSON(KindEventPost, c.Topic(topics.PackPost), req)
+}
+
+// Reply 回复平台请求，suffix 为回复 Topic 后缀 (如 topics.PropertySetReply)
+func (c *Client) Reply(kind Kind, suffix string, r Reply) error {
+	if r.Version == "" {
+		r.Version = ProtocolVersion
+	}
+	return c.PublishJSON(kind, c.Topic(suffix), r)
+}
+
+// Subscribe 订阅 Topic 并等待完成，Broker 在 SUBACK 中拒绝时返回 ErrSubscribeRejected
+func (c *Client) Subscribe(topic string, handler mqtt.MessageHandler) error {
+	qos := c.qos
+	if c.subQoS != nil {
+		qos = *c.subQoS
+	}
+	return c.transport.Subscribe(topic, qos, handler)
+}
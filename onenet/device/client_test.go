@@ -0,0 +1,190 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"qsiot_server/onenet/topics"
+)
+
+// doneToken 立即完成的 mqtt.Token
+type doneToken struct{ err error }
+
+func (t doneToken) Wait() bool                     { return true }
+func (t doneToken) WaitTimeout(time.Duration) bool { return true }
+func (t doneToken) Done() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+func (t doneToken) Error() error { return t.err }
+
+type sent struct {
+	topic    string
+	qos      byte
+	retained bool
+	payload  []byte
+}
+
+// fakeConn 记录发布的消息，未实现的 mqtt.Client 方法调用时 panic
+type fakeConn struct {
+	mqtt.Client
+	sent       []sent
+	subscribed map[string]byte
+	publishErr error
+}
+
+func (c *fakeConn) IsConnected() bool { return true }
+
+func (c *fakeConn) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.sent = append(c.sent, sent{topic, qos, retained, payload.([]byte)})
+	return doneToken{c.publishErr}
+}
+
+func (c *fakeConn) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	if c.subscribed == nil {
+		c.subscribed = map[string]byte{}
+	}
+	c.subscribed[topic] = qos
+	return doneToken{}
+}
+
+func decode(t *testing.T, b []byte) map[string]interface{} {
+	t.Helper()
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatalf("invalid payload %s: %v", b, err)
+	}
+	return m
+}
+
+func TestPostProperties(t *testing.T) {
+	conn := &fakeConn{}
+	c := NewClient(conn, "pid", "dev1")
+	err := c.PostProperties("1", map[string]TimedValue{"relay": {Value: 1}, "csq": {Value: 20, Time: 1700000000000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conn.sent) != 1 {
+		t.Fatalf("sent %d messages", len(conn.sent))
+	}
+	msg := conn.sent[0]
+	if msg.topic != "$sys/pid/dev1/thing/property/post" || msg.qos != 1 {
+		t.Errorf("topic/qos = %s/%d", msg.topic, msg.qos)
+	}
+	want := map[string]interface{}{
+		"id":      "1",
+		"version": ProtocolVersion,
+		"params": map[string]interface{}{
+			"relay": map[string]interface{}{"value": float64(1)},
+			"csq":   map[string]interface{}{"value": float64(20), "time": float64(1700000000000)},
+		},
+	}
+	if got := decode(t, msg.payload); !reflect.DeepEqual(got, want) {
+		t.Errorf("payload = %v, want %v", got, want)
+	}
+}
+
+func TestReply(t *testing.T) {
+	conn := &fakeConn{}
+	c := NewClient(conn, "pid", "dev1", WithQoS(0))
+	if err := c.Reply(KindGetReply, topics.PropertyGetReply, Reply{ID: "7", Code: CodeSuccess, Data: map[string]int{"relay": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	msg := conn.sent[0]
+	if msg.topic != "$sys/pid/dev1/thing/property/get_reply" || msg.qos != 0 {
+		t.Errorf("topic/qos = %s/%d", msg.topic, msg.qos)
+	}
+	r, err := ParseReply(msg.payload)
+	if err != nil || r.ID != "7" || r.Version != ProtocolVersion || !r.OK() {
+		t.Errorf("reply = %+v, %v", r, err)
+	}
+}
+
+func TestPublishOptionsPerKind(t *testing.T) {
+	conn := &fakeConn{}
+	c := NewClient(conn, "pid", "dev1",
+		WithPublishOptions(KindPropertyPost, PublishOptions{QoS: 0, Retain: true}),
+		WithPublishOptions(KindSetReply, PublishOptions{QoS: 0}),
+		WithSubscribeQoS(0))
+	c.PostProperties("1", map[string]TimedValue{"relay": {Value: 1}})
+	c.Reply(KindSetReply, topics.PropertySetReply, Reply{ID: "2", Code: 200})
+	c.PostEvents("3", map[string]TimedValue{"alarm": {Value: map[string]interface{}{}}})
+	if err := c.Subscribe(c.Topic(topics.PropertySet), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		qos      byte
+		retained bool
+	}{{0, true}, {0, false}, {1, false}}
+	if len(conn.sent) != len(want) {
+		t.Fatalf("sent %d messages", len(conn.sent))
+	}
+	for i, w := range want {
+		if got := conn.sent[i]; got.qos != w.qos || got.retained != w.retained {
+			t.Errorf("%s: qos/retained = %d/%v, want %d/%v", got.topic, got.qos, got.retained, w.qos, w.retained)
+		}
+	}
+	if qos := conn.subscribed[c.Topic(topics.PropertySet)]; qos != 0 {
+		t.Errorf("subscribe qos = %d", qos)
+	}
+	if o := c.PublishOptions(KindNtpRequest); o != (PublishOptions{QoS: 1}) {
+		t.Errorf("default options = %+v", o)
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls []string
+	mw := func(name string) Middleware {
+		return func(next PublishFunc) PublishFunc {
+			return func(kind Kind, topic string, payload []byte) error {
+				calls = append(calls, name+":"+string(kind))
+				return next(kind, topic, payload)
+			}
+		}
+	}
+	drop := func(next PublishFunc) PublishFunc {
+		return func(kind Kind, topic string, payload []byte) error {
+			if kind == KindRaw {
+				return nil
+			}
+			return next(kind, topic, payload)
+		}
+	}
+	conn := &fakeConn{}
+	c := NewClient(conn, "pid", "dev1", WithMiddleware(mw("outer"), mw("inner")), WithMiddleware(drop))
+
+	if err := c.Publish(KindRaw, "raw/up", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Publish(KindNtpRequest, c.Topic(topics.NtpRequest), []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"outer:raw", "inner:raw", "outer:ntp_request", "inner:ntp_request"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	if len(conn.sent) != 1 || conn.sent[0].topic != "$sys/pid/dev1/thing/ntp/request" {
+		t.Errorf("sent = %v", conn.sent)
+	}
+}
+
+func TestPublishError(t *testing.T) {
+	conn := &fakeConn{publishErr: errors.New("not connected")}
+	c := NewClient(conn, "pid", "dev1")
+	if err := c.PostEvents("1", map[string]TimedValue{"alarm": {Value: map[string]int{"IN1": 1}}}); err == nil {
+		t.Error("expected publish error")
+	}
+	if err := c.Subscribe(c.Topic(topics.PropertySet), nil); err != nil {
+		t.Errorf("Subscribe: %v", err)
+	}
+	if qos, ok := conn.subscribed["$sys/pid/dev1/thing/property/set"]; !ok || qos != 1 {
+		t.Errorf("subscribed = %v", conn.subscribed)
+	}
+}
@@ -0,0 +1,127 @@
+package device
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"qsiot_server/onenet/auth"
+)
+
+// 默认连接参数
+const (
+	DefaultKeepAlive      = 60 * time.Second
+	DefaultConnectTimeout = 10 * time.Second
+	DefaultTokenTTL       = 1 * time.Hour
+)
+
+// Config 设备接入配置
+type Config struct {
+	ProductID  string
+	DeviceName string
+	AccessKey  string // base64 编码的设备密钥 (或产品 access key)
+	BrokerURL  string // tcp://host:1883 或 ssl://host:8883
+
+	AuthMethod     string        // 签名方法，默认 sha1
+	TokenTTL       time.Duration // Token 有效期
+	KeepAlive      time.Duration
+	ConnectTimeout time.Duration
+	TLSConfig      *tls.Config // ssl:// 时使用，为 nil 时按系统根证书校验
+
+	CleanSession bool       // 默认 true；false 时以持久会话连接，Broker 保留订阅与未完成的 QoS 1 消息
+	Store        mqtt.Store // paho 未完成消息的存储 (如 mqtt.NewFileStore)，为 nil 时使用内存存储
+}
+
+// Option 修改 Config 的选项
+type Option func(*Config)
+
+// WithBroker 设置 Broker 地址
+func WithBroker(url string) Option {
+	return func(c *Config) { c.BrokerURL = url }
+}
+
+// WithAuthMethod 设置 Token 签名方法 (md5/sha1/sha256)
+func WithAuthMethod(method string) Option {
+	return func(c *Config) { c.AuthMethod = method }
+}
+
+// WithTokenTTL 设置 Token 有效期
+func WithTokenTTL(ttl time.Duration) Option {
+	return func(c *Config) { c.TokenTTL = ttl }
+}
+
+// WithKeepAlive 设置 MQTT 心跳间隔
+func WithKeepAlive(d time.Duration) Option {
+	return func(c *Config) { c.KeepAlive = d }
+}
+
+// WithConnectTimeout 设置单次连接超时
+func WithConnectTimeout(d time.Duration) Option {
+	return func(c *Config) { c.ConnectTimeout = d }
+}
+
+// WithTLSConfig 设置 ssl:// 连接使用的 TLS 配置
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *Config) { c.TLSConfig = cfg }
+}
+
+// WithCleanSession 设置是否以清除会话方式连接 (默认 true)
+func WithCleanSession(clean bool) Option {
+	return func(c *Config) { c.CleanSession = clean }
+}
+
+// WithStore 设置 paho 未完成消息的存储，配合持久会话可在进程重启后重发未确认的消息
+func WithStore(store mqtt.Store) Option {
+	return func(c *Config) { c.Store = store }
+}
+
+// NewConfig 创建设备接入配置，未指定的参数取默认值
+func NewConfig(productID, deviceName, accessKey string, opts ...Option) Config {
+	c := Config{
+		ProductID:      productID,
+		DeviceName:     deviceName,
+		AccessKey:      accessKey,
+		AuthMethod:     auth.MethodSHA1,
+		TokenTTL:       DefaultTokenTTL,
+		KeepAlive:      DefaultKeepAlive,
+		ConnectTimeout: DefaultConnectTimeout,
+		CleanSession:   true,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// Token 生成设备级鉴权 Token (有效期 TokenTTL)
+func (c Config) Token() (string, error) {
+	et := time.Now().Add(c.TokenTTL).Unix()
+	return auth.Token(auth.DeviceResource(c.ProductID, c.DeviceName), et, c.AccessKey, c.AuthMethod, auth.Version)
+}
+
+// MQTTOptions 构造 paho 连接选项: ClientID 为设备名，Username 为产品 ID，Password 为 Token
+func (c Config) MQTTOptions() (*mqtt.ClientOptions, error) {
+	token, err := c.Token()
+	if err != nil {
+		return nil, fmt.Errorf("generate OneNET token: %w", err)
+	}
+
+	opts := mqtt.NewClientOptions().AddBroker(c.BrokerURL)
+	opts.SetClientID(c.DeviceName)
+	opts.SetUsername(c.ProductID)
+	opts.SetPassword(token)
+	opts.SetKeepAlive(c.KeepAlive)
+	opts.SetPingTimeout(1 * time.Second)
+	opts.SetCleanSession(c.CleanSession)
+	if c.Store != nil {
+		opts.SetStore(c.Store)
+	}
+	opts.SetConnectTimeout(c.ConnectTimeout)
+	if c.TLSConfig != nil && (strings.HasPrefix(c.BrokerURL, "ssl") || strings.HasPrefix(c.BrokerURL, "tls")) {
+		opts.SetTLSConfig(c.TLSConfig)
+	}
+	return opts, nil
+}
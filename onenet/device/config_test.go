@@ -0,0 +1,83 @@
+package device
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"qsiot_server/onenet/auth"
+)
+
+func TestConfigToken(t *testing.T) {
+	const key = "KuF3NT/jUBJ62LNBB/A8XZA9CqS3Cu79B/ABmfA1UCw="
+	cfg := NewConfig("5S34OM4Rc6", "866560088910415", key)
+	token, err := cfg.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := url.ParseQuery(token)
+	if err != nil {
+		t.Fatalf("token is not a query string: %v", err)
+	}
+	if got := q.Get("res"); got != "products/5S34OM4Rc6/devices/866560088910415" {
+		t.Errorf("res = %q", got)
+	}
+	if q.Get("method") != auth.MethodSHA1 || q.Get("version") != auth.Version {
+		t.Errorf("method/version = %q/%q", q.Get("method"), q.Get("version"))
+	}
+	et, err := strconv.ParseInt(q.Get("et"), 10, 64)
+	if err != nil {
+		t.Fatalf("et: %v", err)
+	}
+	if d := time.Until(time.Unix(et, 0)); d < DefaultTokenTTL-time.Minute || d > DefaultTokenTTL {
+		t.Errorf("token expires in %v, want about %v", d, DefaultTokenTTL)
+	}
+
+	// 签名应与按同一 et 重新构造的结果一致
+	want, _ := auth.Token(q.Get("res"), et, key, auth.MethodSHA1, auth.Version)
+	if token != want {
+		t.Errorf("token = %s, want %s", token, want)
+	}
+
+	if _, err := NewConfig("p", "d", "###").MQTTOptions(); err == nil {
+		t.Error("invalid key: expected error")
+	}
+}
+
+func TestConfigMQTTOptions(t *testing.T) {
+	cfg := NewConfig("pid", "dev1", "KuF3NT/jUBJ62LNBB/A8XZA9CqS3Cu79B/ABmfA1UCw=",
+		WithBroker("tcp://127.0.0.1:1883"), WithAuthMethod(auth.MethodSHA256), WithKeepAlive(30*time.Second))
+	opts, err := cfg.MQTTOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.ClientID != "dev1" || opts.Username != "pid" {
+		t.Errorf("client id/username = %q/%q", opts.ClientID, opts.Username)
+	}
+	if opts.KeepAlive != 30 {
+		t.Errorf("keepalive = %d", opts.KeepAlive)
+	}
+	q, err := url.ParseQuery(opts.Password)
+	if err != nil || q.Get("method") != auth.MethodSHA256 || q.Get("res") != "products/pid/devices/dev1" {
+		t.Errorf("password = %q", opts.Password)
+	}
+	if len(opts.Servers) != 1 || opts.Servers[0].Host != "127.0.0.1:1883" {
+		t.Errorf("servers = %v", opts.Servers)
+	}
+	if !opts.CleanSession || opts.Store != nil {
+		t.Errorf("clean session/store = %v/%v", opts.CleanSession, opts.Store)
+	}
+
+	store := mqtt.NewMemoryStore()
+	opts, err = NewConfig("pid", "dev1", "KuF3NT/jUBJ62LNBB/A8XZA9CqS3Cu79B/ABmfA1UCw=",
+		WithCleanSession(false), WithStore(store)).MQTTOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.CleanSession || opts.Store != store {
+		t.Errorf("persistent session: clean session/store = %v/%v", opts.CleanSession, opts.Store)
+	}
+}
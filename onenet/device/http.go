@@ -0,0 +1,188 @@
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"qsiot_server/onenet/topics"
+)
+
+// ======================================================================
+// HTTP 接入
+//
+// 低功耗设备不维持长连接，每条上行消息为一次请求，鉴权与 MQTT 相同 (设备级 Token):
+//
+//	POST {base}/{suffix}    上行消息，suffix 为 Topic 后缀 (如 thing/property/post)，
+//	                        请求体与 MQTT 载荷相同；响应体为平台回复 (post/reply、ntp/response)，可为空
+//	GET  {base}/commands    拉取待处理的下行消息: [{"topic": "thing/property/set", "payload": {...}}]
+//
+// 请求头 token 携带设备级 Token。同步回复与拉取到的下行消息都按完整 Topic
+// 分发给 Subscribe 注册的 handler，因此 Client 的上层逻辑与 MQTT 接入一致。
+// ======================================================================
+
+// HTTPCommandsPath 拉取下行消息的路径
+const HTTPCommandsPath = "commands"
+
+// HTTPTokenHeader 携带设备 Token 的请求头
+const HTTPTokenHeader = "token"
+
+// HTTPCommand 一条待设备处理的下行消息 (topic 为 Topic 后缀)
+type HTTPCommand struct {
+	Topic   string          `json:"topic"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// HTTPTransport 基于 HTTP 请求的 Transport
+type HTTPTransport struct {
+	baseURL string
+	cfg     Config
+	client  *http.Client
+
+	mu       sync.Mutex
+	handlers map[string]mqtt.MessageHandler
+}
+
+// NewHTTPTransport 创建 HTTP 接入，baseURL 为接入地址，cfg 提供设备身份与 Token 参数
+// (请求超时取 cfg.ConnectTimeout)
+func NewHTTPTransport(baseURL string, cfg Config) *HTTPTransport {
+	return &HTTPTransport{
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		cfg:      cfg,
+		client:   &http.Client{Timeout: cfg.ConnectTimeout},
+		handlers: make(map[string]mqtt.MessageHandler),
+	}
+}
+
+// IsConnected HTTP 接入无连接状态，始终可以发起请求
+func (t *HTTPTransport) IsConnected() bool { return true }
+
+// Subscribe 登记下行消息的 handler (不产生网络请求)
+func (t *HTTPTransport) Subscribe(topic string, qos byte, handler mqtt.MessageHandler) error {
+	t.mu.Lock()
+	t.handlers[topic] = handler
+	t.mu.Unlock()
+	return nil
+}
+
+// Publish 以一次 POST 请求发送上行消息，平台的同步回复分发给对应的回复 Topic (qos、retained 不适用)
+func (t *HTTPTransport) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	suffix, err := t.suffix(topic)
+	if err != nil {
+		return err
+	}
+	body, err := t.do(http.MethodPost, suffix, payload)
+	if err != nil {
+		return err
+	}
+	if reply := responseSuffix(suffix); reply != "" && len(bytes.TrimSpace(body)) > 0 {
+		t.dispatch(t.topic(reply), body)
+	}
+	return nil
+}
+
+// Poll 拉取一次下行消息并分发，返回消息条数
+func (t *HTTPTransport) Poll() (int, error) {
+	body, err := t.do(http.MethodGet, HTTPCommandsPath, nil)
+	if err != nil {
+		return 0, err
+	}
+	var cmds []HTTPCommand
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &cmds); err != nil {
+			return 0, fmt.Errorf("parse commands: %w", err)
+		}
+	}
+	for _, cmd := range cmds {
+		t.dispatch(t.topic(cmd.Topic), cmd.Payload)
+	}
+	return len(cmds), nil
+}
+
+func (t *HTTPTransport) do(method, path string, payload []byte) ([]byte, error) {
+	token, err := t.cfg.Token()
+	if err != nil {
+		return nil, fmt.Errorf("generate OneNET token: %w", err)
+	}
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, t.baseURL+"/"+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(HTTPTokenHeader, token)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// suffix 校验 topic 属于本设备并返回 Topic 后缀
+func (t *HTTPTransport) suffix(topic string) (string, error) {
+	pid, dev, suffix, ok := topics.ParseDevice(topic)
+	if !ok || pid != t.cfg.ProductID || dev != t.cfg.DeviceName {
+		return "", fmt.Errorf("topic %s does not belong to device %s", topic, t.cfg.DeviceName)
+	}
+	return suffix, nil
+}
+
+func (t *HTTPTransport) topic(suffix string) string {
+	return topics.Device(t.cfg.ProductID, t.cfg.DeviceName, suffix)
+}
+
+func (t *HTTPTransport) dispatch(topic string, payload []byte) {
+	t.mu.Lock()
+	handler := t.handlers[topic]
+	t.mu.Unlock()
+	if handler != nil {
+		handler(nil, &inboundMessage{topic: topic, payload: payload})
+	}
+}
+
+// responseSuffix 上行消息的同步回复对应的 Topic 后缀 (无回复时为空)
+func responseSuffix(suffix string) string {
+	switch suffix {
+	case topics.PropertyPost, topics.EventPost, topics.PackPost:
+		return suffix + "/reply"
+	case topics.NtpRequest:
+		return topics.NtpResponse
+	}
+	return ""
+}
+
+// inboundMessage 以 mqtt.Message 形式交给 handler 的下行消息 (HTTP 拉取或 MQTT 5 连接)
+type inboundMessage struct {
+	topic    string
+	qos      byte
+	retained bool
+	payload  []byte
+}
+
+func (m *inboundMessage) Duplicate() bool   { return false }
+func (m *inboundMessage) Qos() byte         { return m.qos }
+func (m *inboundMessage) Retained() bool    { return m.retained }
+func (m *inboundMessage) Topic() string     { return m.topic }
+func (m *inboundMessage) MessageID() uint16 { return 0 }
+func (m *inboundMessage) Payload() []byte   { return m.payload }
+func (m *inboundMessage) Ack()              {}
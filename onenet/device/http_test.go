@@ -0,0 +1,85 @@
+package device
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"qsiot_server/onenet/auth"
+	"qsiot_server/onenet/topics"
+)
+
+const testKey = "KuF3NT/jUBJ62LNBB/A8XZA9CqS3Cu79B/ABmfA1UCw="
+
+func TestHTTPTransport(t *testing.T) {
+	var posted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, err := auth.Verify(r.Header.Get(HTTPTokenHeader), testKey, time.Now())
+		if err != nil || f.Res != auth.DeviceResource("pid", "dev1") {
+			http.Error(w, "bad token", http.StatusUnauthorized)
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/api/")
+		switch {
+		case r.Method == http.MethodGet && path == HTTPCommandsPath:
+			io.WriteString(w, `[{"topic":"thing/property/set","payload":{"id":"9","params":{"relay":1}}}]`)
+		case r.Method == http.MethodPost && path == topics.PropertyPost:
+			body, _ := io.ReadAll(r.Body)
+			posted = append(posted, string(body))
+			io.WriteString(w, `{"id":"1","code":200,"msg":"success"}`)
+		case r.Method == http.MethodPost && path == topics.PropertySetReply:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tr := NewHTTPTransport(srv.URL+"/api/", NewConfig("pid", "dev1", testKey))
+	c := NewTransportClient(tr, "pid", "dev1")
+	got := map[string]string{}
+	handler := func(client mqtt.Client, msg mqtt.Message) { got[msg.Topic()] = string(msg.Payload()) }
+	for _, sx := range []string{topics.PropertyPostReply, topics.PropertySet} {
+		if err := c.Subscribe(c.Topic(sx), handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.PostProperties("1", map[string]TimedValue{"relay": {Value: 0}}); err != nil {
+		t.Fatalf("PostProperties: %v", err)
+	}
+	if len(posted) != 1 || !strings.Contains(posted[0], `"relay":{"value":0}`) {
+		t.Errorf("posted = %v", posted)
+	}
+	if r := got["$sys/pid/dev1/thing/property/post/reply"]; !strings.Contains(r, `"code":200`) {
+		t.Errorf("post reply = %q", r)
+	}
+
+	n, err := tr.Poll()
+	if err != nil || n != 1 {
+		t.Fatalf("Poll = %d, %v", n, err)
+	}
+	if r := got["$sys/pid/dev1/thing/property/set"]; !strings.Contains(r, `"relay":1`) {
+		t.Errorf("set command = %q", r)
+	}
+	if err := c.Reply(KindSetReply, topics.PropertySetReply, Reply{ID: "9", Code: CodeSuccess}); err != nil {
+		t.Errorf("Reply: %v", err)
+	}
+
+	// 非 2xx 响应、其他设备的 Topic、错误的密钥均返回错误
+	if err := c.Publish(KindRaw, c.Topic(topics.RawUp), []byte("x")); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("unknown endpoint: err = %v", err)
+	}
+	if err := c.Publish(KindRaw, topics.Device("pid", "dev2", topics.PropertyPost), []byte("{}")); err == nil {
+		t.Error("foreign topic: expected error")
+	}
+	bad := NewHTTPTransport(srv.URL+"/api", NewConfig("pid", "dev1", "CwsLCwsLCwsLCwsLCwsLCw=="))
+	if _, err := bad.Poll(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("wrong key: err = %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ProtocolVersion OneNET 物模型消息协议版本
+const ProtocolVersion = "1.0"
+
+// 常用回复 code
+const (
+	CodeSuccess    = 200
+	CodeBadRequest = 400
+)
+
+// Request 物模型请求消息: 设备上报 (property/post、event/post ...) 与平台下发 (property/set、property/get)
+//
+//	{"id": "123", "version": "1.0", "params": {...}}
+type Request struct {
+	ID      string      `json:"id"`
+	Version string      `json:"version"`
+	Params  interface{} `json:"params"`
+}
+
+// Reply 物模型回复消息: 平台回复 (post/reply) 与设备回复 (set_reply、get_reply)
+//
+//	{"id": "123", "version": "1.0", "code": 200, "msg": "success", "data": {...}}
+type Reply struct {
+	ID      string      `json:"id"`
+	Version string      `json:"version,omitempty"`
+	Code    int         `json:"code"`
+	Msg     string      `json:"msg,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// OK 是否为成功回复
+func (r Reply) OK() bool {
+	return r.Code == CodeSuccess
+}
+
+// TimedValue 属性或事件的取值: {"value": v, "time": ms} (property/post、event/post 的 params 项)
+type TimedValue struct {
+	Value interface{} `json:"value"`
+	Time  int64       `json:"time,omitempty"` // 毫秒时间戳，为 0 时不上报
+}
+
+// PackEvent pack/post 中的一个事件
+type PackEvent struct {
+	Identifier string                 `json:"identifier"`
+	Params     map[string]interface{} `json:"params"`
+	Time       int64                  `json:"time,omitempty"`
+}
+
+// ParseRequest 解析请求消息，params 按 JSON 通用值解码 (对象为 map[string]interface{}，数组为 []interface{})
+func ParseRequest(payload []byte) (Request, error) {
+	var req Request
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return Request{}, fmt.Errorf("parse request: %w", err)
+	}
+	return req, nil
+}
+
+// ParseReply 解析回复消息
+func ParseReply(payload []byte) (Reply, error) {
+	var r Reply
+	if err := json.Unmarshal(payload, &r); err != nil {
+		return Reply{}, fmt.Errorf("parse reply: %w", err)
+	}
+	return r, nil
+}
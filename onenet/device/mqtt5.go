@@ -0,0 +1,455 @@
+package device
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// ======================================================================
+// MQTT 5 接入 (基于 paho.golang)
+//
+// 鉴权与 Topic 与 MQTT 3.1.1 相同，额外支持:
+//   - 会话过期 (Session Expiry Interval) 与上行消息过期 (Message Expiry Interval)
+//   - Topic 别名: 同一 Topic 的别名定义被 Broker 确认后，后续发布只携带别名，
+//     数量不超过 Broker 在 CONNACK 中允许的上限
+//   - 用户属性: 附加在 CONNECT 与每条上行消息上
+//   - 响应 Topic: 下行请求携带 Response Topic 时，对应的 _reply 改发到该 Topic 并带回 Correlation Data
+//   - 原因码: CONNACK/PUBACK/SUBACK/DISCONNECT 中的非成功原因码经 OnReasonCode 上报，
+//     连接被拒或被 Broker 断开时以 *ReasonCodeError 返回
+//
+// 连接的建立与重连由调用方控制 (Connect/Disconnect + OnConnectionLost)。
+// ======================================================================
+
+// ErrNotConnected 连接未建立或已断开
+var ErrNotConnected = errors.New("not connected")
+
+// MQTT5Options MQTT 5 连接与发布参数
+type MQTT5Options struct {
+	SessionExpiry  time.Duration     // 会话过期间隔，0 表示断开即清除会话
+	MessageExpiry  time.Duration     // 上行消息过期间隔，0 表示不过期
+	TopicAliases   uint16            // 最多使用的 Topic 别名数 (另受 Broker 上限约束)，0 表示不使用
+	UserProperties map[string]string // 附加到 CONNECT 与每条上行消息的用户属性
+
+	// Dial 建立到 Broker 的网络连接 (可选，默认按 BrokerURL 的 scheme 建立 TCP/TLS 连接)
+	Dial func(ctx context.Context, uri *url.URL) (net.Conn, error)
+	// OnReasonCode Broker 返回非成功原因码时调用，packet 为 CONNACK/PUBACK/SUBACK/DISCONNECT
+	OnReasonCode func(packet string, code byte, reason string)
+	// OnConnectionLost 已建立的连接断开时调用 (Disconnect 主动断开时不调用)
+	OnConnectionLost func(err error)
+}
+
+// ReasonCodeError Broker 以 MQTT 5 原因码拒绝请求或断开连接
+type ReasonCodeError struct {
+	Packet string // CONNACK/PUBACK/SUBACK/DISCONNECT
+	Code   byte
+	Reason string // Broker 提供的原因说明 (可为空)
+}
+
+func (e *ReasonCodeError) Error() string {
+	msg := fmt.Sprintf("%s reason code 0x%02X (%s)", e.Packet, e.Code, ReasonName(e.Code))
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// reasonNames MQTT 5 常见原因码的名称
+var reasonNames = map[byte]string{
+	0x00: "Success",
+	0x04: "Disconnect with Will Message",
+	0x10: "No matching subscribers",
+	0x11: "No subscription existed",
+	0x80: "Unspecified error",
+	0x81: "Malformed Packet",
+	0x82: "Protocol Error",
+	0x83: "Implementation specific error",
+	0x84: "Unsupported Protocol Version",
+	0x85: "Client Identifier not valid",
+	0x86: "Bad User Name or Password",
+	0x87: "Not authorized",
+	0x88: "Server unavailable",
+	0x89: "Server busy",
+	0x8A: "Banned",
+	0x8B: "Server shutting down",
+	0x8D: "Keep Alive timeout",
+	0x8E: "Session taken over",
+	0x8F: "Topic Filter invalid",
+	0x90: "Topic Name invalid",
+	0x93: "Receive Maximum exceeded",
+	0x94: "Topic Alias invalid",
+	0x95: "Packet too large",
+	0x96: "Message rate too high",
+	0x97: "Quota exceeded",
+	0x98: "Administrative action",
+	0x99: "Payload format invalid",
+	0x9C: "Use another server",
+	0x9F: "Connection rate exceeded",
+}
+
+// ReasonName 返回 MQTT 5 原因码的名称
+func ReasonName(code byte) string {
+	if name, ok := reasonNames[code]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// MQTT5Transport 基于 MQTT 5 连接的 Transport
+type MQTT5Transport struct {
+	cfg  Config
+	opts MQTT5Options
+
+	mu        sync.Mutex
+	conn      *paho.Client // 当前连接，未连接时为 nil
+	aliasMax  uint16
+	aliases   map[string]*topicAlias
+	handlers  map[string]mqtt.MessageHandler
+	responses map[string][]responseTarget // 回复 Topic -> 待回复请求的响应 Topic (先进先出)
+}
+
+// topicAlias 一个已分配的 Topic 别名，confirmed 后发布时省略 Topic
+type topicAlias struct {
+	id        uint16
+	confirmed bool
+}
+
+// responseTarget 下行请求指定的响应 Topic 与关联数据
+type responseTarget struct {
+	topic       string
+	correlation []byte
+}
+
+// NewMQTT5Transport 创建 MQTT 5 接入，cfg 提供 Broker 地址、设备身份与 Token 参数
+func NewMQTT5Transport(cfg Config, opts MQTT5Options) *MQTT5Transport {
+	return &MQTT5Transport{
+		cfg:      cfg,
+		opts:     opts,
+		handlers: make(map[string]mqtt.MessageHandler),
+	}
+}
+
+// Connect 建立连接 (ClientID 为设备名，Username 为产品 ID，Password 为设备级 Token)。
+// Broker 拒绝时返回 *ReasonCodeError。
+func (t *MQTT5Transport) Connect(ctx context.Context) error {
+	uri, err := url.Parse(t.cfg.BrokerURL)
+	if err != nil {
+		return fmt.Errorf("parse broker url: %w", err)
+	}
+	token, err := t.cfg.Token()
+	if err != nil {
+		return fmt.Errorf("generate OneNET token: %w", err)
+	}
+	dial := t.opts.Dial
+	if dial == nil {
+		dial = t.dial
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, t.cfg.ConnectTimeout)
+	defer cancel()
+	nc, err := dial(dialCtx, uri)
+	if err != nil {
+		return err
+	}
+
+	// 连接结束的原因: Broker 的 DISCONNECT 或网络错误，取先发生的一个
+	var (
+		lostMu  sync.Mutex
+		lostErr error
+	)
+	setLost := func(err error) {
+		lostMu.Lock()
+		if lostErr == nil {
+			lostErr = err
+		}
+		lostMu.Unlock()
+	}
+	c := paho.NewClient(paho.ClientConfig{
+		Conn:              packets.NewThreadSafeConn(nc),
+		PacketTimeout:     t.cfg.ConnectTimeout,
+		OnPublishReceived: []func(paho.PublishReceived) (bool, error){t.onPublish},
+		OnServerDisconnect: func(d *paho.Disconnect) {
+			var reason string
+			if d.Properties != nil {
+				reason = d.Properties.ReasonString
+			}
+			t.reportReason("DISCONNECT", d.ReasonCode, reason)
+			setLost(&ReasonCodeError{Packet: "DISCONNECT", Code: d.ReasonCode, Reason: reason})
+		},
+		OnClientError: setLost,
+	})
+
+	cp := &paho.Connect{
+		ClientID:     t.cfg.DeviceName,
+		Username:     t.cfg.ProductID,
+		UsernameFlag: true,
+		Password:     []byte(token),
+		PasswordFlag: true,
+		KeepAlive:    uint16(t.cfg.KeepAlive / time.Second),
+		CleanStart:   t.cfg.CleanSession && t.opts.SessionExpiry == 0,
+		Properties:   &paho.ConnectProperties{User: t.userProperties()},
+	}
+	if t.opts.SessionExpiry > 0 {
+		expiry := uint32(t.opts.SessionExpiry / time.Second)
+		cp.Properties.SessionExpiryInterval = &expiry
+	}
+	ca, err := c.Connect(ctx, cp)
+	if err != nil {
+		if ca != nil && ca.ReasonCode >= 0x80 {
+			var reason string
+			if ca.Properties != nil {
+				reason = ca.Properties.ReasonString
+			}
+			t.reportReason("CONNACK", ca.ReasonCode, reason)
+			return &ReasonCodeError{Packet: "CONNACK", Code: ca.ReasonCode, Reason: reason}
+		}
+		return err
+	}
+
+	t.mu.Lock()
+	t.conn = c
+	t.aliasMax = 0
+	if ca.Properties != nil && ca.Properties.TopicAliasMaximum != nil {
+		t.aliasMax = min(t.opts.TopicAliases, *ca.Properties.TopicAliasMaximum)
+	}
+	t.aliases = make(map[string]*topicAlias)
+	t.responses = make(map[string][]responseTarget)
+	t.mu.Unlock()
+
+	go func() {
+		<-c.Done()
+		lostMu.Lock()
+		err := lostErr
+		lostMu.Unlock()
+		if err == nil {
+			err = errors.New("connection closed")
+		}
+		t.lost(c, err)
+	}()
+	return nil
+}
+
+// Disconnect 发送 DISCONNECT 并关闭连接
+func (t *MQTT5Transport) Disconnect() {
+	t.mu.Lock()
+	c := t.conn
+	t.conn = nil
+	t.mu.Unlock()
+	if c != nil {
+		c.Disconnect(&paho.Disconnect{ReasonCode: 0})
+	}
+}
+
+// lost 连接 c 结束: 仍是当前连接时 (非主动断开) 通知 OnConnectionLost
+func (t *MQTT5Transport) lost(c *paho.Client, err error) {
+	t.mu.Lock()
+	current := t.conn == c
+	if current {
+		t.conn = nil
+	}
+	t.mu.Unlock()
+	if current && t.opts.OnConnectionLost != nil {
+		t.opts.OnConnectionLost(err)
+	}
+}
+
+// IsConnected 连接是否在线
+func (t *MQTT5Transport) IsConnected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conn != nil
+}
+
+// Publish 发布上行消息并等待完成 (QoS 1 等待 PUBACK)，PUBACK 中的失败原因码以 *ReasonCodeError 返回
+func (t *MQTT5Transport) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	t.mu.Lock()
+	c := t.conn
+	if c == nil {
+		t.mu.Unlock()
+		return ErrNotConnected
+	}
+	p := &paho.Publish{
+		QoS:        qos,
+		Retain:     retained,
+		Topic:      topic,
+		Payload:    payload,
+		Properties: &paho.PublishProperties{User: t.userProperties()},
+	}
+	if t.opts.MessageExpiry > 0 {
+		expiry := uint32(t.opts.MessageExpiry / time.Second)
+		p.Properties.MessageExpiry = &expiry
+	}
+	if queue := t.responses[topic]; len(queue) > 0 {
+		p.Topic = queue[0].topic
+		p.Properties.CorrelationData = queue[0].correlation
+		t.responses[topic] = queue[1:]
+	}
+	alias := t.alias(p.Topic)
+	if alias != nil {
+		id := alias.id
+		p.Properties.TopicAlias = &id
+		if alias.confirmed {
+			p.Topic = ""
+		}
+	}
+	t.mu.Unlock()
+
+	resp, err := c.Publish(context.Background(), p)
+	if resp != nil && resp.ReasonCode != 0 {
+		var reason string
+		if resp.Properties != nil {
+			reason = resp.Properties.ReasonString
+		}
+		t.reportReason("PUBACK", resp.ReasonCode, reason)
+		if resp.ReasonCode >= 0x80 {
+			return &ReasonCodeError{Packet: "PUBACK", Code: resp.ReasonCode, Reason: reason}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if alias != nil && !alias.confirmed {
+		t.mu.Lock()
+		alias.confirmed = true
+		t.mu.Unlock()
+	}
+	return nil
+}
+
+// alias 返回 topic 的别名，尚未分配且未达上限时分配一个 (调用方持有锁)
+func (t *MQTT5Transport) alias(topic string) *topicAlias {
+	if a, ok := t.aliases[topic]; ok {
+		return a
+	}
+	if uint16(len(t.aliases)) >= t.aliasMax {
+		return nil
+	}
+	a := &topicAlias{id: uint16(len(t.aliases)) + 1}
+	t.aliases[topic] = a
+	return a
+}
+
+// Subscribe 订阅并等待 SUBACK，Broker 拒绝时返回包装了 ErrSubscribeRejected 的错误
+func (t *MQTT5Transport) Subscribe(topic string, qos byte, handler mqtt.MessageHandler) error {
+	t.mu.Lock()
+	c := t.conn
+	t.handlers[topic] = handler
+	t.mu.Unlock()
+	if c == nil {
+		return ErrNotConnected
+	}
+
+	sa, err := c.Subscribe(context.Background(), &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: topic, QoS: qos}},
+	})
+	if sa != nil && len(sa.Reasons) > 0 && sa.Reasons[0] >= 0x80 {
+		var reason string
+		if sa.Properties != nil {
+			reason = sa.Properties.ReasonString
+		}
+		t.reportReason("SUBACK", sa.Reasons[0], reason)
+		return fmt.Errorf("%w: %s", ErrSubscribeRejected, &ReasonCodeError{Packet: "SUBACK", Code: sa.Reasons[0], Reason: reason})
+	}
+	return err
+}
+
+// onPublish 将下行消息分发给订阅的 handler，并记录请求指定的响应 Topic
+func (t *MQTT5Transport) onPublish(pr paho.PublishReceived) (bool, error) {
+	p := pr.Packet
+	t.mu.Lock()
+	handler := t.handlers[p.Topic]
+	if handler == nil {
+		for filter, h := range t.handlers {
+			if matchTopic(filter, p.Topic) {
+				handler = h
+				break
+			}
+		}
+	}
+	if p.Properties != nil && p.Properties.ResponseTopic != "" && t.responses != nil {
+		reply := p.Topic + "_reply"
+		t.responses[reply] = append(t.responses[reply], responseTarget{p.Properties.ResponseTopic, p.Properties.CorrelationData})
+	}
+	t.mu.Unlock()
+
+	if handler == nil {
+		return false, nil
+	}
+	handler(nil, &inboundMessage{topic: p.Topic, qos: p.QoS, retained: p.Retain, payload: p.Payload})
+	return true, nil
+}
+
+func (t *MQTT5Transport) reportReason(packet string, code byte, reason string) {
+	if t.opts.OnReasonCode != nil {
+		t.opts.OnReasonCode(packet, code, reason)
+	}
+}
+
+// userProperties 按键排序的用户属性
+func (t *MQTT5Transport) userProperties() paho.UserProperties {
+	if len(t.opts.UserProperties) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(t.opts.UserProperties))
+	for k := range t.opts.UserProperties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	props := make(paho.UserProperties, 0, len(keys))
+	for _, k := range keys {
+		props = append(props, paho.UserProperty{Key: k, Value: t.opts.UserProperties[k]})
+	}
+	return props
+}
+
+// dial 按 URL scheme 建立 TCP/TLS 连接
+func (t *MQTT5Transport) dial(ctx context.Context, uri *url.URL) (net.Conn, error) {
+	var d net.Dialer
+	switch uri.Scheme {
+	case "tcp", "mqtt":
+		return d.DialContext(ctx, "tcp", hostPort(uri, "1883"))
+	case "ssl", "tls", "mqtts", "tcps":
+		cfg := t.cfg.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		td := tls.Dialer{NetDialer: &d, Config: cfg}
+		return td.DialContext(ctx, "tcp", hostPort(uri, "8883"))
+	}
+	return nil, fmt.Errorf("unsupported broker scheme %q", uri.Scheme)
+}
+
+func hostPort(uri *url.URL, defaultPort string) string {
+	if uri.Port() == "" {
+		return net.JoinHostPort(uri.Hostname(), defaultPort)
+	}
+	return uri.Host
+}
+
+// matchTopic 判断 Topic 是否匹配订阅过滤器 (支持 + 与 #)，首级为通配符时不匹配 $ 开头的 Topic
+func matchTopic(filter, topic string) bool {
+	fs := strings.Split(filter, "/")
+	ts := strings.Split(topic, "/")
+	if (fs[0] == "#" || fs[0] == "+") && strings.HasPrefix(topic, "$") {
+		return false
+	}
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) || (f != "+" && f != ts[i]) {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
@@ -0,0 +1,32 @@
+package device
+
+import "testing"
+
+func TestReasonCodeError(t *testing.T) {
+	err := &ReasonCodeError{Packet: "PUBACK", Code: 0x97, Reason: "daily quota"}
+	if got, want := err.Error(), "PUBACK reason code 0x97 (Quota exceeded): daily quota"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if got := ReasonName(0x7F); got != "Unknown" {
+		t.Errorf("ReasonName(0x7F) = %q", got)
+	}
+}
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"$sys/pid/dev/thing/property/set", "$sys/pid/dev/thing/property/set", true},
+		{"$sys/pid/dev/thing/service/+/invoke", "$sys/pid/dev/thing/service/reboot/invoke", true},
+		{"$sys/pid/dev/#", "$sys/pid/dev/thing/property/get", true},
+		{"$sys/pid/dev/+", "$sys/pid/dev/thing/property/get", false},
+		{"$sys/pid/dev/thing/property/set", "$sys/pid/dev/thing/property/get", false},
+		{"#", "$sys/pid/dev/thing/property/get", false},
+	}
+	for _, tt := range tests {
+		if got := matchTopic(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("matchTopic(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
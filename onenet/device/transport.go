@@ -0,0 +1,49 @@
+package device
+
+import (
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Transport 承载设备物模型消息的接入方式 (MQTT 长连接或 HTTP 请求)。
+// topic 均为完整的 $sys/{pid}/{device-name}/... Topic，handler 按 paho 的回调形式接收下行消息
+// (非 MQTT 实现回调时 client 参数为 nil)。qos 与 retained 为 MQTT 语义，HTTP 接入忽略。
+type Transport interface {
+	IsConnected() bool
+	Publish(topic string, qos byte, retained bool, payload []byte) error
+	Subscribe(topic string, qos byte, handler mqtt.MessageHandler) error
+}
+
+// mqttTransport 基于 paho 连接的 Transport
+type mqttTransport struct {
+	conn mqtt.Client
+}
+
+// NewMQTTTransport 以已创建的 MQTT 连接 (paho 或兼容实现) 作为 Transport
+func NewMQTTTransport(conn mqtt.Client) Transport {
+	return &mqttTransport{conn: conn}
+}
+
+func (t *mqttTransport) IsConnected() bool { return t.conn.IsConnected() }
+
+func (t *mqttTransport) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	token := t.conn.Publish(topic, qos, retained, payload)
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
+}
+
+// Subscribe 订阅并等待完成，Broker 在 SUBACK 中拒绝时返回 ErrSubscribeRejected
+func (t *mqttTransport) Subscribe(topic string, qos byte, handler mqtt.MessageHandler) error {
+	token := t.conn.Subscribe(topic, qos, handler)
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return err
+	}
+	if st, ok := token.(*mqtt.SubscribeToken); ok {
+		if code, ok := st.Result()[topic]; ok && code == 0x80 {
+			return ErrSubscribeRejected
+		}
+	}
+	return nil
+}
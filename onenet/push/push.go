@@ -0,0 +1,188 @@
+// Package push 实现 OneNET 数据推送 (HTTP 推送) 的消息格式、签名与加解密。
+//
+// 平台以 POST 请求将设备数据推送到应用服务器，请求体为:
+//
+//	{"id": "...", "msg": "<消息 JSON 字符串>", "nonce": "...", "signature": "...", "time": 1700000000000}
+//
+// 开启加密时 msg 换为 enc_msg (base64 密文)。signature = base64(MD5(token + nonce + msg))，
+// msg 取请求体中的原文 (加密时为 enc_msg)。配置推送地址时平台先发送
+// GET ?msg=...&nonce=...&signature=...，服务器校验签名后原样返回 msg 完成 URL 验证。
+//
+// 加密使用 AES-256-CBC: 密钥为 base64decode(aesKey + "=") (aesKey 为 43 个字符)，
+// IV 为密钥前 16 字节，明文为 16 字节随机数 + 4 字节网络序消息长度 + 消息，PKCS#7 填充。
+package push
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// 物模型推送的消息类别 (Notification.NotifyType)
+const (
+	NotifyProperty = "property"
+	NotifyEvent    = "event"
+)
+
+// ErrSignatureMismatch 推送请求的签名与 token 不符
+var ErrSignatureMismatch = errors.New("push: signature mismatch")
+
+// Envelope 推送请求体
+type Envelope struct {
+	ID        string          `json:"id,omitempty"`
+	Msg       json.RawMessage `json:"msg,omitempty"`
+	EncMsg    string          `json:"enc_msg,omitempty"`
+	Nonce     string          `json:"nonce"`
+	Signature string          `json:"signature"`
+	Time      int64           `json:"time"`
+}
+
+// Notification 物模型数据推送的消息内容 (msg 解码后)
+type Notification struct {
+	MessageType string `json:"messageType"` // notify
+	NotifyType  string `json:"notifyType"`  // property | event
+	ProductID   string `json:"productId"`
+	DeviceName  string `json:"deviceName"`
+	Data        struct {
+		ID     string          `json:"id"`
+		Params json.RawMessage `json:"params"`
+	} `json:"data"`
+}
+
+// Sign 计算推送签名 base64(MD5(token + nonce + msg))
+func Sign(token, nonce, msg string) string {
+	sum := md5.Sum([]byte(token + nonce + msg))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// VerifyURL 校验平台的 URL 验证请求 (GET 参数)，返回应原样响应的 msg
+func VerifyURL(token string, query url.Values) (string, error) {
+	msg := query.Get("msg")
+	if Sign(token, query.Get("nonce"), msg) != query.Get("signature") {
+		return "", ErrSignatureMismatch
+	}
+	return msg, nil
+}
+
+// signed 返回参与签名的消息原文: enc_msg，或 msg (JSON 字符串时取其内容)
+func (e Envelope) signed() string {
+	if e.EncMsg != "" {
+		return e.EncMsg
+	}
+	var s string
+	if json.Unmarshal(e.Msg, &s) == nil {
+		return s
+	}
+	return string(e.Msg)
+}
+
+// Open 校验签名并返回消息内容，enc_msg 以 aesKey 解密
+func (e Envelope) Open(token, aesKey string) ([]byte, error) {
+	msg := e.signed()
+	if Sign(token, e.Nonce, msg) != e.Signature {
+		return nil, ErrSignatureMismatch
+	}
+	if e.EncMsg == "" {
+		return []byte(msg), nil
+	}
+	if aesKey == "" {
+		return nil, errors.New("push: encrypted message but no AES key configured")
+	}
+	return Decrypt(aesKey, e.EncMsg)
+}
+
+// Seal 构造一条推送请求体 (平台侧)，aesKey 非空时加密消息
+func Seal(token, aesKey, id string, msg []byte, now time.Time) (Envelope, error) {
+	e := Envelope{ID: id, Nonce: nonce(), Time: now.UnixMilli()}
+	signed := string(msg)
+	if aesKey != "" {
+		enc, err := Encrypt(aesKey, msg)
+		if err != nil {
+			return Envelope{}, err
+		}
+		e.EncMsg, signed = enc, enc
+	} else {
+		e.Msg, _ = json.Marshal(signed)
+	}
+	e.Signature = Sign(token, e.Nonce, signed)
+	return e, nil
+}
+
+// nonce 生成 8 位随机字符串
+func nonce() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeKey 解码 43 个字符的 AES Key 为 32 字节密钥
+func decodeKey(aesKey string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(aesKey + "=")
+	if err != nil {
+		return nil, fmt.Errorf("push: decode AES key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("push: AES key must decode to 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// Encrypt 加密推送消息，返回 base64 密文
+func Encrypt(aesKey string, msg []byte) (string, error) {
+	key, err := decodeKey(aesKey)
+	if err != nil {
+		return "", err
+	}
+	plain := make([]byte, 20, 20+len(msg)+aes.BlockSize)
+	rand.Read(plain[:16])
+	binary.BigEndian.PutUint32(plain[16:20], uint32(len(msg)))
+	plain = append(plain, msg...)
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	plain = append(plain, bytes.Repeat([]byte{byte(pad)}, pad)...)
+
+	block, _ := aes.NewCipher(key)
+	out := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, key[:aes.BlockSize]).CryptBlocks(out, plain)
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+// Decrypt 解密 base64 密文，返回消息内容
+func Decrypt(aesKey, encMsg string) ([]byte, error) {
+	key, err := decodeKey(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(encMsg)
+	if err != nil {
+		return nil, fmt.Errorf("push: decode enc_msg: %w", err)
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("push: enc_msg length %d is not a multiple of the block size", len(data))
+	}
+	block, _ := aes.NewCipher(key)
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, key[:aes.BlockSize]).CryptBlocks(plain, data)
+
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > aes.BlockSize || pad > len(plain) {
+		return nil, errors.New("push: invalid padding")
+	}
+	plain = plain[:len(plain)-pad]
+	if len(plain) < 20 {
+		return nil, errors.New("push: decrypted message too short")
+	}
+	n := binary.BigEndian.Uint32(plain[16:20])
+	if int(n) > len(plain)-20 {
+		return nil, fmt.Errorf("push: invalid message length %d", n)
+	}
+	return plain[20 : 20+n], nil
+}
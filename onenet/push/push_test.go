@@ -0,0 +1,69 @@
+package push
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 43 个字符的 AES Key (32 字节密钥的 base64 去掉末尾 '=')
+var testAESKey = strings.TrimSuffix(base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")), "=")
+
+func TestSealOpen(t *testing.T) {
+	msg := []byte(`{"messageType":"notify","notifyType":"property","deviceName":"dev1","data":{"id":"7","params":{}}}`)
+	for _, aesKey := range []string{"", testAESKey} {
+		e, err := Seal("tok", aesKey, "push-1", msg, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (e.EncMsg != "") != (aesKey != "") {
+			t.Errorf("aes key %q: enc_msg = %q", aesKey, e.EncMsg)
+		}
+
+		// 经 JSON 编解码后仍可校验并还原
+		body, _ := json.Marshal(e)
+		var got Envelope
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Fatal(err)
+		}
+		out, err := got.Open("tok", aesKey)
+		if err != nil {
+			t.Fatalf("aes key %q: open: %v", aesKey, err)
+		}
+		if string(out) != string(msg) {
+			t.Errorf("aes key %q: msg = %s", aesKey, out)
+		}
+		var n Notification
+		if err := json.Unmarshal(out, &n); err != nil || n.DeviceName != "dev1" || n.Data.ID != "7" {
+			t.Errorf("notification = %+v, %v", n, err)
+		}
+
+		if _, err := got.Open("other", aesKey); !errors.Is(err, ErrSignatureMismatch) {
+			t.Errorf("wrong token: err = %v", err)
+		}
+	}
+}
+
+func TestVerifyURL(t *testing.T) {
+	q := url.Values{"msg": {"hello"}, "nonce": {"abc"}, "signature": {Sign("tok", "abc", "hello")}}
+	if msg, err := VerifyURL("tok", q); err != nil || msg != "hello" {
+		t.Errorf("VerifyURL = %q, %v", msg, err)
+	}
+	q.Set("nonce", "abd")
+	if _, err := VerifyURL("tok", q); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("tampered nonce: err = %v", err)
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	if _, err := Decrypt("short", "AAAA"); err == nil {
+		t.Error("invalid key: expected error")
+	}
+	if _, err := Decrypt(testAESKey, base64.StdEncoding.EncodeToString([]byte("not a block"))); err == nil {
+		t.Error("invalid ciphertext length: expected error")
+	}
+}
@@ -0,0 +1,347 @@
+// Package thingmodel 解析 OneNET 导出的物模型 JSON，并提供类型化取值层:
+// 负责物模型全部数据类型的解码 (来自 set 命令的 JSON)、校验与上报编码:
+//   - int32/int64/enum: 整数，校验 min/max/step 及枚举取值
+//   - float/double:     浮点，按 step 的小数位数控制上报精度
+//   - bool:             true/false (兼容 0/1)
+//   - string/text:      字符串，校验字符长度
+//   - date:             UTC 毫秒时间戳 (Date)
+//   - struct:           嵌套结构体，逐字段递归校验
+//   - array:            定长数组 (length 为元素个数)，逐元素递归校验
+package thingmodel
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Model 物模型定义
+type Model struct {
+	ProductID  string
+	Properties []*Property
+	Events     []*Event
+	Services   []*Service
+
+	props    map[string]*Property
+	events   map[string]*Event
+	services map[string]*Service
+}
+
+// Property 物模型属性定义
+type Property struct {
+	Identifier string   `json:"identifier"`
+	Name       string   `json:"name"`
+	AccessMode string   `json:"accessMode"`
+	Desc       string   `json:"desc"`
+	DataType   DataType `json:"dataType"`
+}
+
+// Writable 属性是否可由平台设置 (accessMode 含 w)
+func (p *Property) Writable() bool {
+	return strings.Contains(p.AccessMode, "w")
+}
+
+// Event 物模型事件定义
+type Event struct {
+	Identifier string  `json:"identifier"`
+	Name       string  `json:"name"`
+	OutputData []Field `json:"outputData"`
+}
+
+// Param 按标识符查找事件的输出参数定义
+func (e *Event) Param(id string) (*Field, bool) {
+	return findField(e.OutputData, id)
+}
+
+// Service 物模型服务定义
+type Service struct {
+	Identifier string  `json:"identifier"`
+	Name       string  `json:"name"`
+	CallType   string  `json:"callType"`
+	InputData  []Field `json:"inputData"`
+	OutputData []Field `json:"outputData"`
+}
+
+// Field 结构体字段 / 事件输出参数 / 服务输入输出参数定义
+type Field struct {
+	Identifier string   `json:"identifier"`
+	Name       string   `json:"name"`
+	DataType   DataType `json:"dataType"`
+}
+
+// DataType 物模型数据类型 (dataType 节点)，specs 按类型解析
+type DataType struct {
+	Type string
+
+	Min, Max, Step string // 数值类型 (保留原始字符串，避免 int64 精度丢失)
+	Unit           string
+
+	Length int               // string/text 的字符数上限，array 的元素个数 (定长)
+	Enum   map[string]string // enum/bool 取值说明
+	Fields []Field           // struct 字段
+	Elem   *DataType         // array 元素类型
+}
+
+// specString 兼容 specs 中数字与字符串两种写法
+type specString string
+
+func (s *specString) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err == nil {
+		*s = specString(str)
+		return nil
+	}
+	var num json.Number
+	if err := json.Unmarshal(b, &num); err != nil {
+		return err
+	}
+	*s = specString(num.String())
+	return nil
+}
+
+func (dt *DataType) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Type  string          `json:"type"`
+		Specs json.RawMessage `json:"specs"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	return dt.parse(raw.Type, raw.Specs)
+}
+
+func (dt *DataType) parse(typ string, specs json.RawMessage) error {
+	*dt = DataType{Type: typ}
+	hasSpecs := len(specs) > 0 && string(specs) != "null"
+
+	switch typ {
+	case "int32", "int64", "float", "double":
+		var ns struct {
+			Min, Max, Step, Unit specString
+		}
+		if hasSpecs {
+			if err := json.Unmarshal(specs, &ns); err != nil {
+				return fmt.Errorf("%s specs: %w", typ, err)
+			}
+		}
+		dt.Min, dt.Max, dt.Step, dt.Unit = string(ns.Min), string(ns.Max), string(ns.Step), string(ns.Unit)
+	case "enum", "bool":
+		if hasSpecs {
+			if err := json.Unmarshal(specs, &dt.Enum); err != nil {
+				return fmt.Errorf("%s specs: %w", typ, err)
+			}
+		}
+	case "string", "text":
+		var ls struct{ Length specString }
+		if hasSpecs {
+			if err := json.Unmarshal(specs, &ls); err != nil {
+				return fmt.Errorf("%s specs: %w", typ, err)
+			}
+		}
+		dt.Length, _ = strconv.Atoi(string(ls.Length))
+	case "date":
+	case "struct":
+		if err := json.Unmarshal(specs, &dt.Fields); err != nil {
+			return fmt.Errorf("struct specs: %w", err)
+		}
+	case "array":
+		var as struct {
+			Length specString
+			Type   string
+			Specs  json.RawMessage
+		}
+		if err := json.Unmarshal(specs, &as); err != nil {
+			return fmt.Errorf("array specs: %w", err)
+		}
+		dt.Length, _ = strconv.Atoi(string(as.Length))
+		dt.Elem = &DataType{}
+		if err := dt.Elem.parse(as.Type, as.Specs); err != nil {
+			return fmt.Errorf("array element: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported data type %q", typ)
+	}
+	return nil
+}
+
+// Load 读取并解析物模型 JSON 文件
+func Load(path string) (*Model, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Parse 解析 OneNET 导出的物模型 JSON
+func Parse(data []byte) (*Model, error) {
+	var raw struct {
+		Profile struct {
+			ProductID string `json:"productId"`
+		} `json:"profile"`
+		Properties []*Property `json:"properties"`
+		Events     []*Event    `json:"events"`
+		Services   []*Service  `json:"services"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	m := &Model{
+		ProductID:  raw.Profile.ProductID,
+		Properties: raw.Properties,
+		Events:     raw.Events,
+		Services:   raw.Services,
+		props:      make(map[string]*Property),
+		events:     make(map[string]*Event),
+		services:   make(map[string]*Service),
+	}
+	for _, p := range m.Properties {
+		m.props[p.Identifier] = p
+	}
+	for _, e := range m.Events {
+		m.events[e.Identifier] = e
+	}
+	for _, sv := range m.Services {
+		m.services[sv.Identifier] = sv
+	}
+	return m, nil
+}
+
+// MustParse 同 Parse，解析失败时 panic (用于嵌入的物模型)
+func MustParse(data []byte) *Model {
+	m, err := Parse(data)
+	if err != nil {
+		panic(fmt.Sprintf("parse thing model: %v", err))
+	}
+	return m
+}
+
+// Property 按标识符查找属性定义
+func (m *Model) Property(id string) (*Property, bool) {
+	p, ok := m.props[id]
+	return p, ok
+}
+
+// Event 按标识符查找事件定义
+func (m *Model) Event(id string) (*Event, bool) {
+	e, ok := m.events[id]
+	return e, ok
+}
+
+// Service 按标识符查找服务定义
+func (m *Model) Service(id string) (*Service, bool) {
+	s, ok := m.services[id]
+	return s, ok
+}
+
+// DecodeProperty 解码并校验 set 命令中的属性值，写入类型化的 out
+func (m *Model) DecodeProperty(id string, raw interface{}, out interface{}) error {
+	p, ok := m.Property(id)
+	if !ok {
+		return fmt.Errorf("unknown property: %s", id)
+	}
+	v, err := p.DataType.Decode(raw)
+	if err != nil {
+		return fmt.Errorf("%s: %w", id, err)
+	}
+	b, err := json.Marshal(p.DataType.format(v))
+	if err != nil {
+		return fmt.Errorf("%s: %w", id, err)
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("%s: %w", id, err)
+	}
+	return nil
+}
+
+// EncodeProperty 将类型化属性值编码为上报用的 JSON 取值
+func (m *Model) EncodeProperty(id string, v interface{}) (interface{}, error) {
+	p, ok := m.Property(id)
+	if !ok {
+		return nil, fmt.Errorf("unknown property: %s", id)
+	}
+	enc, err := p.DataType.Encode(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", id, err)
+	}
+	return enc, nil
+}
+
+// EncodeEvent 将事件参数编码为上报用的 JSON 取值，参数必须是物模型中声明的输出参数
+func (m *Model) EncodeEvent(id string, params map[string]interface{}) (map[string]interface{}, error) {
+	e, ok := m.Event(id)
+	if !ok {
+		return nil, fmt.Errorf("unknown event: %s", id)
+	}
+	out := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		f, ok := findField(e.OutputData, k)
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown output parameter %s", id, k)
+		}
+		enc, err := f.DataType.Encode(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", id, k, err)
+		}
+		out[k] = enc
+	}
+	return out, nil
+}
+
+// DecodeServiceInput 解码并校验服务调用的输入参数，参数必须是物模型中声明的输入参数
+// (未提供的参数不会补齐)，返回类型化的取值
+func (m *Model) DecodeServiceInput(id string, params map[string]interface{}) (map[string]interface{}, error) {
+	s, ok := m.Service(id)
+	if !ok {
+		return nil, fmt.Errorf("unknown service: %s", id)
+	}
+	in := make(map[string]interface{}, len(params))
+	for k, raw := range params {
+		f, ok := findField(s.InputData, k)
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown input parameter %s", id, k)
+		}
+		v, err := f.DataType.Decode(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", id, k, err)
+		}
+		in[k] = v
+	}
+	return in, nil
+}
+
+// EncodeServiceOutput 将服务输出参数编码为回复用的 JSON 取值，参数必须是物模型中声明的输出参数
+func (m *Model) EncodeServiceOutput(id string, params map[string]interface{}) (map[string]interface{}, error) {
+	s, ok := m.Service(id)
+	if !ok {
+		return nil, fmt.Errorf("unknown service: %s", id)
+	}
+	out := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		f, ok := findField(s.OutputData, k)
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown output parameter %s", id, k)
+		}
+		enc, err := f.DataType.Encode(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", id, k, err)
+		}
+		out[k] = enc
+	}
+	return out, nil
+}
+
+func findField(fields []Field, id string) (*Field, bool) {
+	for i := range fields {
+		if fields[i].Identifier == id {
+			return &fields[i], true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,168 @@
+package thingmodel
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+const testModel = `{
+  "profile": {"productId": "pid"},
+  "properties": [
+    {"identifier": "relay", "name": "继电器", "accessMode": "rw",
+     "dataType": {"type": "int32", "specs": {"min": "0", "max": "1", "step": "1"}}},
+    {"identifier": "mode", "name": "模式", "accessMode": "rw",
+     "dataType": {"type": "enum", "specs": {"0": "auto", "1": "manual"}}},
+    {"identifier": "label", "name": "标签", "accessMode": "rw",
+     "dataType": {"type": "string", "specs": {"length": "4"}}},
+    {"identifier": "since", "name": "时间", "accessMode": "r",
+     "dataType": {"type": "date"}},
+    {"identifier": "pos", "name": "位置", "accessMode": "rw",
+     "dataType": {"type": "struct", "specs": [
+       {"identifier": "x", "name": "x", "dataType": {"type": "int32", "specs": {"min": "0", "max": "100"}}}
+     ]}},
+    {"identifier": "tags", "name": "标签组", "accessMode": "rw",
+     "dataType": {"type": "array", "specs": {"length": "2", "type": "string", "specs": {"length": "8"}}}},
+    {"identifier": "gain", "name": "增益", "accessMode": "rw",
+     "dataType": {"type": "float", "specs": {"min": "0.5", "max": "10", "step": "1"}}},
+    {"identifier": "ratio", "name": "比例", "accessMode": "rw",
+     "dataType": {"type": "float", "specs": {"min": "0", "max": "1", "step": ""}}}
+  ],
+  "events": [
+    {"identifier": "alarm", "name": "告警", "outputData": [
+      {"identifier": "level", "name": "级别", "dataType": {"type": "int32", "specs": {"min": "0", "max": "3"}}}
+    ]}
+  ],
+  "services": [
+    {"identifier": "calibrate", "name": "校准", "callType": "sync",
+     "inputData": [
+       {"identifier": "offset", "name": "偏移", "dataType": {"type": "int32", "specs": {"min": "-10", "max": "10"}}}
+     ],
+     "outputData": [
+       {"identifier": "ok", "name": "结果", "dataType": {"type": "bool", "specs": {"0": "失败", "1": "成功"}}}
+     ]}
+  ]
+}`
+
+func TestParse(t *testing.T) {
+	m := MustParse([]byte(testModel))
+	if m.ProductID != "pid" || len(m.Properties) != 8 || len(m.Events) != 1 || len(m.Services) != 1 {
+		t.Fatalf("model = %+v", m)
+	}
+	p, ok := m.Property("relay")
+	if !ok || !p.Writable() || p.DataType.Min != "0" || p.DataType.Max != "1" {
+		t.Errorf("relay = %+v", p)
+	}
+	if p, _ := m.Property("since"); p.Writable() {
+		t.Error("since must be read-only")
+	}
+	if p, _ := m.Property("tags"); p.DataType.Length != 2 || p.DataType.Elem == nil || p.DataType.Elem.Length != 8 {
+		t.Errorf("tags = %+v", p.DataType)
+	}
+	if e, ok := m.Event("alarm"); !ok {
+		t.Error("alarm not found")
+	} else if _, ok := e.Param("level"); !ok {
+		t.Error("alarm.level not found")
+	}
+	if s, ok := m.Service("calibrate"); !ok || s.CallType != "sync" || len(s.InputData) != 1 || len(s.OutputData) != 1 {
+		t.Errorf("calibrate = %+v", s)
+	}
+	if _, err := Parse([]byte(`{"properties": [`)); err == nil {
+		t.Error("invalid json: expected error")
+	}
+}
+
+func TestDecodeProperty(t *testing.T) {
+	m := MustParse([]byte(testModel))
+
+	var relay int32
+	if err := m.DecodeProperty("relay", float64(1), &relay); err != nil || relay != 1 {
+		t.Errorf("relay = %d, %v", relay, err)
+	}
+	var pos struct {
+		X int32 `json:"x"`
+	}
+	if err := m.DecodeProperty("pos", map[string]interface{}{"x": float64(42)}, &pos); err != nil || pos.X != 42 {
+		t.Errorf("pos = %+v, %v", pos, err)
+	}
+	var tags []string
+	if err := m.DecodeProperty("tags", []interface{}{"a", "b"}, &tags); err != nil || len(tags) != 2 {
+		t.Errorf("tags = %v, %v", tags, err)
+	}
+	var gain float32
+	if err := m.DecodeProperty("gain", float64(1.5), &gain); err != nil || gain != 1.5 { // step 以 min 为起点
+		t.Errorf("gain = %v, %v", gain, err)
+	}
+	var since Date
+	if err := m.DecodeProperty("since", float64(1700000000000), &since); err != nil || !time.Time(since).Equal(time.UnixMilli(1700000000000)) {
+		t.Errorf("since = %v, %v", since, err)
+	}
+
+	bad := []struct {
+		id  string
+		raw interface{}
+	}{
+		{"relay", float64(2)},
+		{"relay", "1x"},
+		{"mode", float64(5)},
+		{"label", "too long"},
+		{"pos", map[string]interface{}{"x": float64(101)}},
+		{"tags", []interface{}{"a", "b", "c"}}, // 定长数组: 过长
+		{"tags", []interface{}{"a"}},           // 定长数组: 过短
+		{"gain", float64(1)},
+		{"nope", float64(1)},
+	}
+	for _, tt := range bad {
+		var v interface{}
+		if err := m.DecodeProperty(tt.id, tt.raw, &v); err == nil {
+			t.Errorf("DecodeProperty(%s, %v): expected error", tt.id, tt.raw)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	m := MustParse([]byte(testModel))
+	since := Date(time.UnixMilli(1700000000000))
+	if v, err := m.EncodeProperty("since", since); err != nil || v != int64(1700000000000) {
+		t.Errorf("EncodeProperty(since) = %v (%T), %v", v, v, err)
+	}
+	if v, err := m.EncodeProperty("gain", float32(2.4)); err != nil || v != json.Number("2.5") {
+		t.Errorf("EncodeProperty(gain) = %v, %v", v, err)
+	}
+	// 无 step 的 float 按 float32 的最短表示输出
+	if v, err := m.EncodeProperty("ratio", float32(0.1)); err != nil || v != json.Number("0.1") {
+		t.Errorf("EncodeProperty(ratio) = %v, %v", v, err)
+	}
+	if _, err := m.EncodeEvent("alarm", map[string]interface{}{"level": int32(2)}); err != nil {
+		t.Errorf("EncodeEvent: %v", err)
+	}
+	if _, err := m.EncodeEvent("alarm", map[string]interface{}{"other": 1}); err == nil {
+		t.Error("unknown output parameter: expected error")
+	}
+}
+
+func TestService(t *testing.T) {
+	m := MustParse([]byte(testModel))
+	in, err := m.DecodeServiceInput("calibrate", map[string]interface{}{"offset": float64(-3)})
+	if err != nil || in["offset"] != int32(-3) {
+		t.Errorf("DecodeServiceInput = %v, %v", in, err)
+	}
+	for name, params := range map[string]map[string]interface{}{
+		"out of range": {"offset": float64(11)},
+		"unknown":      {"gain": float64(1)},
+	} {
+		if _, err := m.DecodeServiceInput("calibrate", params); err == nil {
+			t.Errorf("DecodeServiceInput(%s): expected error", name)
+		}
+	}
+	if _, err := m.DecodeServiceInput("reboot", nil); err == nil {
+		t.Error("unknown service: expected error")
+	}
+	out, err := m.EncodeServiceOutput("calibrate", map[string]interface{}{"ok": true})
+	if err != nil || out["ok"] != true {
+		t.Errorf("EncodeServiceOutput = %v, %v", out, err)
+	}
+	if _, err := m.EncodeServiceOutput("calibrate", map[string]interface{}{"offset": 1}); err == nil {
+		t.Error("input parameter in output: expected error")
+	}
+}
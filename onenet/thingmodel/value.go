@@ -0,0 +1,353 @@
+package thingmodel
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ======================================================================
+// Date: date 类型
+// ======================================================================
+
+// Date 物模型 date 类型，JSON 编码为 UTC 毫秒时间戳
+type Date time.Time
+
+func (t Date) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatInt(time.Time(t).UnixMilli(), 10)), nil
+}
+
+func (t *Date) UnmarshalJSON(b []byte) error {
+	ms, err := strconv.ParseInt(strings.Trim(string(b), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("date must be a millisecond timestamp: %w", err)
+	}
+	*t = Date(time.UnixMilli(ms))
+	return nil
+}
+
+func (t Date) String() string {
+	return time.Time(t).Format(time.RFC3339Nano)
+}
+
+// ======================================================================
+// 解码 / 校验 / 编码
+// ======================================================================
+
+// Decode 将 JSON 解码得到的通用值 (float64/json.Number/string/map/slice 等)
+// 转换为类型化的值并按 specs 校验:
+// int32/int64/float32/float64/bool/string/Date/map[string]interface{}/[]interface{}
+func (dt *DataType) Decode(raw interface{}) (interface{}, error) {
+	return dt.decode(raw, false)
+}
+
+// decode 实现 Decode；quantize 为 true 时浮点先按 step 取整再校验 (用于上报编码)
+func (dt *DataType) decode(raw interface{}, quantize bool) (interface{}, error) {
+	switch dt.Type {
+	case "int32", "enum":
+		n, err := toInt64(raw)
+		if err != nil {
+			return nil, err
+		}
+		if n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, fmt.Errorf("value %d overflows int32", n)
+		}
+		if err := dt.checkInt(n); err != nil {
+			return nil, err
+		}
+		return int32(n), nil
+	case "int64":
+		n, err := toInt64(raw)
+		if err != nil {
+			return nil, err
+		}
+		if err := dt.checkInt(n); err != nil {
+			return nil, err
+		}
+		return n, nil
+	case "float", "double":
+		f, err := toFloat64(raw)
+		if err != nil {
+			return nil, err
+		}
+		if step, err := strconv.ParseFloat(dt.Step, 64); quantize && err == nil && step > 0 {
+			base, _ := strconv.ParseFloat(dt.Min, 64)
+			f = base + math.Round((f-base)/step)*step
+		}
+		if err := dt.checkFloat(f); err != nil {
+			return nil, err
+		}
+		if dt.Type == "float" {
+			return float32(f), nil
+		}
+		return f, nil
+	case "bool":
+		switch v := raw.(type) {
+		case bool:
+			return v, nil
+		default:
+			n, err := toInt64(raw)
+			if err != nil || (n != 0 && n != 1) {
+				return nil, fmt.Errorf("value %v is not a bool", raw)
+			}
+			return n == 1, nil
+		}
+	case "string", "text":
+		s, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("value %v is not a string", raw)
+		}
+		if dt.Length > 0 && utf8.RuneCountInString(s) > dt.Length {
+			return nil, fmt.Errorf("text longer than %d", dt.Length)
+		}
+		return s, nil
+	case "date":
+		var ms int64
+		switch v := raw.(type) {
+		case Date:
+			return v, nil
+		case time.Time:
+			return Date(v), nil
+		case string:
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("date %q is not a millisecond timestamp", v)
+			}
+			ms = n
+		default:
+			n, err := toInt64(raw)
+			if err != nil {
+				return nil, err
+			}
+			ms = n
+		}
+		return Date(time.UnixMilli(ms)), nil
+	case "struct":
+		obj, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("value %v is not a struct", raw)
+		}
+		out := make(map[string]interface{}, len(dt.Fields))
+		for _, f := range dt.Fields {
+			fv, ok := obj[f.Identifier]
+			if !ok {
+				return nil, fmt.Errorf("missing field %s", f.Identifier)
+			}
+			v, err := f.DataType.decode(fv, quantize)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Identifier, err)
+			}
+			out[f.Identifier] = v
+		}
+		for k := range obj {
+			if _, ok := findField(dt.Fields, k); !ok {
+				return nil, fmt.Errorf("unknown field %s", k)
+			}
+		}
+		return out, nil
+	case "array":
+		arr, ok := raw.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("value %v is not an array", raw)
+		}
+		if dt.Length > 0 && len(arr) != dt.Length {
+			return nil, fmt.Errorf("array has %d elements, want %d", len(arr), dt.Length)
+		}
+		out := make([]interface{}, len(arr))
+		for i, ev := range arr {
+			v, err := dt.Elem.decode(ev, quantize)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			out[i] = v
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported data type %q", dt.Type)
+}
+
+// Encode 将类型化的值 (含 tmgen 生成的结构体) 校验后编码为上报用的 JSON 取值，
+// 浮点按 step 取整并以对应小数位数输出
+func (dt *DataType) Encode(v interface{}) (interface{}, error) {
+	// 先经 JSON 归一化为通用值，再复用 Decode 的校验逻辑
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.UseNumber()
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	typed, err := dt.decode(generic, true)
+	if err != nil {
+		return nil, err
+	}
+	return dt.format(typed), nil
+}
+
+// format 将 Decode 得到的类型化值转换为可直接 json.Marshal 的取值
+func (dt *DataType) format(v interface{}) interface{} {
+	switch dt.Type {
+	case "float", "double":
+		var f float64
+		switch x := v.(type) {
+		case float32:
+			f = float64(x)
+		case float64:
+			f = x
+		}
+		// float 以 float32 精度输出，避免最短表示时带出 float64 展开的尾数 (0.1 -> 0.10000000149011612)
+		bitSize := 64
+		if dt.Type == "float" {
+			bitSize = 32
+		}
+		return json.Number(strconv.FormatFloat(f, 'f', dt.precision(), bitSize))
+	case "date":
+		return time.Time(v.(Date)).UnixMilli()
+	case "struct":
+		obj := v.(map[string]interface{})
+		out := make(map[string]interface{}, len(obj))
+		for _, f := range dt.Fields {
+			out[f.Identifier] = f.DataType.format(obj[f.Identifier])
+		}
+		return out
+	case "array":
+		arr := v.([]interface{})
+		out := make([]interface{}, len(arr))
+		for i, ev := range arr {
+			out[i] = dt.Elem.format(ev)
+		}
+		return out
+	}
+	return v
+}
+
+// precision 由 step 推导上报的小数位数 (step 为空时使用最短表示)；
+// 取值以 min 为起点按 step 递增，min 的小数位数更多时以 min 为准
+func (dt *DataType) precision() int {
+	if dt.Step == "" {
+		return -1
+	}
+	return max(decimals(dt.Step), decimals(dt.Min))
+}
+
+// decimals 返回十进制数字串的有效小数位数
+func decimals(s string) int {
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		return len(strings.TrimRight(s[i+1:], "0"))
+	}
+	return 0
+}
+
+func (dt *DataType) checkInt(n int64) error {
+	if dt.Type == "enum" {
+		if len(dt.Enum) > 0 {
+			if _, ok := dt.Enum[strconv.FormatInt(n, 10)]; !ok {
+				return fmt.Errorf("value %d is not a valid enum", n)
+			}
+		}
+		return nil
+	}
+	if dt.Min != "" {
+		if min, err := strconv.ParseInt(dt.Min, 10, 64); err == nil && n < min {
+			return fmt.Errorf("value %d out of range [%s, %s]", n, dt.Min, dt.Max)
+		}
+	}
+	if dt.Max != "" {
+		if max, err := strconv.ParseInt(dt.Max, 10, 64); err == nil && n > max {
+			return fmt.Errorf("value %d out of range [%s, %s]", n, dt.Min, dt.Max)
+		}
+	}
+	if step, err := strconv.ParseInt(dt.Step, 10, 64); err == nil && step > 1 {
+		base, _ := strconv.ParseInt(dt.Min, 10, 64)
+		if (n-base)%step != 0 {
+			return fmt.Errorf("value %d is not a multiple of step %d", n, step)
+		}
+	}
+	return nil
+}
+
+func (dt *DataType) checkFloat(f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("value %v is not a finite number", f)
+	}
+	if dt.Min != "" {
+		if min, err := strconv.ParseFloat(dt.Min, 64); err == nil && f < min {
+			return fmt.Errorf("value %v out of range [%s, %s]", f, dt.Min, dt.Max)
+		}
+	}
+	if dt.Max != "" {
+		if max, err := strconv.ParseFloat(dt.Max, 64); err == nil && f > max {
+			return fmt.Errorf("value %v out of range [%s, %s]", f, dt.Min, dt.Max)
+		}
+	}
+	if step, err := strconv.ParseFloat(dt.Step, 64); err == nil && step > 0 {
+		base, _ := strconv.ParseFloat(dt.Min, 64)
+		n := (f - base) / step
+		if math.Abs(n-math.Round(n)) > 1e-6 {
+			return fmt.Errorf("value %v is not a multiple of step %s", f, dt.Step)
+		}
+	}
+	return nil
+}
+
+// toInt64 将 JSON 数值转换为整数，拒绝带小数的取值
+func toInt64(raw interface{}) (int64, error) {
+	switch v := raw.(type) {
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return 0, fmt.Errorf("value %v is not a number", raw)
+		}
+		return toInt64(f)
+	case float64:
+		if v != math.Trunc(v) || math.IsInf(v, 0) {
+			return 0, fmt.Errorf("value %v is not an integer", v)
+		}
+		if v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, fmt.Errorf("value %v overflows int64", v)
+		}
+		return int64(v), nil
+	case float32:
+		return toInt64(float64(v))
+	}
+	return 0, fmt.Errorf("value %v is not a number", raw)
+}
+
+// toFloat64 将 JSON 数值转换为浮点数
+func toFloat64(raw interface{}) (float64, error) {
+	switch v := raw.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return 0, fmt.Errorf("value %v is not a number", raw)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("value %v is not a number", raw)
+}
@@ -0,0 +1,73 @@
+// Package topics 定义 OneNET 物模型 ($sys) Topic 并提供构造与解析。
+//
+// 设备 Topic 统一为 $sys/{product-id}/{device-name}/<suffix>，
+// 本包的常量为 <suffix> 部分，模板可包含 {product-id} 与 {device-name} 占位符。
+package topics
+
+import "strings"
+
+// 占位符
+const (
+	ProductIDPlaceholder  = "{product-id}"
+	DeviceNamePlaceholder = "{device-name}"
+)
+
+// Prefix 设备 Topic 模板前缀
+const Prefix = "$sys/" + ProductIDPlaceholder + "/" + DeviceNamePlaceholder + "/"
+
+// Topic 后缀 (设备 -> 云端为发布，云端 -> 设备为订阅)
+const (
+	PropertyPost      = "thing/property/post"       // 发布: 上报属性
+	PropertyPostReply = "thing/property/post/reply" // 订阅: 上报属性响应
+	PropertySet       = "thing/property/set"        // 订阅: 平台设置属性
+	PropertySetReply  = "thing/property/set_reply"  // 发布: 属性设置响应
+	PropertyGet       = "thing/property/get"        // 订阅: 平台获取属性
+	PropertyGetReply  = "thing/property/get_reply"  // 发布: 回复平台获取属性
+	EventPost         = "thing/event/post"          // 发布: 上报事件
+	EventPostReply    = "thing/event/post/reply"    // 订阅: 上报事件响应
+	PackPost          = "thing/pack/post"           // 发布: 批量上报属性或事件
+	PackPostReply     = "thing/pack/post/reply"     // 订阅: 批量上报响应
+	NtpRequest        = "thing/ntp/request"         // 发布: 请求时间同步
+	NtpResponse       = "thing/ntp/response"        // 订阅: 时间同步结果
+	RawUp             = "thing/model/up_raw"        // 发布: 透传数据上行
+	RawDown           = "thing/model/down_raw"      // 订阅: 透传数据下行
+)
+
+// ServiceInvoke 平台调用服务 identifier 的 Topic 后缀 (订阅)
+func ServiceInvoke(identifier string) string {
+	return "thing/service/" + identifier + "/invoke"
+}
+
+// ServiceInvokeReply 回复服务调用的 Topic 后缀 (发布)
+func ServiceInvokeReply(identifier string) string {
+	return "thing/service/" + identifier + "/invoke_reply"
+}
+
+// Device 返回设备的完整 Topic: $sys/{productID}/{deviceName}/{suffix}
+func Device(productID, deviceName, suffix string) string {
+	return "$sys/" + productID + "/" + deviceName + "/" + suffix
+}
+
+// Expand 替换模板中的 {product-id} 与 {device-name} 占位符
+func Expand(template, productID, deviceName string) string {
+	s := strings.ReplaceAll(template, ProductIDPlaceholder, productID)
+	return strings.ReplaceAll(s, DeviceNamePlaceholder, deviceName)
+}
+
+// Parse 解析 $sys/{pid}/{device-name}/thing/... 形式的 Topic，suffix 以 thing/ 开头
+func Parse(topic string) (productID, deviceName, suffix string, ok bool) {
+	productID, deviceName, suffix, ok = ParseDevice(topic)
+	if !ok || !strings.HasPrefix(suffix, "thing/") {
+		return "", "", "", false
+	}
+	return productID, deviceName, suffix, true
+}
+
+// ParseDevice 解析任意 $sys/{pid}/{device-name}/{suffix} 形式的设备 Topic
+func ParseDevice(topic string) (productID, deviceName, suffix string, ok bool) {
+	parts := strings.SplitN(topic, "/", 4)
+	if len(parts) != 4 || parts[0] != "$sys" || parts[1] == "" || parts[2] == "" || parts[3] == "" {
+		return "", "", "", false
+	}
+	return parts[1], parts[2], parts[3], true
+}
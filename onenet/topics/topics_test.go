@@ -0,0 +1,51 @@
+package topics
+
+import "testing"
+
+func TestDeviceAndExpand(t *testing.T) {
+	if got := Device("pid", "dev1", PropertyPost); got != "$sys/pid/dev1/thing/property/post" {
+		t.Errorf("Device = %q", got)
+	}
+	if got := Expand(Prefix+PropertySetReply, "pid", "dev1"); got != "$sys/pid/dev1/thing/property/set_reply" {
+		t.Errorf("Expand = %q", got)
+	}
+	if got := Expand("{device-name}/{device-name}", "pid", "dev1"); got != "dev1/dev1" {
+		t.Errorf("Expand = %q", got)
+	}
+	if got := ServiceInvokeReply("reboot"); got != "thing/service/reboot/invoke_reply" {
+		t.Errorf("ServiceInvokeReply = %q", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		topic        string
+		pid, dev, sx string
+		ok           bool
+	}{
+		{"$sys/pid/dev1/thing/property/post/reply", "pid", "dev1", PropertyPostReply, true},
+		{"$sys/pid/dev1/thing/service/reboot/invoke", "pid", "dev1", ServiceInvoke("reboot"), true},
+		{"$sys/pid/dev1/custom/up", "", "", "", false},
+		{"sys/pid/dev1/thing/property/post", "", "", "", false},
+		{"$sys/pid", "", "", "", false},
+	}
+	for _, tt := range tests {
+		pid, dev, sx, ok := Parse(tt.topic)
+		if pid != tt.pid || dev != tt.dev || sx != tt.sx || ok != tt.ok {
+			t.Errorf("Parse(%q) = %q, %q, %q, %v", tt.topic, pid, dev, sx, ok)
+		}
+	}
+}
+
+func TestParseDevice(t *testing.T) {
+	pid, dev, sx, ok := ParseDevice("$sys/pid/dev1/custom/reply")
+	if !ok || pid != "pid" || dev != "dev1" || sx != "custom/reply" {
+		t.Errorf("ParseDevice = %q, %q, %q, %v", pid, dev, sx, ok)
+	}
+	if _, _, _, ok := Parse("$sys/pid/dev1/custom"); ok {
+		t.Error("Parse must only accept thing/ topics")
+	}
+	if _, _, _, ok := ParseDevice("$sys/pid//custom"); ok {
+		t.Error("empty device name must be rejected")
+	}
+}
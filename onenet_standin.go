@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"qsiot_server/onenet/auth"
+	"qsiot_server/onenet/device"
+	"qsiot_server/onenet/push"
+	"qsiot_server/onenet/topics"
+)
+
+// ======================================================================
+// OneNET 平台模拟 (挂接在本地 Broker 上)
+//
+// 模拟平台对设备上行消息的处理:
+//   thing/property/post  -> property/post/reply (按物模型校验，非法时 code 400)
+//   thing/event/post     -> event/post/reply
+//   thing/pack/post      -> pack/post/reply
+//   thing/ntp/request    -> ntp/response (四时间戳)
+//   register             -> register/reply (平台模拟专用的动态注册，下发设备密钥)
+// 并记录设备上下线，用于验证平台侧的在线/离线处理。
+//
+// 开启动态注册 (EnableRegistration) 后同时校验连接 Token: 产品级注册密钥签发的
+// 产品级/设备级 Token，或已注册设备以自身密钥签发的设备级 Token。
+// 该注册协议 (standInRegisterTopic) 是模拟器与平台模拟之间的约定，真实 OneNET 没有对应的 Topic，
+// 仅用于在本地批量接入未在控制台创建的设备。
+//
+// 开启数据推送 (EnablePush) 后，通过校验的属性上报与事件上报按 OneNET HTTP 推送格式
+// 转发到应用服务器 (见 onenet/push)，用于验证北向送达。
+//
+// 平台还缓存每台设备最近上报的属性值，并把设备的 *_reply 分发给等待中的平台请求
+// (应用 OpenAPI 模拟，见 openapi_mock.go)。
+// ======================================================================
+
+// 平台模拟专用的动态注册 Topic 后缀 ($sys/{pid}/{device-name}/<suffix>，真实 OneNET 不支持)
+const (
+	standInRegisterTopic      = "register"       // 发布: 以设备名申请设备密钥
+	standInRegisterReplyTopic = "register/reply" // 订阅: 注册结果 (data.key 为设备密钥)
+)
+
+// DeviceSession 设备在平台侧的在线统计
+type DeviceSession struct {
+	Online      bool
+	Connects    int
+	Disconnects int
+	LastOnline  time.Time
+	LastOffline time.Time
+	Messages    int
+}
+
+// OneNETStandIn 平台模拟
+type OneNETStandIn struct {
+	broker *LocalBroker
+
+	mu       sync.Mutex
+	sessions map[string]*DeviceSession
+
+	// 动态注册 (productKey 为空时未开启)
+	productKey string
+	deviceKeys map[string]string
+
+	// 数据推送 (pushURL 为空时未开启)
+	pushURL, pushToken, pushAESKey string
+	pushSeq                        int64
+
+	properties map[string]map[string]ReportedProperty // 设备名 -> 最近上报的属性
+	waiters    map[pendingRequest]chan device.Reply   // 等待设备回复的平台请求
+}
+
+// ReportedProperty 平台缓存的一条最近上报的属性值
+type ReportedProperty struct {
+	Value interface{}
+	Time  time.Time
+}
+
+// pendingRequest 标识一条等待中的平台请求: 设备、回复 Topic 后缀与请求 id
+type pendingRequest struct {
+	device, suffix, id string
+}
+
+// NewOneNETStandIn 在 Broker 上挂接平台模拟
+func NewOneNETStandIn(b *LocalBroker) *OneNETStandIn {
+	s := &OneNETStandIn{
+		broker:     b,
+		sessions:   make(map[string]*DeviceSession),
+		properties: make(map[string]map[string]ReportedProperty),
+		waiters:    make(map[pendingRequest]chan device.Reply),
+	}
+	b.OnConnect = s.onConnect
+	b.OnDisconnect = s.onDisconnect
+	b.OnPublish = s.onPublish
+	return s
+}
+
+// Session 返回设备在线统计的快照
+func (s *OneNETStandIn) Session(deviceName string) (DeviceSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds, ok := s.sessions[deviceName]
+	if !ok {
+		return DeviceSession{}, false
+	}
+	return *ds, true
+}
+
+// EnableRegistration 开启平台模拟专用的动态注册，并按 productKey 与已注册的设备密钥校验连接 Token
+func (s *OneNETStandIn) EnableRegistration(productKey string) {
+	s.mu.Lock()
+	s.productKey = productKey
+	if s.deviceKeys == nil {
+		s.deviceKeys = make(map[string]string)
+	}
+	s.mu.Unlock()
+	s.broker.Authenticate = s.authenticate
+}
+
+// Properties 返回设备最近上报 (且通过校验) 的属性值
+func (s *OneNETStandIn) Properties(deviceName string) map[string]ReportedProperty {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]ReportedProperty, len(s.properties[deviceName]))
+	for id, p := range s.properties[deviceName] {
+		out[id] = p
+	}
+	return out
+}
+
+// cacheProperties 缓存一次已接受的属性上报 (带 time 时以其为上报时间)
+func (s *OneNETStandIn) cacheProperties(deviceName string, payload []byte) {
+	var req struct {
+		Params map[string]struct {
+			Value interface{} `json:"value"`
+			Time  int64       `json:"time"`
+		} `json:"params"`
+	}
+	if json.Unmarshal(payload, &req) != nil {
+		return
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	props := s.properties[deviceName]
+	if props == nil {
+		props = make(map[string]ReportedProperty)
+		s.properties[deviceName] = props
+	}
+	for id, p := range req.Params {
+		t := now
+		if p.Time > 0 {
+			t = time.UnixMilli(p.Time)
+		}
+		props[id] = ReportedProperty{Value: p.Value, Time: t}
+	}
+}
+
+// Request 向设备下发一条平台请求 (suffix 如 topics.PropertySet)，并等待其在 replySuffix 上
+// 回复相同 id；timeout 内未回复时返回 ok=false
+func (s *OneNETStandIn) Request(productID, deviceName, suffix, replySuffix string, req device.Request, timeout time.Duration) (reply device.Reply, ok bool) {
+	key := pendingRequest{deviceName, replySuffix, req.ID}
+	ch := make(chan device.Reply, 1)
+	s.mu.Lock()
+	s.waiters[key] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.waiters, key)
+		s.mu.Unlock()
+	}()
+
+	payload, _ := json.Marshal(req)
+	s.broker.Publish(topics.Device(productID, deviceName, suffix), payload, 1, false)
+	select {
+	case reply = <-ch:
+		return reply, true
+	case <-time.After(timeout):
+		return device.Reply{}, false
+	}
+}
+
+// deliverReply 将设备的回复交给等待中的平台请求
+func (s *OneNETStandIn) deliverReply(deviceName, suffix string, payload []byte) {
+	r, err := device.ParseReply(payload)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	ch := s.waiters[pendingRequest{deviceName, suffix, r.ID}]
+	s.mu.Unlock()
+	if ch != nil {
+		select {
+		case ch <- r:
+		default:
+		}
+	}
+}
+
+// EnablePush 开启数据推送: 通过校验的属性/事件上报以 token 签名 (aesKey 非空时加密) 推送到 url
+func (s *OneNETStandIn) EnablePush(url, token, aesKey string) {
+	s.mu.Lock()
+	s.pushURL, s.pushToken, s.pushAESKey = url, token, aesKey
+	s.mu.Unlock()
+}
+
+// DeviceKey 返回动态注册下发给设备的密钥
+func (s *OneNETStandIn) DeviceKey(deviceName string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.deviceKeys[deviceName]
+	return key, ok
+}
+
+// authenticate 校验 CONNECT 中的 Token (clientID 为设备名，username 为产品 ID)
+func (s *OneNETStandIn) authenticate(clientID, username, password string) bool {
+	s.mu.Lock()
+	productKey, deviceKey := s.productKey, s.deviceKeys[clientID]
+	s.mu.Unlock()
+
+	now := time.Now()
+	if f, err := auth.Verify(password, productKey, now); err == nil {
+		if f.Res == auth.ProductResource(username) || f.Res == auth.DeviceResource(username, clientID) {
+			return true
+		}
+	}
+	if deviceKey != "" {
+		if f, err := auth.Verify(password, deviceKey, now); err == nil && f.Res == auth.DeviceResource(username, clientID) {
+			return true
+		}
+	}
+	log.Printf("[平台] ⛔ 拒绝接入: %s (Token 校验失败)", clientID)
+	return false
+}
+
+// register 处理动态注册请求: 为设备名生成密钥 (已注册时返回原密钥)
+func (s *OneNETStandIn) register(productID, deviceName string, payload []byte) {
+	reply := device.Reply{Code: device.CodeSuccess, Msg: "success"}
+	req, err := device.ParseRequest(payload)
+	reply.ID = req.ID
+
+	s.mu.Lock()
+	enabled := s.productKey != ""
+	key, exists := s.deviceKeys[deviceName]
+	if err == nil && enabled && !exists {
+		raw := make([]byte, 32)
+		rand.Read(raw)
+		key = base64.StdEncoding.EncodeToString(raw)
+		s.deviceKeys[deviceName] = key
+	}
+	s.mu.Unlock()
+
+	switch {
+	case err != nil:
+		reply.Code, reply.Msg = device.CodeBadRequest, err.Error()
+	case !enabled:
+		reply.Code, reply.Msg = device.CodeBadRequest, "dynamic registration is disabled"
+	default:
+		reply.Data = map[string]string{"deviceName": deviceName, "key": key}
+		if exists {
+			log.Printf("[平台] 🔑 设备重复注册，返回已有密钥: %s", deviceName)
+		} else {
+			log.Printf("[平台] 🔑 动态注册设备: %s", deviceName)
+		}
+	}
+	out, _ := json.Marshal(reply)
+	s.broker.Publish(topics.Device(productID, deviceName, standInRegisterReplyTopic), out, 1, false)
+}
+
+func (s *OneNETStandIn) session(deviceName string) *DeviceSession {
+	ds, ok := s.sessions[deviceName]
+	if !ok {
+		ds = &DeviceSession{}
+		s.sessions[deviceName] = ds
+	}
+	return ds
+}
+
+func (s *OneNETStandIn) onConnect(clientID, username string) {
+	s.mu.Lock()
+	ds := s.session(clientID)
+	ds.Online = true
+	ds.Connects++
+	ds.LastOnline = time.Now()
+	var downtime time.Duration
+	if !ds.LastOffline.IsZero() {
+		downtime = ds.LastOnline.Sub(ds.LastOffline)
+	}
+	n := ds.Connects
+	s.mu.Unlock()
+
+	if downtime > 0 {
+		log.Printf("[平台] 🟢 设备上线: %s (第 %d 次, 离线 %v)", clientID, n, downtime.Round(time.Millisecond))
+	} else {
+		log.Printf("[平台] 🟢 设备上线: %s", clientID)
+	}
+}
+
+func (s *OneNETStandIn) onDisconnect(clientID string) {
+	s.mu.Lock()
+	ds := s.session(clientID)
+	ds.Online = false
+	ds.Disconnects++
+	ds.LastOffline = time.Now()
+	s.mu.Unlock()
+	log.Printf("[平台] 🔴 设备离线: %s", clientID)
+}
+
+func (s *OneNETStandIn) onPublish(clientID, topic string, payload []byte) {
+	if pid, name, suffix, ok := topics.ParseDevice(topic); ok && suffix == standInRegisterTopic {
+		s.register(pid, name, payload)
+		return
+	}
+	pid, name, suffix, ok := topics.Parse(topic)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	s.session(name).Messages++
+	s.mu.Unlock()
+
+	if strings.HasSuffix(suffix, "_reply") {
+		s.deliverReply(name, suffix, payload)
+		return
+	}
+	switch suffix {
+	case topics.PropertyPost:
+		if s.reply(topics.Device(pid, name, topics.PropertyPostReply), payload, validatePropertyPost) {
+			s.cacheProperties(name, payload)
+			s.push(pid, name, push.NotifyProperty, payload)
+		}
+	case topics.EventPost:
+		if s.reply(topics.Device(pid, name, topics.EventPostReply), payload, validateEventPost) {
+			s.push(pid, name, push.NotifyEvent, payload)
+		}
+	case topics.PackPost:
+		s.reply(topics.Device(pid, name, topics.PackPostReply), payload, nil)
+	case topics.NtpRequest:
+		s.replyNtp(topics.Device(pid, name, topics.NtpResponse), payload)
+	}
+}
+
+// reply 在 topic 上回复上报请求，validate 返回错误时 code 为 400；返回上报是否被接受
+func (s *OneNETStandIn) reply(topic string, payload []byte, validate func(params map[string]interface{}) error) bool {
+	out := postReply(payload, validate)
+	s.broker.Publish(topic, out, 1, false)
+	r, err := device.ParseReply(out)
+	return err == nil && r.OK()
+}
+
+// push 开启数据推送时，在后台将一条已接受的上报推送到应用服务器
+func (s *OneNETStandIn) push(productID, deviceName, notifyType string, payload []byte) {
+	s.mu.Lock()
+	url, token, aesKey := s.pushURL, s.pushToken, s.pushAESKey
+	s.pushSeq++
+	id := fmt.Sprintf("push-%d", s.pushSeq)
+	s.mu.Unlock()
+	if url == "" {
+		return
+	}
+
+	var req struct {
+		ID     string          `json:"id"`
+		Params json.RawMessage `json:"params"`
+	}
+	json.Unmarshal(payload, &req)
+	var n push.Notification
+	n.MessageType, n.NotifyType, n.ProductID, n.DeviceName = "notify", notifyType, productID, deviceName
+	n.Data.ID, n.Data.Params = req.ID, req.Params
+	msg, _ := json.Marshal(n)
+	e, err := push.Seal(token, aesKey, id, msg, time.Now())
+	if err != nil {
+		log.Printf("[平台] 数据推送失败 %s: %v", deviceName, err)
+		return
+	}
+	body, _ := json.Marshal(e)
+
+	go func() {
+		resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("%s", resp.Status)
+			}
+		}
+		if err != nil {
+			log.Printf("[平台] 数据推送失败 %s %s id=%s: %v", deviceName, notifyType, req.ID, err)
+		}
+	}()
+}
+
+// replyNtp 回复时间同步请求
+func (s *OneNETStandIn) replyNtp(topic string, payload []byte) {
+	if out := ntpReply(payload, time.Now()); out != nil {
+		s.broker.Publish(topic, out, 1, false)
+	}
+}
+
+// postReply 构造平台对上报请求的回复 (MQTT 与 HTTP 平台模拟共用)，validate 返回错误时 code 为 400
+func postReply(payload []byte, validate func(params map[string]interface{}) error) []byte {
+	var msg struct {
+		ID     string                 `json:"id"`
+		Params map[string]interface{} `json:"params"`
+	}
+	code, text := device.CodeSuccess, "success"
+	dec := json.NewDecoder(strings.NewReader(string(payload)))
+	dec.UseNumber()
+	if err := dec.Decode(&msg); err != nil {
+		code, text = device.CodeBadRequest, fmt.Sprintf("invalid json: %v", err)
+	} else if validate != nil {
+		if err := validate(msg.Params); err != nil {
+			code, text = device.CodeBadRequest, err.Error()
+		}
+	}
+	out, _ := json.Marshal(device.Reply{ID: msg.ID, Code: code, Msg: text})
+	return out
+}
+
+// ntpReply 构造时间同步响应 (recv 为收到请求的时间)，请求无法解析时返回 nil
+func ntpReply(payload []byte, recv time.Time) []byte {
+	var req struct {
+		DeviceSendTime json.Number `json:"deviceSendTime"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil
+	}
+	out, _ := json.Marshal(map[string]string{
+		"deviceSendTime": req.DeviceSendTime.String(),
+		"serverRecvTime": fmt.Sprintf("%d", recv.UnixMilli()),
+		"serverSendTime": fmt.Sprintf("%d", time.Now().UnixMilli()),
+	})
+	return out
+}
+
+// validatePropertyPost 按物模型校验属性上报 params: {"id": {"value": v, "time": ms}}
+func validatePropertyPost(params map[string]interface{}) error {
+	for id, raw := range params {
+		p, ok := thingModelSpec.Property(id)
+		if !ok {
+			return fmt.Errorf("unknown property: %s", id)
+		}
+		entry, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: want {\"value\": ...}", id)
+		}
+		if _, err := p.DataType.Decode(entry["value"]); err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// validateEventPost 按物模型校验事件上报 params: {"event": {"value": {...}, "time": ms}}
+func validateEventPost(params map[string]interface{}) error {
+	for id, raw := range params {
+		e, ok := thingModelSpec.Event(id)
+		if !ok {
+			return fmt.Errorf("unknown event: %s", id)
+		}
+		entry, _ := raw.(map[string]interface{})
+		value, ok := entry["value"].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: want {\"value\": {...}}", id)
+		}
+		for k, v := range value {
+			f, ok := e.Param(k)
+			if !ok {
+				return fmt.Errorf("%s: unknown output parameter %s", id, k)
+			}
+			if _, err := f.DataType.Decode(v); err != nil {
+				return fmt.Errorf("%s.%s: %w", id, k, err)
+			}
+		}
+	}
+	return nil
+}
+
+// runBrokerCommand 实现 `broker` 子命令: 启动本地 Broker 与平台模拟
+func runBrokerCommand(args []string) int {
+	fs := flag.NewFlagSet("broker", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:1883", "监听地址")
+	registerKey := fs.String("standin-register-key", "", "平台模拟专用的动态注册密钥 (base64)，非空时开启模拟器与平台模拟之间的注册约定并校验连接 Token (真实 OneNET 不支持)")
+	httpAddr := fs.String("http", "", "同时启动 HTTP 接入平台模拟并监听该地址 (如 127.0.0.1:8081)")
+	httpKey := fs.String("http-key", "", "HTTP 接入校验 Token 使用的产品 Access Key，为空时不校验签名")
+	pushURL := fs.String("push-url", "", "数据推送地址 (如 http://127.0.0.1:8090/)，非空时将属性/事件上报推送到该地址")
+	pushToken := fs.String("push-token", "", "数据推送签名使用的 token")
+	pushAESKey := fs.String("push-aes-key", "", "数据推送加密使用的 AES Key (43 个字符)，为空时明文推送")
+	apiAddr := fs.String("api", "", "同时启动应用 OpenAPI 模拟并监听该地址 (如 127.0.0.1:8082)")
+	apiKey := fs.String("api-key", "", "OpenAPI 校验 authorization Token 使用的 Access Key，为空时不校验")
+	apiTimeout := fs.Duration("api-timeout", 5*time.Second, "OpenAPI 同步接口等待设备回复的时长")
+	fs.Parse(args)
+
+	b := NewLocalBroker()
+	standIn := NewOneNETStandIn(b)
+	if *registerKey != "" {
+		standIn.EnableRegistration(*registerKey)
+		log.Printf("平台模拟动态注册已开启 (仅本模拟支持)，连接需通过 Token 校验")
+	}
+	if *pushURL != "" {
+		standIn.EnablePush(*pushURL, *pushToken, *pushAESKey)
+		log.Printf("数据推送已开启: %s", *pushURL)
+	}
+	if err := b.Listen(*listen); err != nil {
+		log.Printf("启动本地 Broker 失败: %v", err)
+		return 1
+	}
+	log.Printf("==== 本地 Broker 已启动: %s ====", b.URL())
+	if *httpAddr != "" {
+		srv := &http.Server{Addr: *httpAddr, Handler: NewHTTPStandIn(*httpKey)}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("HTTP 接入平台模拟退出: %v", err)
+			}
+		}()
+		defer srv.Close()
+		log.Printf("==== HTTP 接入平台模拟已启动: http://%s ====", *httpAddr)
+	}
+	if *apiAddr != "" {
+		api := NewOpenAPIMock(standIn, *apiKey)
+		api.Timeout = *apiTimeout
+		srv := &http.Server{Addr: *apiAddr, Handler: api.Handler()}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("OpenAPI 模拟退出: %v", err)
+			}
+		}()
+		defer srv.Close()
+		log.Printf("==== OpenAPI 模拟已启动: http://%s ====", *apiAddr)
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	b.Close()
+	log.Printf("==== 本地 Broker 已退出 ====")
+	return 0
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"qsiot_server/onenet/auth"
+	"qsiot_server/onenet/device"
+	"qsiot_server/onenet/topics"
+)
+
+// ======================================================================
+// OneNET 应用 OpenAPI 模拟 (挂接在平台模拟上)
+//
+// 应用侧集成测试无需登录控制台即可向模拟设备下发命令:
+//   POST /thingmodel/set-device-property     {product_id, device_name, params: {id: value}}
+//   POST /thingmodel/get-device-property     {product_id, device_name, params: [id, ...]}
+//   POST /thingmodel/call-service            {product_id, device_name, identifier, params: {...}}
+//   GET  /thingmodel/query-device-property   ?product_id=&device_name=   平台缓存的最近上报值
+//   GET  /device/detail                      ?product_id=&device_name=   在线状态
+//
+// 同步接口经本地 Broker 向设备下发 property/set、property/get、service/{id}/invoke，
+// 等待设备回复后返回。响应均为 HTTP 200 + {"code", "msg", "request_id", "data"}，
+// code 为 0 表示成功，失败码见 apiCode* 常量。key 非空时校验请求头 authorization 中的 Token 签名。
+// ======================================================================
+
+// OpenAPI 模拟的返回码
+const (
+	apiCodeOK           = 0
+	apiCodeBadRequest   = 10400 // 请求参数错误
+	apiCodeUnauthorized = 10401 // authorization 校验失败
+	apiCodeNoDevice     = 10404 // 设备从未接入
+	apiCodeOffline      = 10409 // 设备不在线
+	apiCodeTimeout      = 10408 // 设备未在超时时间内回复
+	apiCodeDeviceError  = 10410 // 设备回复错误 (msg 为设备回复的 code 与 msg)
+)
+
+// 设备状态 (/device/detail 的 status)
+const (
+	apiStatusOffline  = 0
+	apiStatusOnline   = 1
+	apiStatusInactive = 2 // 从未接入
+)
+
+// OpenAPIMock 应用 OpenAPI 模拟
+type OpenAPIMock struct {
+	standIn *OneNETStandIn
+	key     string // 校验 authorization 的 Access Key，为空时不校验
+
+	// Timeout 同步接口等待设备回复的时长
+	Timeout time.Duration
+
+	mu  sync.Mutex
+	seq int64
+}
+
+// NewOpenAPIMock 创建挂接在平台模拟上的 OpenAPI 模拟
+func NewOpenAPIMock(s *OneNETStandIn, key string) *OpenAPIMock {
+	return &OpenAPIMock{standIn: s, key: key, Timeout: 5 * time.Second}
+}
+
+// apiResponse OpenAPI 响应体
+type apiResponse struct {
+	Code      int         `json:"code"`
+	Msg       string      `json:"msg"`
+	RequestID string      `json:"request_id"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// apiRequest 同步接口的请求体
+type apiRequest struct {
+	ProductID  string          `json:"product_id"`
+	DeviceName string          `json:"device_name"`
+	Identifier string          `json:"identifier"`
+	Params     json.RawMessage `json:"params"`
+}
+
+// apiProperty query-device-property 返回的一条属性
+type apiProperty struct {
+	Identifier string `json:"identifier"`
+	Name       string `json:"name,omitempty"`
+	AccessMode string `json:"access_mode,omitempty"`
+	DataType   string `json:"data_type,omitempty"`
+	Value      string `json:"value"`
+	Time       int64  `json:"time"`
+}
+
+// apiDeviceDetail /device/detail 的 data
+type apiDeviceDetail struct {
+	ProductID   string `json:"product_id"`
+	DeviceName  string `json:"device_name"`
+	Status      int    `json:"status"`
+	LastOnline  int64  `json:"last_online,omitempty"`
+	LastOffline int64  `json:"last_offline,omitempty"`
+}
+
+// Handler 返回 OpenAPI 的 HTTP 处理
+func (m *OpenAPIMock) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /thingmodel/set-device-property", m.setProperty)
+	mux.HandleFunc("POST /thingmodel/get-device-property", m.getProperty)
+	mux.HandleFunc("POST /thingmodel/call-service", m.callService)
+	mux.HandleFunc("GET /thingmodel/query-device-property", m.queryProperty)
+	mux.HandleFunc("GET /device/detail", m.deviceDetail)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.key != "" {
+			if _, err := auth.Verify(r.Header.Get("authorization"), m.key, time.Now()); err != nil {
+				log.Printf("[OpenAPI] ⛔ 拒绝请求 %s %s: %v", r.Method, r.URL.Path, err)
+				m.write(w, apiCodeUnauthorized, fmt.Sprintf("authorization: %v", err), nil)
+				return
+			}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (m *OpenAPIMock) write(w http.ResponseWriter, code int, msg string, data interface{}) {
+	m.mu.Lock()
+	m.seq++
+	id := fmt.Sprintf("mock-%d", m.seq)
+	m.mu.Unlock()
+	if code == apiCodeOK && msg == "" {
+		msg = "succ"
+	}
+	writeJSON(w, http.StatusOK, apiResponse{Code: code, Msg: msg, RequestID: id, Data: data})
+}
+
+// decode 解析同步接口的请求体并检查设备在线
+func (m *OpenAPIMock) decode(w http.ResponseWriter, r *http.Request) (apiRequest, bool) {
+	var req apiRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		m.write(w, apiCodeBadRequest, fmt.Sprintf("invalid json: %v", err), nil)
+		return req, false
+	}
+	if req.ProductID == "" || req.DeviceName == "" {
+		m.write(w, apiCodeBadRequest, "product_id and device_name are required", nil)
+		return req, false
+	}
+	code, msg := m.checkOnline(req.DeviceName)
+	if code != apiCodeOK {
+		m.write(w, code, msg, nil)
+		return req, false
+	}
+	return req, true
+}
+
+func (m *OpenAPIMock) checkOnline(deviceName string) (int, string) {
+	ds, ok := m.standIn.Session(deviceName)
+	switch {
+	case !ok:
+		return apiCodeNoDevice, fmt.Sprintf("device %s has never connected", deviceName)
+	case !ds.Online:
+		return apiCodeOffline, fmt.Sprintf("device %s is offline", deviceName)
+	}
+	return apiCodeOK, ""
+}
+
+// call 向设备下发请求并按设备回复写入响应
+func (m *OpenAPIMock) call(w http.ResponseWriter, req apiRequest, suffix, replySuffix string, params interface{}) {
+	m.mu.Lock()
+	m.seq++
+	id := strconv.FormatInt(time.Now().UnixMilli(), 10) + "-" + strconv.FormatInt(m.seq, 10)
+	m.mu.Unlock()
+
+	log.Printf("[OpenAPI] ➡️ %s %s: %s", req.DeviceName, suffix, req.Params)
+	reply, ok := m.standIn.Request(req.ProductID, req.DeviceName, suffix, replySuffix,
+		device.Request{ID: id, Version: device.ProtocolVersion, Params: params}, m.Timeout)
+	switch {
+	case !ok:
+		m.write(w, apiCodeTimeout, fmt.Sprintf("device did not reply within %v", m.Timeout), nil)
+	case !reply.OK():
+		m.write(w, apiCodeDeviceError, fmt.Sprintf("device replied code %d: %s", reply.Code, reply.Msg), nil)
+	default:
+		m.write(w, apiCodeOK, "", reply.Data)
+	}
+}
+
+func (m *OpenAPIMock) setProperty(w http.ResponseWriter, r *http.Request) {
+	req, ok := m.decode(w, r)
+	if !ok {
+		return
+	}
+	var params map[string]interface{}
+	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) == 0 {
+		m.write(w, apiCodeBadRequest, "params must be a non-empty object", nil)
+		return
+	}
+	m.call(w, req, topics.PropertySet, topics.PropertySetReply, params)
+}
+
+func (m *OpenAPIMock) getProperty(w http.ResponseWriter, r *http.Request) {
+	req, ok := m.decode(w, r)
+	if !ok {
+		return
+	}
+	var params []string
+	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) == 0 {
+		m.write(w, apiCodeBadRequest, "params must be a non-empty array of identifiers", nil)
+		return
+	}
+	m.call(w, req, topics.PropertyGet, topics.PropertyGetReply, params)
+}
+
+func (m *OpenAPIMock) callService(w http.ResponseWriter, r *http.Request) {
+	req, ok := m.decode(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := thingModelSpec.Service(req.Identifier); !ok {
+		m.write(w, apiCodeBadRequest, fmt.Sprintf("service %q is not defined in the thing model", req.Identifier), nil)
+		return
+	}
+	params := map[string]interface{}{}
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			m.write(w, apiCodeBadRequest, "params must be an object", nil)
+			return
+		}
+	}
+	m.call(w, req, topics.ServiceInvoke(req.Identifier), topics.ServiceInvokeReply(req.Identifier), params)
+}
+
+func (m *OpenAPIMock) queryProperty(w http.ResponseWriter, r *http.Request) {
+	deviceName := r.URL.Query().Get("device_name")
+	if r.URL.Query().Get("product_id") == "" || deviceName == "" {
+		m.write(w, apiCodeBadRequest, "product_id and device_name are required", nil)
+		return
+	}
+	if _, ok := m.standIn.Session(deviceName); !ok {
+		m.write(w, apiCodeNoDevice, fmt.Sprintf("device %s has never connected", deviceName), nil)
+		return
+	}
+	out := []apiProperty{}
+	reported := m.standIn.Properties(deviceName)
+	for _, p := range thingModelSpec.Properties {
+		v, ok := reported[p.Identifier]
+		if !ok {
+			continue
+		}
+		ap := apiProperty{Identifier: p.Identifier, Name: p.Name, AccessMode: p.AccessMode, DataType: p.DataType.Type, Time: v.Time.UnixMilli()}
+		if s, ok := v.Value.(string); ok {
+			ap.Value = s
+		} else {
+			b, _ := json.Marshal(v.Value)
+			ap.Value = string(b)
+		}
+		out = append(out, ap)
+	}
+	m.write(w, apiCodeOK, "", out)
+}
+
+func (m *OpenAPIMock) deviceDetail(w http.ResponseWriter, r *http.Request) {
+	productID, deviceName := r.URL.Query().Get("product_id"), r.URL.Query().Get("device_name")
+	if productID == "" || deviceName == "" {
+		m.write(w, apiCodeBadRequest, "product_id and device_name are required", nil)
+		return
+	}
+	d := apiDeviceDetail{ProductID: productID, DeviceName: deviceName, Status: apiStatusInactive}
+	if ds, ok := m.standIn.Session(deviceName); ok {
+		d.Status = apiStatusOffline
+		if ds.Online {
+			d.Status = apiStatusOnline
+		}
+		if !ds.LastOnline.IsZero() {
+			d.LastOnline = ds.LastOnline.UnixMilli()
+		}
+		if !ds.LastOffline.IsZero() {
+			d.LastOffline = ds.LastOffline.UnixMilli()
+		}
+	}
+	m.write(w, apiCodeOK, "", d)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"qsiot_server/onenet/auth"
+	"qsiot_server/onenet/thingmodel"
+)
+
+// useServiceFixture 在产品物模型上叠加 testdata 中的测试服务 (产品本身未定义服务)，
+// 并注册 calibrate 的设备端实现；测试结束后恢复
+func useServiceFixture(t *testing.T) {
+	t.Helper()
+	var model map[string]json.RawMessage
+	if err := json.Unmarshal(thingModelJSON, &model); err != nil {
+		t.Fatal(err)
+	}
+	fixture, err := os.ReadFile("testdata/thingmodel_services.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var services struct {
+		Services json.RawMessage `json:"services"`
+	}
+	if err := json.Unmarshal(fixture, &services); err != nil {
+		t.Fatal(err)
+	}
+	model["services"] = services.Services
+	data, _ := json.Marshal(model)
+	spec, err := thingmodel.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldSpec := thingModelSpec
+	thingModelSpec = spec
+	serviceHandlers["calibrate"] = func(d *Device, in map[string]interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{"applied": in["offset"]}, nil
+	}
+	t.Cleanup(func() {
+		thingModelSpec = oldSpec
+		delete(serviceHandlers, "calibrate")
+	})
+}
+
+// apiCall 调用 OpenAPI 模拟并解析响应
+func apiCall(t *testing.T, srv *httptest.Server, method, path string, body interface{}, token string) apiResponse {
+	t.Helper()
+	var rd io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		rd = bytes.NewReader(b)
+	}
+	req, _ := http.NewRequest(method, srv.URL+path, rd)
+	if token != "" {
+		req.Header.Set("authorization", token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return out
+}
+
+func TestOpenAPIMock(t *testing.T) {
+	useServiceFixture(t)
+	_, standIn, _ := startTestPlatform(t)
+	api := NewOpenAPIMock(standIn, AccessKey)
+	api.Timeout = 2 * time.Second
+	srv := httptest.NewServer(api.Handler())
+	defer srv.Close()
+	token, err := auth.Token(auth.ProductResource(ProductID), time.Now().Add(time.Hour).Unix(), AccessKey, auth.MethodSHA1, auth.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const name = "api-dev"
+	target := map[string]interface{}{"product_id": ProductID, "device_name": name}
+	with := func(kv ...interface{}) map[string]interface{} {
+		m := map[string]interface{}{}
+		for k, v := range target {
+			m[k] = v
+		}
+		for i := 0; i < len(kv); i += 2 {
+			m[kv[i].(string)] = kv[i+1]
+		}
+		return m
+	}
+	detail := "/device/detail?product_id=" + ProductID + "&device_name=" + name
+
+	if r := apiCall(t, srv, "GET", detail, nil, ""); r.Code != apiCodeUnauthorized {
+		t.Errorf("no authorization: code = %d", r.Code)
+	}
+	if r := apiCall(t, srv, "GET", detail, nil, token); r.Code != apiCodeOK || r.Data.(map[string]interface{})["status"] != float64(apiStatusInactive) {
+		t.Errorf("detail before connect = %+v", r)
+	}
+	if r := apiCall(t, srv, "POST", "/thingmodel/set-device-property", with("params", map[string]int{"relay": 1}), token); r.Code != apiCodeNoDevice {
+		t.Errorf("set before connect: code = %d", r.Code)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go runDeviceWithStop(name, &wg, stop)
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+	waitFor(t, name+" online", func() bool {
+		d, ok := lookupDevice(name)
+		return ok && d.State() == StateOnline
+	})
+	d, _ := lookupDevice(name)
+
+	if r := apiCall(t, srv, "GET", detail, nil, token); r.Data.(map[string]interface{})["status"] != float64(apiStatusOnline) {
+		t.Errorf("detail after connect = %+v", r)
+	}
+
+	// 设置属性: 设备接受时 code 0，拒绝时返回设备回复的错误
+	if r := apiCall(t, srv, "POST", "/thingmodel/set-device-property", with("params", map[string]int{"relay": 1}), token); r.Code != apiCodeOK {
+		t.Fatalf("set relay=1 = %+v", r)
+	}
+	if v, _ := d.getProperty(PropRelay); v != int32(1) {
+		t.Errorf("device relay = %v after set", v)
+	}
+	if r := apiCall(t, srv, "POST", "/thingmodel/set-device-property", with("params", map[string]int{"relay": 2}), token); r.Code != apiCodeDeviceError {
+		t.Errorf("set relay=2 = %+v", r)
+	}
+
+	// 同步获取属性
+	r := apiCall(t, srv, "POST", "/thingmodel/get-device-property", with("params", []string{"relay"}), token)
+	if data, _ := r.Data.(map[string]interface{}); r.Code != apiCodeOK || data["relay"] == nil {
+		t.Errorf("get relay = %+v", r)
+	}
+
+	// 平台缓存的最近上报值
+	waitFor(t, "reported properties cached", func() bool { return len(standIn.Properties(name)) > 0 })
+	r = apiCall(t, srv, "GET", "/thingmodel/query-device-property?product_id="+ProductID+"&device_name="+name, nil, token)
+	props, _ := r.Data.([]interface{})
+	if r.Code != apiCodeOK || len(props) == 0 {
+		t.Errorf("query properties = %+v", r)
+	}
+
+	// 调用服务: 设备回复输出参数；输入参数不合法或设备未实现时返回设备回复的错误
+	r = apiCall(t, srv, "POST", "/thingmodel/call-service", with("identifier", "calibrate", "params", map[string]int{"offset": -3}), token)
+	if data, _ := r.Data.(map[string]interface{}); r.Code != apiCodeOK || data["applied"] != float64(-3) {
+		t.Errorf("call calibrate = %+v", r)
+	}
+	if r := apiCall(t, srv, "POST", "/thingmodel/call-service", with("identifier", "calibrate", "params", map[string]int{"offset": 11}), token); r.Code != apiCodeDeviceError {
+		t.Errorf("call calibrate offset=11 = %+v", r)
+	}
+	if r := apiCall(t, srv, "POST", "/thingmodel/call-service", with("identifier", "factory_reset"), token); r.Code != apiCodeDeviceError {
+		t.Errorf("call unimplemented factory_reset = %+v", r)
+	}
+
+	// 物模型未定义的服务
+	if r := apiCall(t, srv, "POST", "/thingmodel/call-service", with("identifier", "reboot"), token); r.Code != apiCodeBadRequest {
+		t.Errorf("call undefined service: code = %d", r.Code)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"qsiot_server/onenet/device"
+)
+
+// ======================================================================
+// 动态注册 (仅限平台模拟)
+//
+// 注册协议 ($sys/{pid}/{device-name}/register) 是模拟器与平台模拟 (broker 子命令) 之间的约定，
+// 真实 OneNET 不支持，用于在本地联调时接入未在控制台创建的设备。
+// 配置了平台模拟的注册密钥 (-standin-register-key) 时，本地没有密钥的设备在首次运行时
+// 先以注册密钥签发的 Token 向平台模拟注册，下发的设备密钥保存在 StateDir/device_keys.json:
+//
+//	{"866560088910415": "base64 设备密钥", ...}
+//
+// 之后该设备以自身密钥生成设备级 Token 接入 (getConnectOptions 优先使用已保存的密钥)。
+// 密钥文件不受 -fresh 影响；StateDir 为空时密钥仅保存在内存中。
+// ======================================================================
+
+// StandInRegistrationKey 平台模拟的注册密钥 (可通过命令行参数覆盖)，为空时不进行动态注册
+var StandInRegistrationKey = ""
+
+// kindStandInRegister 注册请求的上行类别 (注册连接不经过设备的中间件链)
+const kindStandInRegister device.Kind = "standin_register"
+
+// errStandInRegisterTimeout 在 ConnectTimeout 内未收到注册结果
+var errStandInRegisterTimeout = errors.New("register: no reply from platform stand-in")
+
+// deviceKeyStore 已注册设备的密钥 (设备名 -> base64 密钥)
+type deviceKeyStore struct {
+	mu     sync.Mutex
+	loaded bool
+	dir    string
+	keys   map[string]string
+}
+
+var deviceKeys = &deviceKeyStore{}
+
+func deviceKeysPath(dir string) string {
+	return filepath.Join(dir, "device_keys.json")
+}
+
+// load 首次访问或 StateDir 变化时读取密钥文件 (调用方持有锁)
+func (s *deviceKeyStore) load() {
+	if s.loaded && s.dir == StateDir {
+		return
+	}
+	s.loaded, s.dir, s.keys = true, StateDir, make(map[string]string)
+	if StateDir == "" {
+		return
+	}
+	data, err := os.ReadFile(deviceKeysPath(StateDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &s.keys)
+	}
+	if err != nil {
+		log.Printf("读取设备密钥文件失败: %v", err)
+	}
+}
+
+// get 返回设备已保存的密钥
+func (s *deviceKeyStore) get(deviceName string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	key, ok := s.keys[deviceName]
+	return key, ok
+}
+
+// put 保存设备密钥并写回密钥文件 (仅属主可读写)
+func (s *deviceKeyStore) put(deviceName, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	s.keys[deviceName] = key
+	if StateDir == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(StateDir, 0o755); err != nil {
+		return err
+	}
+	path := deviceKeysPath(StateDir)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// deviceAccessKey 设备接入使用的密钥: 已注册的设备密钥，否则为产品 Access Key
+func deviceAccessKey(deviceName string) string {
+	if key, ok := deviceKeys.get(deviceName); ok {
+		return key
+	}
+	return AccessKey
+}
+
+// ensureRegistered 开启动态注册时，为尚无密钥的设备向平台模拟注册并保存下发的密钥
+func ensureRegistered(deviceName string) error {
+	if StandInRegistrationKey == "" {
+		return nil
+	}
+	if _, ok := deviceKeys.get(deviceName); ok {
+		return nil
+	}
+
+	log.Printf("[%s] 🔑 本地无设备密钥，开始向平台模拟动态注册...", deviceName)
+	key, err := registerWithStandIn(deviceName)
+	if err != nil {
+		return err
+	}
+	if err := deviceKeys.put(deviceName, key); err != nil {
+		return fmt.Errorf("save device key: %w", err)
+	}
+	log.Printf("[%s] 🔑 动态注册成功，设备密钥已保存", deviceName)
+	return nil
+}
+
+// registerWithStandIn 以注册密钥签发的 Token 接入平台模拟，在 standInRegisterTopic 上以设备名申请设备密钥，
+// 返回下发的 base64 密钥。连接与等待回复均受 ConnectTimeout 限制，返回前断开连接；
+// 注册连接总是清除会话且不使用持久化存储。
+func registerWithStandIn(deviceName string) (string, error) {
+	cfg := connectConfig(deviceName, StandInRegistrationKey)
+	cfg.CleanSession, cfg.Store = true, nil
+	opts, err := cfg.MQTTOptions()
+	if err != nil {
+		return "", err
+	}
+	conn := mqtt.NewClient(opts)
+	token := conn.Connect()
+	if !token.WaitTimeout(cfg.ConnectTimeout) {
+		return "", fmt.Errorf("register: connect timeout after %v", cfg.ConnectTimeout)
+	}
+	if err := token.Error(); err != nil {
+		return "", fmt.Errorf("register: connect: %w", err)
+	}
+	defer conn.Disconnect(250)
+
+	c := device.NewClient(conn, cfg.ProductID, deviceName)
+	id := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	replies := make(chan device.Reply, 1)
+	err = c.Subscribe(c.Topic(standInRegisterReplyTopic), func(_ mqtt.Client, msg mqtt.Message) {
+		r, err := device.ParseReply(msg.Payload())
+		if err != nil || r.ID != id {
+			return
+		}
+		select {
+		case replies <- r:
+		default:
+		}
+	})
+	if err != nil {
+		return "", fmt.Errorf("register: subscribe: %w", err)
+	}
+
+	req := device.Request{ID: id, Version: device.ProtocolVersion, Params: map[string]string{"deviceName": deviceName}}
+	if err := c.PublishJSON(kindStandInRegister, c.Topic(standInRegisterTopic), req); err != nil {
+		return "", fmt.Errorf("register: %w", err)
+	}
+
+	select {
+	case r := <-replies:
+		if !r.OK() {
+			return "", fmt.Errorf("register: code %d: %s", r.Code, r.Msg)
+		}
+		data, _ := r.Data.(map[string]interface{})
+		key, _ := data["key"].(string)
+		if key == "" {
+			return "", fmt.Errorf("register: reply has no device key")
+		}
+		return key, nil
+	case <-time.After(cfg.ConnectTimeout):
+		return "", errStandInRegisterTimeout
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const testRegistrationKey = "CwsLCwsLCwsLCwsLCwsLCwsLCws="
+
+// useRegistration 在测试平台上开启动态注册，设备的产品 Access Key 换成平台不认可的密钥
+func useRegistration(t *testing.T, standIn *OneNETStandIn) {
+	t.Helper()
+	standIn.EnableRegistration(testRegistrationKey)
+	regKey, key := StandInRegistrationKey, AccessKey
+	StandInRegistrationKey, AccessKey = testRegistrationKey, "CwsLCwsLCwsLCwsLCwsLCw=="
+	t.Cleanup(func() { StandInRegistrationKey, AccessKey = regKey, key })
+}
+
+func connectDevice(t *testing.T, name string) error {
+	t.Helper()
+	opts, err := getConnectOptions(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := mqtt.NewClient(opts)
+	token := c.Connect()
+	token.Wait()
+	if token.Error() == nil {
+		c.Disconnect(50)
+	}
+	return token.Error()
+}
+
+func TestDynamicRegistration(t *testing.T) {
+	_, standIn, _ := startTestPlatform(t)
+	useRegistration(t, standIn)
+
+	// 未注册且产品 Access Key 无效的设备被平台拒绝
+	if err := connectDevice(t, "reg-dev"); err == nil {
+		t.Fatal("unregistered device with a wrong key connected")
+	}
+
+	if err := ensureRegistered("reg-dev"); err != nil {
+		t.Fatalf("ensureRegistered: %v", err)
+	}
+	want, ok := standIn.DeviceKey("reg-dev")
+	if !ok {
+		t.Fatal("platform has no key for reg-dev")
+	}
+	if got := deviceAccessKey("reg-dev"); got != want {
+		t.Errorf("device key = %q, want %q", got, want)
+	}
+
+	// 密钥写入 StateDir 且仅属主可读写，重新加载后仍可用
+	path := deviceKeysPath(StateDir)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("%s mode = %v, want 0600", path, info.Mode().Perm())
+	}
+	data, _ := os.ReadFile(path)
+	var saved map[string]string
+	if err := json.Unmarshal(data, &saved); err != nil || saved["reg-dev"] != want {
+		t.Errorf("saved keys = %s (%v)", data, err)
+	}
+	if key, ok := (&deviceKeyStore{}).get("reg-dev"); !ok || key != want {
+		t.Errorf("reloaded key = %q, %v", key, ok)
+	}
+
+	if err := connectDevice(t, "reg-dev"); err != nil {
+		t.Errorf("registered device failed to connect: %v", err)
+	}
+	// 已有密钥时不再注册
+	sessions, _ := standIn.Session("reg-dev")
+	if err := ensureRegistered("reg-dev"); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := standIn.Session("reg-dev"); s.Connects != sessions.Connects {
+		t.Errorf("ensureRegistered reconnected for an already registered device")
+	}
+}
+
+func TestRunDeviceRegistersOnFirstRun(t *testing.T) {
+	_, standIn, _ := startTestPlatform(t)
+	useRegistration(t, standIn)
+
+	const name = "reg-run-dev"
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go runDeviceWithStop(name, &wg, stop)
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	waitFor(t, name+" online", func() bool {
+		d, ok := lookupDevice(name)
+		return ok && d.State() == StateOnline
+	})
+	if _, ok := standIn.DeviceKey(name); !ok {
+		t.Error("device came online without registering")
+	}
+	waitFor(t, name+" property post acknowledged", func() bool {
+		d, _ := lookupDevice(name)
+		return d.Stats.Snapshot().LastReplyCode == 200
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"qsiot_server/onenet/device"
+)
+
+// ======================================================================
+// 按消息类别的 QoS / 保留标志与持久会话
+//
+// 属性上报、事件上报 (含批量上报)、属性设置/获取回复与订阅分别配置 QoS (0 或 1)，
+// -retain 列出需要带保留标志发布的类别；校时请求与透传上行固定为 QoS 1、不保留。
+//
+// -clean-session=false 时以持久会话连接 (MQTT 3.1.1)，paho 未完成的消息
+// (已发出、尚未收到 PUBACK 的 QoS 1 上行) 保存在 StateDir/mqtt_store/{设备名}，
+// 进程重启后随重连重发。StateDir 为空时只能保存在内存中，重启即丢失。
+// ======================================================================
+
+// QoS 与会话配置 (可通过命令行参数覆盖)
+var (
+	PropertyPostQoS = 1
+	EventPostQoS    = 1
+	ReplyQoS        = 1
+	SubscribeQoS    = 1
+	RetainKinds     = ""   // 带保留标志发布的类别: property,event,reply
+	CleanSession    = true // false 时以持久会话连接
+)
+
+// publishCategories 可配置的上行消息类别 -> 对应的 device.Kind
+var publishCategories = map[string][]device.Kind{
+	"property": {device.KindPropertyPost},
+	"event":    {device.KindEventPost},
+	"reply":    {device.KindSetReply, device.KindGetReply, device.KindServiceReply},
+}
+
+// checkPublishQoS 校验 QoS 与保留配置
+func checkPublishQoS() error {
+	for name, qos := range map[string]int{
+		"-property-qos":  PropertyPostQoS,
+		"-event-qos":     EventPostQoS,
+		"-reply-qos":     ReplyQoS,
+		"-subscribe-qos": SubscribeQoS,
+	} {
+		if qos != 0 && qos != 1 {
+			return fmt.Errorf("%s must be 0 or 1", name)
+		}
+	}
+	_, err := parseRetainKinds(RetainKinds)
+	return err
+}
+
+// parseRetainKinds 解析逗号分隔的保留类别列表
+func parseRetainKinds(s string) (map[string]bool, error) {
+	retain := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if _, ok := publishCategories[name]; !ok {
+			return nil, fmt.Errorf("unknown retain category %q (want property, event or reply)", name)
+		}
+		retain[name] = true
+	}
+	return retain, nil
+}
+
+// publishOptions 按配置生成设备 Client 的发布与订阅 QoS 选项
+func publishOptions() []device.ClientOption {
+	retain, _ := parseRetainKinds(RetainKinds) // 已在启动时校验
+	qos := map[string]int{"property": PropertyPostQoS, "event": EventPostQoS, "reply": ReplyQoS}
+	var opts []device.ClientOption
+	for name, kinds := range publishCategories {
+		o := device.PublishOptions{QoS: byte(qos[name]), Retain: retain[name]}
+		for _, kind := range kinds {
+			opts = append(opts, device.WithPublishOptions(kind, o))
+		}
+	}
+	return append(opts, device.WithSubscribeQoS(byte(SubscribeQoS)))
+}
+
+// sessionStore 持久会话时设备的 paho 文件存储；清除会话或 StateDir 为空时为 nil (内存存储)
+func sessionStore(deviceName string) mqtt.Store {
+	if CleanSession || StateDir == "" {
+		return nil
+	}
+	return mqtt.NewFileStore(filepath.Join(StateDir, "mqtt_store", deviceName))
+}
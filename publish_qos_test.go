@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"qsiot_server/onenet/device"
+)
+
+func TestCheckPublishQoS(t *testing.T) {
+	qos, retain := EventPostQoS, RetainKinds
+	t.Cleanup(func() { EventPostQoS, RetainKinds = qos, retain })
+
+	EventPostQoS, RetainKinds = 0, "property, reply"
+	if err := checkPublishQoS(); err != nil {
+		t.Errorf("valid config: %v", err)
+	}
+	EventPostQoS = 2
+	if err := checkPublishQoS(); err == nil {
+		t.Error("qos 2: expected error")
+	}
+	EventPostQoS, RetainKinds = 1, "property,ntp"
+	if err := checkPublishQoS(); err == nil {
+		t.Error("unknown retain category: expected error")
+	}
+}
+
+func TestRetainedPropertyPostWithPersistentSession(t *testing.T) {
+	b, _, _ := startTestPlatform(t)
+	qos, retain, clean := PropertyPostQoS, RetainKinds, CleanSession
+	PropertyPostQoS, RetainKinds, CleanSession = 0, "property", false
+	t.Cleanup(func() { PropertyPostQoS, RetainKinds, CleanSession = qos, retain, clean })
+
+	const name = "retain-dev"
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go runDeviceWithStop(name, &wg, stop)
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	postTopic := getTopic(name, PropertyPostTopicTemplate)
+	waitFor(t, "retained property post", func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		_, ok := b.retained[postTopic]
+		return ok
+	})
+	d, _ := lookupDevice(name)
+	if o := d.Client.PublishOptions(device.KindPropertyPost); o.QoS != 0 || !o.Retain {
+		t.Errorf("property post options = %+v", o)
+	}
+	if _, err := os.Stat(filepath.Join(StateDir, "mqtt_store", name)); err != nil {
+		t.Errorf("persistent session store: %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"qsiot_server/onenet/push"
+	"qsiot_server/onenet/topics"
+)
+
+// ======================================================================
+// 北向数据推送接收 (端到端送达核对)
+//
+// -push-listen 时启动 HTTP 服务接收平台的数据推送 (OneNET HTTP 推送格式，见 onenet/push):
+//   GET  /?msg=&nonce=&signature=   URL 验证，校验签名后原样返回 msg
+//   POST /                          推送消息，校验签名，配置 -push-aes-key 时解密 enc_msg
+//   GET  /report                    当前核对结果 (JSON)
+//
+// 模拟器成功发布的属性上报与事件上报按 (设备, property|event, id) 登记，与推送消息逐条核对:
+// 超过 -push-late 才收到的计为迟到，超过 -push-missing 仍未收到的计为缺失 (之后收到则改计迟到)。
+// 核对结果每 -push-report 写入日志，退出时输出最终结果。
+// ======================================================================
+
+// 推送接收配置 (可通过命令行参数覆盖)
+var (
+	PushListen         = ""               // 监听地址，为空不启动
+	PushToken          = ""               // 推送配置中的 token (校验签名)
+	PushAESKey         = ""               // 推送配置中的 AES Key (43 个字符)，为空表示明文推送
+	PushLateAfter      = 10 * time.Second // 发布后超过该时长才收到推送即为迟到
+	PushMissingAfter   = time.Minute      // 发布后超过该时长仍未收到推送即为缺失
+	PushReportInterval = time.Minute      // 核对结果写入日志的间隔
+)
+
+// deliveries 推送核对 (nil 表示未开启)，由 startPushReceiver 创建
+var deliveries *deliveryTracker
+
+// deliveryKey 一条可推送的上行消息
+type deliveryKey struct {
+	device, notifyType, id string
+}
+
+func (k deliveryKey) String() string {
+	return fmt.Sprintf("%s %s id=%s", k.device, k.notifyType, k.id)
+}
+
+// DeliveryReport 推送核对结果
+type DeliveryReport struct {
+	Published  int64   `json:"published"`  // 已发布的属性/事件上报
+	Delivered  int64   `json:"delivered"`  // 已收到推送 (含迟到)
+	Late       int64   `json:"late"`       // 迟到
+	Missing    int64   `json:"missing"`    // 超时仍未收到
+	Pending    int     `json:"pending"`    // 尚在等待推送
+	Unexpected int64   `json:"unexpected"` // 收到未登记 (或重复) 的推送
+	MaxLatency float64 `json:"max_latency_ms"`
+}
+
+func (r DeliveryReport) String() string {
+	return fmt.Sprintf("已发布 %d，已送达 %d，迟到 %d，缺失 %d，待确认 %d，多余 %d，最大时延 %.0fms",
+		r.Published, r.Delivered, r.Late, r.Missing, r.Pending, r.Unexpected, r.MaxLatency)
+}
+
+// deliveryTracker 登记已发布的上行消息并与推送核对
+type deliveryTracker struct {
+	mu      sync.Mutex
+	pending map[deliveryKey]time.Time // 等待推送 (发布时间)
+	missing map[deliveryKey]time.Time // 已判定缺失 (发布时间)
+	report  DeliveryReport
+}
+
+func newDeliveryTracker() *deliveryTracker {
+	return &deliveryTracker{pending: make(map[deliveryKey]time.Time), missing: make(map[deliveryKey]time.Time)}
+}
+
+// pushKey 返回上行消息对应的推送核对键，只有属性上报与事件上报会被推送
+func pushKey(deviceName, topic string, payload []byte) (deliveryKey, bool) {
+	_, _, suffix, ok := topics.ParseDevice(topic)
+	if !ok {
+		return deliveryKey{}, false
+	}
+	var notifyType string
+	switch suffix {
+	case topics.PropertyPost:
+		notifyType = push.NotifyProperty
+	case topics.EventPost:
+		notifyType = push.NotifyEvent
+	default:
+		return deliveryKey{}, false
+	}
+	var req struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(payload, &req) != nil || req.ID == "" {
+		return deliveryKey{}, false
+	}
+	return deliveryKey{deviceName, notifyType, req.ID}, true
+}
+
+// published 在发出前登记一条上行消息 (推送可能先于 PUBACK 到达)
+func (t *deliveryTracker) published(deviceName, topic string, payload []byte, now time.Time) {
+	key, ok := pushKey(deviceName, topic, payload)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending[key] = now
+	t.report.Published++
+}
+
+// unpublished 撤销发布失败的消息的登记
+func (t *deliveryTracker) unpublished(deviceName, topic string, payload []byte) {
+	key, ok := pushKey(deviceName, topic, payload)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.pending[key]; ok {
+		delete(t.pending, key)
+		t.report.Published--
+	}
+}
+
+// delivered 核对一条推送消息
+func (t *deliveryTracker) delivered(n push.Notification, now time.Time) {
+	key := deliveryKey{n.DeviceName, n.NotifyType, n.Data.ID}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	at, ok := t.pending[key]
+	if ok {
+		delete(t.pending, key)
+	} else if at, ok = t.missing[key]; ok {
+		delete(t.missing, key)
+		t.report.Missing--
+	} else {
+		t.report.Unexpected++
+		log.Printf("[推送] ❓ 收到未登记的推送: %s", key)
+		return
+	}
+
+	latency := now.Sub(at)
+	t.report.Delivered++
+	if ms := float64(latency) / float64(time.Millisecond); ms > t.report.MaxLatency {
+		t.report.MaxLatency = ms
+	}
+	if latency > PushLateAfter {
+		t.report.Late++
+		log.Printf("[推送] ⏰ 推送迟到 %v: %s", latency.Round(time.Millisecond), key)
+	}
+}
+
+// expire 将等待超过 PushMissingAfter 的消息判定为缺失
+func (t *deliveryTracker) expire(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, at := range t.pending {
+		if now.Sub(at) > PushMissingAfter {
+			delete(t.pending, key)
+			t.missing[key] = at
+			t.report.Missing++
+			log.Printf("[推送] ❌ %v 内未收到推送: %s", PushMissingAfter, key)
+		}
+	}
+}
+
+// Report 返回核对结果
+func (t *deliveryTracker) Report() DeliveryReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := t.report
+	r.Pending = len(t.pending)
+	return r
+}
+
+// pushReceiver 推送接收的 HTTP 处理
+type pushReceiver struct {
+	token, aesKey string
+	tracker       *deliveryTracker
+}
+
+func (p *pushReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		msg, err := push.VerifyURL(p.token, r.URL.Query())
+		if err != nil {
+			log.Printf("[推送] ⛔ URL 验证失败: %v", err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		io.WriteString(w, msg)
+	case http.MethodPost:
+		var e push.Envelope
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		msg, err := e.Open(p.token, p.aesKey)
+		if err != nil {
+			log.Printf("[推送] ⛔ 拒绝推送 %s: %v", e.ID, err)
+			status := http.StatusBadRequest
+			if errors.Is(err, push.ErrSignatureMismatch) {
+				status = http.StatusForbidden
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		var n push.Notification
+		if err := json.Unmarshal(msg, &n); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if n.NotifyType == push.NotifyProperty || n.NotifyType == push.NotifyEvent {
+			p.tracker.delivered(n, time.Now())
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// startPushReceiver 启动推送接收与定期核对，返回的 stop 关闭服务并输出最终核对结果
+func startPushReceiver(addr string) (stop func(), err error) {
+	if PushReportInterval <= 0 {
+		return nil, fmt.Errorf("-push-report must be positive")
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	tracker := newDeliveryTracker()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /report", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, tracker.Report())
+	})
+	mux.Handle("/", &pushReceiver{token: PushToken, aesKey: PushAESKey, tracker: tracker})
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("推送接收服务异常退出: %v", err)
+		}
+	}()
+	deliveries = tracker
+	log.Printf("📬 推送接收: http://%s/", ln.Addr())
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(PushReportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				tracker.expire(now)
+				log.Printf("[推送] 📬 核对: %v", tracker.Report())
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+		tracker.expire(time.Now())
+		log.Printf("[推送] 📬 最终核对: %v", tracker.Report())
+	}, nil
+}
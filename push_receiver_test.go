@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"qsiot_server/onenet/push"
+	"qsiot_server/onenet/topics"
+)
+
+func TestDeliveryTracker(t *testing.T) {
+	late, missing := PushLateAfter, PushMissingAfter
+	PushLateAfter, PushMissingAfter = time.Second, 5*time.Second
+	t.Cleanup(func() { PushLateAfter, PushMissingAfter = late, missing })
+
+	tr := newDeliveryTracker()
+	t0 := time.Now()
+	post := func(id string) []byte { return []byte(`{"id":"` + id + `","version":"1.0","params":{}}`) }
+	tr.published("dev1", topics.Device("pid", "dev1", topics.PropertyPost), post("1"), t0)
+	tr.published("dev1", topics.Device("pid", "dev1", topics.PropertyPost), post("2"), t0)
+	tr.published("dev1", topics.Device("pid", "dev1", topics.EventPost), post("3"), t0)
+	tr.published("dev1", topics.Device("pid", "dev1", topics.PropertySetReply), post("4"), t0) // 回复不会被推送
+
+	notify := func(notifyType, id string) push.Notification {
+		var n push.Notification
+		n.NotifyType, n.DeviceName, n.Data.ID = notifyType, "dev1", id
+		return n
+	}
+	tr.delivered(notify(push.NotifyProperty, "1"), t0.Add(100*time.Millisecond))
+	tr.delivered(notify(push.NotifyEvent, "3"), t0.Add(2*time.Second)) // 迟到
+	tr.delivered(notify(push.NotifyEvent, "9"), t0.Add(2*time.Second)) // 未登记
+	tr.expire(t0.Add(6 * time.Second))                                 // id=2 缺失
+
+	want := DeliveryReport{Published: 3, Delivered: 2, Late: 1, Missing: 1, Unexpected: 1, MaxLatency: 2000}
+	if got := tr.Report(); got != want {
+		t.Errorf("report = %+v, want %+v", got, want)
+	}
+
+	// 判定缺失后又收到: 改计为迟到
+	tr.delivered(notify(push.NotifyProperty, "2"), t0.Add(7*time.Second))
+	if got := tr.Report(); got.Missing != 0 || got.Late != 2 || got.Delivered != 3 {
+		t.Errorf("after late delivery: %+v", got)
+	}
+}
+
+func TestPushDeliveryEndToEnd(t *testing.T) {
+	_, standIn, _ := startTestPlatform(t)
+	const token = "push-token"
+	aesKey := strings.TrimSuffix("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=", "=")
+
+	tracker := newDeliveryTracker()
+	srv := httptest.NewServer(&pushReceiver{token: token, aesKey: aesKey, tracker: tracker})
+	defer srv.Close()
+	deliveries = tracker
+	t.Cleanup(func() { deliveries = nil })
+	standIn.EnablePush(srv.URL, token, aesKey)
+
+	// URL 验证: 签名正确时原样返回 msg
+	q := url.Values{"msg": {"verify-me"}, "nonce": {"n1"}, "signature": {push.Sign(token, "n1", "verify-me")}}
+	resp, err := http.Get(srv.URL + "/?" + q.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("url verification status = %s", resp.Status)
+	}
+
+	const name = "push-dev"
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go runDeviceWithStop(name, &wg, stop)
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	waitFor(t, "property post pushed", func() bool {
+		r := tracker.Report()
+		return r.Published > 0 && r.Delivered == r.Published
+	})
+	d, _ := lookupDevice(name)
+	d.postDeviceEvent(EventAlarm)
+	waitFor(t, "event pushed", func() bool {
+		r := tracker.Report()
+		return r.Published >= 2 && r.Delivered == r.Published
+	})
+	if r := tracker.Report(); r.Unexpected != 0 || r.Missing != 0 {
+		t.Errorf("report = %+v", r)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"qsiot_server/onenet/device"
+)
+
+// ======================================================================
+// 上行限流 (遵守平台的单设备/产品消息配额)
+//
+// 每台设备与全体设备合计各有一个令牌桶 (速率 + 突发容量)，所有上行消息 (含回复、
+// 校时请求与透传) 发布前须从两个桶各取得一个令牌。令牌不足时按 -rate-policy 处理:
+//   queue  排队，令牌可用后在后台发出 (每台设备最多排队 -rate-queue 条，超出即丢弃；排队期间设备停止或断线的消息放弃发送)
+//   drop   立即丢弃，发布返回 errRateLimited
+// 排队与丢弃的消息数计入设备统计 (throttled / rate_dropped)。
+// ======================================================================
+
+// 限流配置 (可通过命令行参数覆盖)
+var (
+	RateLimit       = 0.0     // 每台设备每秒上行消息数，0 表示不限制
+	RateBurst       = 5       // 每台设备的突发容量
+	GlobalRateLimit = 0.0     // 全体设备合计每秒上行消息数，0 表示不限制
+	GlobalRateBurst = 20      // 全体设备的突发容量
+	RatePolicy      = "queue" // 令牌不足时: queue | drop
+	RateQueueSize   = 100     // queue 策略下每台设备最多排队的消息数
+)
+
+// errRateLimited 上行消息因限流被丢弃
+var errRateLimited = errors.New("rate limited")
+
+// globalLimiter 全体设备共用的令牌桶 (nil 表示不限制)，由 configureRateLimits 创建
+var globalLimiter *tokenBucket
+
+// checkRateLimits 校验限流配置
+func checkRateLimits() error {
+	if RateLimit < 0 || GlobalRateLimit < 0 {
+		return fmt.Errorf("rate limits must not be negative")
+	}
+	if RatePolicy != "queue" && RatePolicy != "drop" {
+		return fmt.Errorf("unknown rate policy %q (want queue or drop)", RatePolicy)
+	}
+	if RateQueueSize < 0 {
+		return fmt.Errorf("-rate-queue must not be negative")
+	}
+	return nil
+}
+
+// configureRateLimits 按当前配置创建全局令牌桶 (每台设备的令牌桶在创建设备时生成)
+func configureRateLimits() {
+	globalLimiter = newTokenBucket(GlobalRateLimit, GlobalRateBurst)
+}
+
+// tokenBucket 令牌桶: 以 rate 个/秒补充令牌，最多积累 burst 个
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64 // 可为负数，表示已预约的未来令牌
+	last   time.Time
+}
+
+// newTokenBucket 创建装满的令牌桶，rate <= 0 时返回 nil (不限制)
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// take 取走一个令牌，返回令牌可用前需等待的时长。reserve 为 false 且当前无令牌时
+// 不取走令牌并返回 ok=false；为 true 时预约下一个令牌
+func (b *tokenBucket) take(now time.Time, reserve bool) (wait time.Duration, ok bool) {
+	if b == nil {
+		return 0, true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	if !reserve {
+		return 0, false
+	}
+	b.tokens--
+	return time.Duration(-b.tokens / b.rate * float64(time.Second)), true
+}
+
+// refund 归还一个已取走的令牌
+func (b *tokenBucket) refund() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.tokens = math.Min(b.burst, b.tokens+1)
+	b.mu.Unlock()
+}
+
+// deviceLimiter 单台设备的令牌桶与排队计数
+type deviceLimiter struct {
+	bucket *tokenBucket
+
+	mu     sync.Mutex
+	queued int
+}
+
+func newDeviceLimiter() *deviceLimiter {
+	return &deviceLimiter{bucket: newTokenBucket(RateLimit, RateBurst)}
+}
+
+// enqueue 占用一个排队位置，队列已满时返回 false
+func (l *deviceLimiter) enqueue() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.queued >= RateQueueSize {
+		return false
+	}
+	l.queued++
+	return true
+}
+
+func (l *deviceLimiter) dequeue() {
+	l.mu.Lock()
+	l.queued--
+	l.mu.Unlock()
+}
+
+// rateLimit 发布中间件: 从设备与全局令牌桶各取一个令牌后发布，令牌不足时按 RatePolicy 排队或丢弃
+func (d *Device) rateLimit(next device.PublishFunc) device.PublishFunc {
+	return func(kind device.Kind, topic string, payload []byte) error {
+		l, global := d.limiter, globalLimiter
+		if l == nil || (l.bucket == nil && global == nil) {
+			return next(kind, topic, payload)
+		}
+
+		now := time.Now()
+		if RatePolicy == "drop" {
+			if _, ok := l.bucket.take(now, false); !ok {
+				return d.rateDropped(kind, topic)
+			}
+			if _, ok := global.take(now, false); !ok {
+				l.bucket.refund()
+				return d.rateDropped(kind, topic)
+			}
+			return next(kind, topic, payload)
+		}
+
+		// queue: 预约令牌，需要等待时在后台按序发出
+		w1, _ := l.bucket.take(now, true)
+		w2, _ := global.take(now, true)
+		wait := max(w1, w2)
+		if wait == 0 {
+			return next(kind, topic, payload)
+		}
+		if !l.enqueue() {
+			l.bucket.refund()
+			global.refund()
+			return d.rateDropped(kind, topic)
+		}
+		d.Stats.noteThrottled()
+		log.Printf("[%s] 🚦 上行限流 (%s)，%v 后发送", d.Name, kind, wait.Round(time.Millisecond))
+		state, gen := d.connEpoch()
+		go func() {
+			defer l.dequeue()
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-d.done:
+				l.bucket.refund()
+				global.refund()
+				d.abandonQueued(kind, "设备已停止")
+				return
+			}
+			// 排队期间连接断开或已重连: 不在其他连接上补发
+			if s, g := d.connEpoch(); s != state || g != gen {
+				l.bucket.refund()
+				global.refund()
+				d.abandonQueued(kind, "连接已断开")
+				return
+			}
+			if err := next(kind, topic, payload); err != nil {
+				log.Printf("[%s] 限流排队的消息发布失败 %s: %v", d.Name, topic, err)
+			}
+		}()
+		return nil
+	}
+}
+
+// abandonQueued 记录一条排队期间设备停止或断线而放弃的消息 (计入 rate_dropped，预约的令牌由调用方归还)
+func (d *Device) abandonQueued(kind device.Kind, reason string) {
+	d.Stats.noteRateDropped()
+	log.Printf("[%s] 🚦 %s，放弃限流排队的 %s 消息", d.Name, reason, kind)
+}
+
+// rateDropped 记录一条因限流丢弃的消息并返回 errRateLimited
+func (d *Device) rateDropped(kind device.Kind, topic string) error {
+	d.Stats.noteRateDropped()
+	log.Printf("[%s] 🚦 上行限流，丢弃 %s 消息", d.Name, kind)
+	return fmt.Errorf("publish %s: %w", topic, errRateLimited)
+}
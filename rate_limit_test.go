@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"qsiot_server/onenet/device"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(2, 2) // 每秒 2 个，突发 2 个
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if wait, ok := b.take(now, false); !ok || wait != 0 {
+			t.Fatalf("burst token %d: wait=%v ok=%v", i, wait, ok)
+		}
+	}
+	if _, ok := b.take(now, false); ok {
+		t.Fatal("bucket should be empty")
+	}
+	if wait, _ := b.take(now, true); wait != 500*time.Millisecond {
+		t.Errorf("reserved wait = %v, want 500ms", wait)
+	}
+	if wait, _ := b.take(now, true); wait != time.Second {
+		t.Errorf("second reserved wait = %v, want 1s", wait)
+	}
+	// 1.5s 后补充 3 个令牌，抵消两次预约后剩 1 个
+	if _, ok := b.take(now.Add(1500*time.Millisecond), false); !ok {
+		t.Error("token should be available after refill")
+	}
+	if _, ok := b.take(now.Add(1500*time.Millisecond), false); ok {
+		t.Error("refill must not exceed reservations")
+	}
+
+	if wait, ok := (*tokenBucket)(nil).take(now, false); !ok || wait != 0 {
+		t.Error("nil bucket must not limit")
+	}
+}
+
+// useRateLimits 以 rate/burst 为单台设备限流，全局不限制
+func useRateLimits(t *testing.T, policy string, queue int) {
+	t.Helper()
+	rate, burst, p, q, global := RateLimit, RateBurst, RatePolicy, RateQueueSize, globalLimiter
+	RateLimit, RateBurst, RatePolicy, RateQueueSize, globalLimiter = 20, 2, policy, queue, nil
+	t.Cleanup(func() { RateLimit, RateBurst, RatePolicy, RateQueueSize, globalLimiter = rate, burst, p, q, global })
+}
+
+func TestRateLimitDrop(t *testing.T) {
+	useRateLimits(t, "drop", 0)
+	d := &Device{Name: "rl-drop", limiter: newDeviceLimiter()}
+	var sent int
+	publish := d.rateLimit(func(device.Kind, string, []byte) error { sent++; return nil })
+
+	var dropped int
+	for i := 0; i < 5; i++ {
+		if err := publish(device.KindPropertyPost, "t", nil); errors.Is(err, errRateLimited) {
+			dropped++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sent != 2 || dropped != 3 {
+		t.Errorf("sent/dropped = %d/%d, want 2/3", sent, dropped)
+	}
+	if st := d.Stats.Snapshot(); st.RateDropped != 3 || st.Throttled != 0 {
+		t.Errorf("stats throttled/dropped = %d/%d", st.Throttled, st.RateDropped)
+	}
+}
+
+func TestRateLimitQueue(t *testing.T) {
+	useRateLimits(t, "queue", 2)
+	d := &Device{Name: "rl-queue", limiter: newDeviceLimiter()}
+	var mu sync.Mutex
+	var order []string
+	done := make(chan struct{}, 5)
+	publish := d.rateLimit(func(_ device.Kind, topic string, _ []byte) error {
+		mu.Lock()
+		order = append(order, topic)
+		mu.Unlock()
+		done <- struct{}{}
+		return nil
+	})
+
+	// 突发 2 条立即发出，2 条排队，队列已满时第 5 条被丢弃
+	for _, topic := range []string{"a", "b", "c", "d", "e"} {
+		err := publish(device.KindEventPost, topic, nil)
+		if topic == "e" {
+			if !errors.Is(err, errRateLimited) {
+				t.Errorf("publish e: err = %v, want rate limited", err)
+			}
+		} else if err != nil {
+			t.Fatalf("publish %s: %v", topic, err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("queued messages were not sent")
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if got := len(order); got != 4 || order[2] != "c" || order[3] != "d" {
+		t.Errorf("send order = %v, want [a b c d]", order)
+	}
+	if st := d.Stats.Snapshot(); st.Throttled != 2 || st.RateDropped != 1 {
+		t.Errorf("stats throttled/dropped = %d/%d, want 2/1", st.Throttled, st.RateDropped)
+	}
+}
+
+func TestRateLimitQueueAbandoned(t *testing.T) {
+	useRateLimits(t, "queue", 10)
+	RateLimit = 5 // 排队的消息约 200ms 后发出
+	d := &Device{Name: "rl-abandon", limiter: newDeviceLimiter(), state: StateOnline, done: make(chan struct{})}
+	var mu sync.Mutex
+	sent := 0
+	publish := d.rateLimit(func(device.Kind, string, []byte) error {
+		mu.Lock()
+		sent++
+		mu.Unlock()
+		return nil
+	})
+	burst := func() {
+		for i := 0; i < 3; i++ { // 2 条立即发出，1 条排队
+			if err := publish(device.KindPropertyPost, "t", nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// 排队期间断线 (随后重连): 不在新连接上补发
+	burst()
+	d.setState(StateOffline, 0, nil)
+	d.setState(StateOnline, 0, nil)
+	waitFor(t, "abandoned after reconnect", func() bool { return d.Stats.Snapshot().RateDropped == 1 })
+
+	// 排队期间设备停止
+	time.Sleep(500 * time.Millisecond) // 令牌恢复
+	burst()
+	d.setState(StateStopped, 0, nil)
+	waitFor(t, "abandoned after stop", func() bool { return d.Stats.Snapshot().RateDropped == 2 })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if sent != 4 {
+		t.Errorf("sent %d messages, want 4 (queued ones abandoned)", sent)
+	}
+}
+
+// 放弃排队的消息时归还预约的设备与全局令牌，重连后不因未发出的消息继续限流
+func TestRateLimitQueueAbandonedRefunds(t *testing.T) {
+	useRateLimits(t, "queue", 10)
+	RateLimit, RateBurst = 1, 1
+	globalLimiter = newTokenBucket(1, 1)
+	d := &Device{Name: "rl-refund", limiter: newDeviceLimiter(), state: StateOnline, done: make(chan struct{})}
+	publish := d.rateLimit(func(device.Kind, string, []byte) error { return nil })
+	for i := 0; i < 2; i++ { // 1 条立即发出，1 条预约约 1s 后的令牌
+		if err := publish(device.KindPropertyPost, "t", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.setState(StateStopped, 0, nil)
+	waitFor(t, "queued message abandoned", func() bool { return d.Stats.Snapshot().RateDropped == 1 })
+
+	for name, b := range map[string]*tokenBucket{"device": d.limiter.bucket, "global": globalLimiter} {
+		b.mu.Lock()
+		tokens := b.tokens
+		b.mu.Unlock()
+		if tokens < -0.5 {
+			t.Errorf("%s bucket tokens = %.2f, reservation not refunded", name, tokens)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"qsiot_server/onenet/device"
+	"qsiot_server/onenet/topics"
+)
+
+// ======================================================================
+// 端到端断言模式 (CI 回归门禁)
+//
+//   run -assert scenario.yaml [其他运行参数]
+//
+// 在进程内启动本地 Broker 与平台模拟，按场景运行设备并逐步执行动作、检查期望，
+// 最后输出报告；全部通过时退出码为 0，任一步失败为 1，场景文件有误为 2。
+//
+//	name: 上报周期与属性校验
+//	devices: [ci-dev]              # 默认一台 scenario-dev
+//	steps:
+//	  - name: 设备上线
+//	    expect: {online: true, within: 10s}
+//	  - name: 设置上报周期后按 3s 上报
+//	    set: {interval: 3}
+//	    expect: {reply_code: 200, report_every: 3s, within: 5s}
+//	  - name: relay=2 被拒绝
+//	    set: {relay: 2}
+//	    expect: {reply_code: 400}
+//
+// 每步至多一个动作 (set / get / event / wait / disconnect / connect)，expect 中的各项都须满足:
+// within (默认 5s) 内满足 online / reported；reply_code / reply_data 检查 set、get 的设备回复；
+// report_every 要求 within 内开始出现 reports (默认 2) 个间隔为 report_every ± tolerance (默认 1s)
+// 的连续属性上报。
+// ======================================================================
+
+// AssertFile 断言场景文件 (run -assert)，为空时正常运行
+var AssertFile = ""
+
+// defaultScenarioDevice 场景未列出设备时使用的设备名
+const defaultScenarioDevice = "scenario-dev"
+
+// defaultScenarioProduct 未指定 -product-id 时场景使用的产品ID
+const defaultScenarioProduct = "scenario"
+
+// Scenario 断言场景
+type Scenario struct {
+	Name    string         `yaml:"name"`
+	Devices []string       `yaml:"devices"`
+	Steps   []ScenarioStep `yaml:"steps"`
+}
+
+// ScenarioStep 场景中的一步: 至多一个动作，以及动作后的期望
+type ScenarioStep struct {
+	Name   string `yaml:"name"`
+	Device string `yaml:"device"` // 默认为第一台设备
+
+	Set         map[string]interface{} `yaml:"set"`          // 平台下发 property/set
+	Get         []string               `yaml:"get"`          // 平台下发 property/get
+	Event       string                 `yaml:"event"`        // 设备上报事件
+	EventParams map[string]interface{} `yaml:"event_params"` // 事件参数，为空时随机生成
+	Wait        time.Duration          `yaml:"wait"`         // 等待
+	Disconnect  bool                   `yaml:"disconnect"`   // 设备手动断开
+	Connect     bool                   `yaml:"connect"`      // 设备恢复连接
+
+	Expect ScenarioExpect `yaml:"expect"`
+}
+
+// ScenarioExpect 一步的期望
+type ScenarioExpect struct {
+	Within      time.Duration          `yaml:"within"`
+	Online      *bool                  `yaml:"online"`       // 平台侧在线状态
+	ReplyCode   *int                   `yaml:"reply_code"`   // set/get 回复的 code
+	ReplyData   map[string]interface{} `yaml:"reply_data"`   // get 回复中的属性值
+	Reported    map[string]interface{} `yaml:"reported"`     // 平台缓存的最近上报值
+	ReportEvery time.Duration          `yaml:"report_every"` // 属性上报间隔
+	Tolerance   time.Duration          `yaml:"tolerance"`
+	Reports     int                    `yaml:"reports"`
+}
+
+// LoadScenario 读取并校验场景文件 (未知字段视为错误)
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseScenario(data)
+}
+
+// ParseScenario 解析并校验场景，补齐默认值
+func ParseScenario(data []byte) (*Scenario, error) {
+	var sc Scenario
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&sc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parse scenario: %w", err)
+	}
+	if len(sc.Devices) == 0 {
+		sc.Devices = []string{defaultScenarioDevice}
+	}
+	if len(sc.Steps) == 0 {
+		return nil, errors.New("scenario has no steps")
+	}
+	for i := range sc.Steps {
+		st := &sc.Steps[i]
+		if st.Name == "" {
+			st.Name = fmt.Sprintf("step %d", i+1)
+		}
+		if st.Device == "" {
+			st.Device = sc.Devices[0]
+		} else if !containsString(sc.Devices, st.Device) {
+			return nil, fmt.Errorf("%s: unknown device %q", st.Name, st.Device)
+		}
+		if n := st.actions(); n > 1 {
+			return nil, fmt.Errorf("%s: at most one action per step, got %d", st.Name, n)
+		}
+		e := &st.Expect
+		if (e.ReplyCode != nil || e.ReplyData != nil) && st.Set == nil && st.Get == nil {
+			return nil, fmt.Errorf("%s: reply_code/reply_data require a set or get action", st.Name)
+		}
+		if e.Within <= 0 {
+			e.Within = 5 * time.Second
+		}
+		if e.Tolerance <= 0 {
+			e.Tolerance = time.Second
+		}
+		if e.Reports <= 0 {
+			e.Reports = 2
+		}
+	}
+	return &sc, nil
+}
+
+func (st *ScenarioStep) actions() int {
+	n := 0
+	for _, set := range []bool{st.Set != nil, st.Get != nil, st.Event != "", st.Wait > 0, st.Disconnect, st.Connect} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// scenarioRun 一次场景运行: 进程内的 Broker、平台模拟与属性上报时间记录
+type scenarioRun struct {
+	standIn *OneNETStandIn
+
+	mu    sync.Mutex
+	posts map[string][]time.Time // 设备 -> property/post 到达平台的时间
+	seq   int
+}
+
+// StepResult 一步的执行结果
+type StepResult struct {
+	Name     string
+	Err      error
+	Duration time.Duration
+}
+
+// runScenarioFile 实现 `run -assert`: 运行场景并输出报告，返回进程退出码
+func runScenarioFile(path string) int {
+	sc, err := LoadScenario(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "scenario %s: %v\n", path, err)
+		return 2
+	}
+	if DeviceTransport != "mqtt" {
+		fmt.Fprintln(os.Stderr, "run -assert requires -transport mqtt")
+		return 2
+	}
+	results, err := runScenario(sc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "scenario %s: %v\n", path, err)
+		return 2
+	}
+	if !writeScenarioReport(os.Stdout, sc, results) {
+		return 1
+	}
+	return 0
+}
+
+// runScenario 在进程内的本地 Broker 与平台模拟上运行场景
+func runScenario(sc *Scenario) ([]StepResult, error) {
+	b := NewLocalBroker()
+	if err := b.Listen("127.0.0.1:0"); err != nil {
+		return nil, fmt.Errorf("start local broker: %w", err)
+	}
+	defer b.Close()
+	r := &scenarioRun{standIn: NewOneNETStandIn(b), posts: make(map[string][]time.Time)}
+	onPublish := b.OnPublish
+	b.OnPublish = func(clientID, topic string, payload []byte) {
+		onPublish(clientID, topic, payload)
+		if _, name, suffix, ok := topics.Parse(topic); ok && suffix == topics.PropertyPost {
+			r.mu.Lock()
+			r.posts[name] = append(r.posts[name], time.Now())
+			r.mu.Unlock()
+		}
+	}
+
+	// 场景从默认状态开始，且不读写设备状态文件
+	BrokerURL, StateDir = b.URL(), ""
+	if ProductID == "" {
+		ProductID = defaultScenarioProduct // 平台模拟不校验产品，但 Topic 中必须有产品ID
+	}
+	log.Printf("==== 断言场景 %s: %d 台设备, %d 步 ====", sc.Name, len(sc.Devices), len(sc.Steps))
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, name := range sc.Devices {
+		wg.Add(1)
+		go runDeviceWithStop(name, &wg, stop)
+	}
+	defer func() {
+		close(stop)
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			log.Printf("⚠️ 等待设备退出超时")
+		}
+	}()
+
+	results := make([]StepResult, 0, len(sc.Steps))
+	for _, st := range sc.Steps {
+		start := time.Now()
+		err := r.runStep(st, start)
+		results = append(results, StepResult{Name: st.Name, Err: err, Duration: time.Since(start)})
+		if err != nil {
+			log.Printf("[场景] ❌ %s: %v", st.Name, err)
+		} else {
+			log.Printf("[场景] ✅ %s", st.Name)
+		}
+	}
+	return results, nil
+}
+
+// runStep 执行一步的动作并检查期望
+func (r *scenarioRun) runStep(st ScenarioStep, start time.Time) error {
+	e := st.Expect
+	var reply *device.Reply
+	switch {
+	case st.Set != nil:
+		rep, err := r.request(st.Device, topics.PropertySet, topics.PropertySetReply, st.Set, e.Within)
+		if err != nil {
+			return err
+		}
+		reply = &rep
+	case st.Get != nil:
+		rep, err := r.request(st.Device, topics.PropertyGet, topics.PropertyGetReply, st.Get, e.Within)
+		if err != nil {
+			return err
+		}
+		reply = &rep
+	case st.Event != "":
+		d, err := r.device(st.Device)
+		if err != nil {
+			return err
+		}
+		if st.EventParams != nil {
+			if err := d.postEvent(st.Event, st.EventParams); err != nil {
+				return fmt.Errorf("post event %s: %w", st.Event, err)
+			}
+		} else {
+			d.postDeviceEvent(st.Event)
+		}
+	case st.Wait > 0:
+		time.Sleep(st.Wait)
+	case st.Disconnect || st.Connect:
+		d, err := r.device(st.Device)
+		if err != nil {
+			return err
+		}
+		if st.Disconnect {
+			d.Disconnect()
+		} else {
+			d.Connect()
+		}
+	}
+
+	if reply != nil {
+		if e.ReplyCode != nil && reply.Code != *e.ReplyCode {
+			return fmt.Errorf("reply code %d (%s), want %d", reply.Code, reply.Msg, *e.ReplyCode)
+		}
+		if e.ReplyData != nil {
+			data, _ := reply.Data.(map[string]interface{})
+			for id, want := range e.ReplyData {
+				if got, ok := data[id]; !ok || !sameValue(got, want) {
+					return fmt.Errorf("reply data %s = %v, want %v", id, got, want)
+				}
+			}
+		}
+	}
+	deadline := start.Add(e.Within)
+	if e.Online != nil {
+		if err := waitUntil(deadline, func() error {
+			ds, _ := r.standIn.Session(st.Device)
+			if ds.Online != *e.Online {
+				return fmt.Errorf("online = %v within %v, want %v", ds.Online, e.Within, *e.Online)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	if e.Reported != nil {
+		if err := waitUntil(deadline, func() error {
+			props := r.standIn.Properties(st.Device)
+			for id, want := range e.Reported {
+				if got, ok := props[id]; !ok || !sameValue(got.Value, want) {
+					return fmt.Errorf("reported %s = %v within %v, want %v", id, got.Value, e.Within, want)
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	if e.ReportEvery > 0 {
+		checkDeadline := deadline.Add(time.Duration(e.Reports) * (e.ReportEvery + e.Tolerance))
+		if err := waitUntil(checkDeadline, func() error { return r.checkReportEvery(st.Device, start, e) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// request 经平台模拟向设备下发请求并等待回复
+func (r *scenarioRun) request(deviceName, suffix, replySuffix string, params interface{}, timeout time.Duration) (device.Reply, error) {
+	r.mu.Lock()
+	r.seq++
+	id := fmt.Sprintf("scenario-%d", r.seq)
+	r.mu.Unlock()
+	reply, ok := r.standIn.Request(ProductID, deviceName, suffix, replySuffix,
+		device.Request{ID: id, Version: device.ProtocolVersion, Params: params}, timeout)
+	if !ok {
+		return reply, fmt.Errorf("no %s within %v", replySuffix, timeout)
+	}
+	return reply, nil
+}
+
+func (r *scenarioRun) device(name string) (*Device, error) {
+	d, ok := lookupDevice(name)
+	if !ok {
+		return nil, fmt.Errorf("device %s is not running", name)
+	}
+	return d, nil
+}
+
+// checkReportEvery 检查 start 后 within 内开始的连续 Reports 个上报间隔是否都在 ReportEvery ± Tolerance 内
+func (r *scenarioRun) checkReportEvery(deviceName string, start time.Time, e ScenarioExpect) error {
+	r.mu.Lock()
+	var posts []time.Time
+	for _, t := range r.posts[deviceName] {
+		if !t.Before(start) {
+			posts = append(posts, t)
+		}
+	}
+	r.mu.Unlock()
+
+	var intervals []string
+	for i := 0; i < len(posts); i++ {
+		if i > 0 {
+			intervals = append(intervals, posts[i].Sub(posts[i-1]).Round(100*time.Millisecond).String())
+		}
+		if posts[i].Sub(start) > e.Within || i+e.Reports >= len(posts) {
+			continue
+		}
+		ok := true
+		for j := i; j < i+e.Reports; j++ {
+			if d := posts[j+1].Sub(posts[j]) - e.ReportEvery; d < -e.Tolerance || d > e.Tolerance {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("no %d consecutive property posts %v apart (±%v) starting within %v; intervals seen: [%s]",
+		e.Reports, e.ReportEvery, e.Tolerance, e.Within, strings.Join(intervals, " "))
+}
+
+// waitUntil 轮询 check 直到通过或超过 deadline，返回最后一次的错误
+func waitUntil(deadline time.Time, check func() error) error {
+	for {
+		err := check()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// sameValue 按 JSON 语义比较两个值 (YAML 的 int 与 JSON 的 float64 视为相等)
+func sameValue(got, want interface{}) bool {
+	norm := func(v interface{}) interface{} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return v
+		}
+		var out interface{}
+		json.Unmarshal(b, &out)
+		return out
+	}
+	return reflect.DeepEqual(norm(got), norm(want))
+}
+
+// writeScenarioReport 输出场景报告，返回是否全部通过
+func writeScenarioReport(w io.Writer, sc *Scenario, results []StepResult) bool {
+	failed := 0
+	fmt.Fprintf(w, "场景: %s\n", sc.Name)
+	for i, res := range results {
+		if res.Err != nil {
+			failed++
+			fmt.Fprintf(w, "  ❌ %d. %s (%.1fs): %v\n", i+1, res.Name, res.Duration.Seconds(), res.Err)
+		} else {
+			fmt.Fprintf(w, "  ✅ %d. %s (%.1fs)\n", i+1, res.Name, res.Duration.Seconds())
+		}
+	}
+	fmt.Fprintf(w, "结果: %d 通过, %d 失败\n", len(results)-failed, failed)
+	return failed == 0
+}
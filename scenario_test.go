@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScenario(t *testing.T) {
+	sc, err := ParseScenario([]byte(`
+name: defaults
+steps:
+  - set: {relay: 1}
+    expect: {reply_code: 200}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := sc.Steps[0]
+	if sc.Devices[0] != defaultScenarioDevice || st.Device != defaultScenarioDevice || st.Name != "step 1" {
+		t.Errorf("defaults not applied: %+v", sc)
+	}
+	if st.Expect.Within != 5*time.Second || st.Expect.Tolerance != time.Second || st.Expect.Reports != 2 {
+		t.Errorf("expect defaults = %+v", st.Expect)
+	}
+
+	for _, bad := range []string{
+		"steps: []",
+		"steps:\n  - {set: {relay: 1}, wait: 1s}",
+		"steps:\n  - {device: other, wait: 1s}",
+		"steps:\n  - {wait: 1s, expect: {reply_code: 200}}",
+		"steps:\n  - {sleep: 1s}",
+	} {
+		if _, err := ParseScenario([]byte(bad)); err == nil {
+			t.Errorf("ParseScenario(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestRunScenario(t *testing.T) {
+	if testing.Short() {
+		t.Skip("scenario runs real report intervals")
+	}
+	pid, url, stateDir := ProductID, BrokerURL, StateDir
+	ProductID = testProductID
+	t.Cleanup(func() { ProductID, BrokerURL, StateDir = pid, url, stateDir })
+	sc, err := ParseScenario([]byte(`
+name: ci
+devices: [scenario-test-dev]
+steps:
+  - name: online
+    expect: {online: true}
+  - name: interval=1 reports every second
+    set: {interval: 1}
+    expect: {reply_code: 200, report_every: 1s, tolerance: 500ms}
+  - name: back to a long interval
+    set: {interval: 60}
+    expect: {reply_code: 200}
+  - name: relay=2 rejected
+    set: {relay: 2}
+    expect: {reply_code: 400}
+  - name: get relay
+    get: [relay]
+    expect: {reply_code: 200, reply_data: {relay: 0}}
+  - name: wrong expectation
+    set: {relay: 1}
+    expect: {reply_code: 400}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := runScenario(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, res := range results[:5] {
+		if res.Err != nil {
+			t.Errorf("step %d %s: %v", i+1, res.Name, res.Err)
+		}
+	}
+	if results[5].Err == nil {
+		t.Error("wrong expectation passed")
+	}
+
+	var out strings.Builder
+	if writeScenarioReport(&out, sc, results) {
+		t.Error("report passed with a failing step")
+	}
+	if !strings.Contains(out.String(), "5 通过, 1 失败") {
+		t.Errorf("report:\n%s", out.String())
+	}
+}
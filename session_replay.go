@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// ======================================================================
+// MQTT 会话录制与回放
+//
+// 录制: -record <file> 将每个设备收发的全部消息按 JSON Lines 写入文件。
+// 回放: qsiot_server replay -file <file> -mode uplink|downlink
+//   - uplink:   按原始时间间隔把录制的设备上行消息重新发布到 Broker
+//   - downlink: 离线把录制的下行消息喂给 Device，对比 set_reply/get_reply
+//               与录制结果，用于回归测试 handlePropertySet/handlePropertyGet
+// ======================================================================
+
+// 消息方向
+const (
+	DirectionUp   = "up"   // 设备 -> 云端
+	DirectionDown = "down" // 云端 -> 设备
+)
+
+// TrafficRecord 一条录制的 MQTT 消息
+type TrafficRecord struct {
+	Time     time.Time `json:"time"`
+	Device   string    `json:"device"`
+	Dir      string    `json:"dir"`
+	Topic    string    `json:"topic"`
+	QoS      byte      `json:"qos"`
+	Retained bool      `json:"retained,omitempty"`
+	Payload  string    `json:"payload"`
+	Base64   bool      `json:"base64,omitempty"` // 载荷非 UTF-8 文本时以 base64 保存
+}
+
+// Bytes 返回原始载荷
+func (r *TrafficRecord) Bytes() ([]byte, error) {
+	if r.Base64 {
+		return base64.StdEncoding.DecodeString(r.Payload)
+	}
+	return []byte(r.Payload), nil
+}
+
+func newTrafficRecord(device, dir, topic string, qos byte, retained bool, payload []byte) TrafficRecord {
+	rec := TrafficRecord{Time: time.Now(), Device: device, Dir: dir, Topic: topic, QoS: qos, Retained: retained}
+	if utf8.Valid(payload) {
+		rec.Payload = string(payload)
+	} else {
+		rec.Payload = base64.StdEncoding.EncodeToString(payload)
+		rec.Base64 = true
+	}
+	return rec
+}
+
+// SessionRecorder 将消息追加写入 JSON Lines 文件，可被多个设备并发使用
+type SessionRecorder struct {
+	mu  sync.Mutex
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// sessionRecorder 全局录制器 (-record 启用)，为 nil 时不录制
+var sessionRecorder *SessionRecorder
+
+// OpenSessionRecorder 创建 (覆盖) 录制文件
+func OpenSessionRecorder(path string) (*SessionRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	return &SessionRecorder{f: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// Record 写入一条记录并立即刷盘，保证异常退出时录制完整
+func (r *SessionRecorder) Record(rec TrafficRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(rec); err != nil {
+		log.Printf("写入会话录制失败: %v", err)
+		return
+	}
+	r.w.Flush()
+}
+
+// Close 关闭录制文件
+func (r *SessionRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.w.Flush(); err != nil {
+		r.f.Close()
+		return err
+	}
+	return r.f.Close()
+}
+
+// readTrafficRecords 读取录制文件 (按时间排序)
+func readTrafficRecords(path string) ([]TrafficRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var recs []TrafficRecord
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var rec TrafficRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		recs = append(recs, rec)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(recs, func(i, j int) bool { return recs[i].Time.Before(recs[j].Time) })
+	return recs, nil
+}
+
+// recordTraffic 统计并录制设备的一条消息，同时推送给 Web 仪表盘 (未启用录制且无订阅者时只统计)
+func (d *Device) recordTraffic(dir, topic string, qos byte, retained bool, payload []byte) {
+	d.Stats.countTraffic(dir)
+	if sessionRecorder == nil && !trafficFeed.active() {
+		return
+	}
+	rec := newTrafficRecord(d.Name, dir, topic, qos, retained, payload)
+	if sessionRecorder != nil {
+		sessionRecorder.Record(rec)
+	}
+	trafficFeed.publish(rec)
+}
+
+// payloadBytes 将 Publish 接受的载荷类型统一转换为字节
+func payloadBytes(payload interface{}) []byte {
+	switch p := payload.(type) {
+	case []byte:
+		return p
+	case string:
+		return []byte(p)
+	default:
+		return []byte(fmt.Sprint(p))
+	}
+}
+
+// ======================================================================
+// 离线 Client: 在没有 Broker 的情况下驱动 Device
+// ======================================================================
+
+// offlineClient 实现 mqtt.Client，记录发布的消息并按订阅分发注入的下行消息
+type offlineClient struct {
+	mu        sync.Mutex
+	published []TrafficRecord
+	handlers  map[string]mqtt.MessageHandler
+}
+
+func newOfflineClient() *offlineClient {
+	return &offlineClient{handlers: make(map[string]mqtt.MessageHandler)}
+}
+
+func (c *offlineClient) IsConnected() bool      { return true }
+func (c *offlineClient) IsConnectionOpen() bool { return true }
+func (c *offlineClient) Connect() mqtt.Token    { return &mqtt.DummyToken{} }
+func (c *offlineClient) Disconnect(uint)        {}
+
+func (c *offlineClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.published = append(c.published, newTrafficRecord("", DirectionUp, topic, qos, retained, payloadBytes(payload)))
+	return &mqtt.DummyToken{}
+}
+
+func (c *offlineClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[topic] = callback
+	return &mqtt.DummyToken{}
+}
+
+func (c *offlineClient) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	for topic, qos := range filters {
+		c.Subscribe(topic, qos, callback)
+	}
+	return &mqtt.DummyToken{}
+}
+
+func (c *offlineClient) Unsubscribe(topics ...string) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range topics {
+		delete(c.handlers, t)
+	}
+	return &mqtt.DummyToken{}
+}
+
+func (c *offlineClient) AddRoute(topic string, callback mqtt.MessageHandler) {
+	c.Subscribe(topic, 0, callback)
+}
+
+func (c *offlineClient) OptionsReader() mqtt.ClientOptionsReader {
+	return mqtt.NewClient(mqtt.NewClientOptions()).OptionsReader()
+}
+
+// deliver 将一条下行消息分发给订阅了该 Topic 的处理函数，返回是否有人订阅
+func (c *offlineClient) deliver(topic string, qos byte, payload []byte) bool {
+	c.mu.Lock()
+	h := c.handlers[topic]
+	c.mu.Unlock()
+	if h == nil {
+		return false
+	}
+	h(c, &offlineMessage{topic: topic, qos: qos, payload: payload})
+	return true
+}
+
+// takePublished 取出并清空已发布的消息
+func (c *offlineClient) takePublished() []TrafficRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := c.published
+	c.published = nil
+	return out
+}
+
+// offlineMessage 实现 mqtt.Message
+type offlineMessage struct {
+	topic   string
+	qos     byte
+	payload []byte
+}
+
+func (m *offlineMessage) Duplicate() bool   { return false }
+func (m *offlineMessage) Qos() byte         { return m.qos }
+func (m *offlineMessage) Retained() bool    { return false }
+func (m *offlineMessage) Topic() string     { return m.topic }
+func (m *offlineMessage) MessageID() uint16 { return 0 }
+func (m *offlineMessage) Payload() []byte   { return m.payload }
+func (m *offlineMessage) Ack()              {}
+
+// ======================================================================
+// replay 子命令
+// ======================================================================
+
+// runReplayCommand 解析 replay 子命令参数并执行回放，返回进程退出码
+func runReplayCommand(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	file := fs.String("file", "session.jsonl", "录制文件 (JSON Lines)")
+	mode := fs.String("mode", "downlink", "回放模式: uplink (上行重放到 Broker) | downlink (离线下行回归)")
+	device := fs.String("device", "", "只回放指定设备，为空回放全部")
+	speed := fs.Float64("speed", 1.0, "uplink 模式的回放速度倍数")
+	fs.StringVar(&BrokerURL, "broker", BrokerURL, "uplink 模式连接的 Broker URL")
+	fs.StringVar(&ProductID, "product-id", ProductID, "录制设备所属的产品ID")
+	fs.StringVar(&AccessKey, "access-key", AccessKey, "uplink 模式签发设备 Token 使用的产品 Access Key")
+	fs.Parse(args)
+
+	recs, err := readTrafficRecords(*file)
+	if err != nil {
+		log.Printf("读取录制文件失败: %v", err)
+		return 1
+	}
+	if *device != "" {
+		var filtered []TrafficRecord
+		for _, r := range recs {
+			if r.Device == *device {
+				filtered = append(filtered, r)
+			}
+		}
+		recs = filtered
+	}
+	log.Printf("==== 回放 %s: %d 条消息, 模式 %s ====", *file, len(recs), *mode)
+
+	switch *mode {
+	case "uplink":
+		return replayUplink(recs, *speed)
+	case "downlink":
+		return replayDownlink(recs)
+	}
+	log.Printf("未知回放模式: %s", *mode)
+	return 2
+}
+
+// replayUplink 以录制时的设备身份连接 Broker，按原始时间间隔重新发布上行消息
+func replayUplink(recs []TrafficRecord, speed float64) int {
+	if speed <= 0 {
+		speed = 1
+	}
+	clients := make(map[string]mqtt.Client)
+	defer func() {
+		for _, c := range clients {
+			c.Disconnect(250)
+		}
+	}()
+
+	var start time.Time
+	replayStart := time.Now()
+	sent := 0
+	for _, rec := range recs {
+		if rec.Dir != DirectionUp {
+			continue
+		}
+		client, ok := clients[rec.Device]
+		if !ok {
+			opts, err := getConnectOptions(rec.Device)
+			if err != nil {
+				log.Printf("[%s] 连接配置错误: %v", rec.Device, err)
+				return 1
+			}
+			client = mqtt.NewClient(opts)
+			if token := client.Connect(); token.Wait() && token.Error() != nil {
+				log.Printf("[%s] 连接 Broker 失败: %v", rec.Device, token.Error())
+				return 1
+			}
+			clients[rec.Device] = client
+		}
+		if start.IsZero() {
+			start = rec.Time
+		}
+		due := replayStart.Add(time.Duration(float64(rec.Time.Sub(start)) / speed))
+		time.Sleep(time.Until(due))
+
+		payload, err := rec.Bytes()
+		if err != nil {
+			log.Printf("[%s] 载荷解码失败: %v", rec.Device, err)
+			return 1
+		}
+		if token := client.Publish(rec.Topic, rec.QoS, rec.Retained, payload); token.Wait() && token.Error() != nil {
+			log.Printf("[%s] 回放发布失败 %s: %v", rec.Device, rec.Topic, token.Error())
+			return 1
+		}
+		sent++
+		log.Printf("[%s] ⏩ 回放 %s (%d 字节)", rec.Device, rec.Topic, len(payload))
+	}
+	log.Printf("==== 上行回放完成: %d 条 ====", sent)
+	return 0
+}
+
+// replyKey 用于匹配请求与回复: 设备 + 回复 Topic + 消息 ID
+type replyKey struct {
+	device, topic, id string
+}
+
+// replayDownlink 离线回放下行消息并对比设备回复
+func replayDownlink(recs []TrafficRecord) int {
+	// 录制中的设备回复 (set_reply/get_reply)，按消息 ID 索引
+	expected := make(map[replyKey]TrafficRecord)
+	for _, rec := range recs {
+		if rec.Dir == DirectionUp {
+			if id := payloadMessageID([]byte(rec.Payload)); id != "" {
+				expected[replyKey{rec.Device, rec.Topic, id}] = rec
+			}
+		}
+	}
+
+	devices := make(map[string]*Device)
+	clients := make(map[string]*offlineClient)
+	checked, failed := 0, 0
+
+	for _, rec := range recs {
+		if rec.Dir != DirectionDown {
+			continue
+		}
+		dev, ok := devices[rec.Device]
+		if !ok {
+			// 离线回放从默认状态开始，且不读写设备状态文件
+			dev = newDeviceState(rec.Device, false)
+			client := newOfflineClient()
+			dev.attachClient(client)
+			if err := dev.subscribeForCommands(); err != nil {
+				log.Printf("[%s] 订阅失败: %v", rec.Device, err)
+				return 1
+			}
+			devices[rec.Device], clients[rec.Device] = dev, client
+		}
+		client := clients[rec.Device]
+
+		payload, err := rec.Bytes()
+		if err != nil {
+			log.Printf("[%s] 载荷解码失败: %v", rec.Device, err)
+			failed++
+			continue
+		}
+		if !client.deliver(rec.Topic, rec.QoS, payload) {
+			log.Printf("[%s] 跳过未订阅的下行 Topic: %s", rec.Device, rec.Topic)
+			continue
+		}
+
+		var replyTopic string
+		switch rec.Topic {
+		case getTopic(rec.Device, PropertySetTopicTemplate):
+			replyTopic = getTopic(rec.Device, PropertySetReplyTopicTemplate)
+		case getTopic(rec.Device, PropertyGetTopicTemplate):
+			replyTopic = getTopic(rec.Device, PropertyGetReplyTopicTemplate)
+		default:
+			client.takePublished()
+			continue
+		}
+
+		id := payloadMessageID(payload)
+		var actual *TrafficRecord
+		for _, pub := range client.takePublished() {
+			if pub.Topic == replyTopic && payloadMessageID([]byte(pub.Payload)) == id {
+				pub := pub
+				actual = &pub
+				break
+			}
+		}
+		want, hasWant := expected[replyKey{rec.Device, replyTopic, id}]
+
+		checked++
+		switch {
+		case actual == nil:
+			failed++
+			log.Printf("[%s] ❌ ID %s: 设备未回复 %s", rec.Device, id, replyTopic)
+		case !hasWant:
+			log.Printf("[%s] ⚠️ ID %s: 录制中没有对应回复，实际: %s", rec.Device, id, actual.Payload)
+		default:
+			if diff := compareReplies([]byte(want.Payload), []byte(actual.Payload)); diff != "" {
+				failed++
+				log.Printf("[%s] ❌ ID %s: 回复不一致: %s\n  录制: %s\n  实际: %s", rec.Device, id, diff, want.Payload, actual.Payload)
+			} else {
+				log.Printf("[%s] ✅ ID %s: 回复一致", rec.Device, id)
+			}
+		}
+	}
+
+	log.Printf("==== 下行回放完成: 校验 %d 条回复, 不一致 %d 条 ====", checked, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// payloadMessageID 提取 OneNET 载荷中的 id 字段
+func payloadMessageID(payload []byte) string {
+	var m struct {
+		ID interface{} `json:"id"`
+	}
+	if json.Unmarshal(payload, &m) != nil || m.ID == nil {
+		return ""
+	}
+	return fmt.Sprint(m.ID)
+}
+
+// compareReplies 比较两条回复: code/msg 必须一致，data 的属性标识符集合必须一致
+// (属性取值含随机模拟数据，不做比较)
+func compareReplies(want, got []byte) string {
+	var w, g struct {
+		Code interface{}            `json:"code"`
+		Msg  interface{}            `json:"msg"`
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(want, &w); err != nil {
+		return fmt.Sprintf("录制回复无法解析: %v", err)
+	}
+	if err := json.Unmarshal(got, &g); err != nil {
+		return fmt.Sprintf("实际回复无法解析: %v", err)
+	}
+	if fmt.Sprint(w.Code) != fmt.Sprint(g.Code) {
+		return fmt.Sprintf("code %v != %v", w.Code, g.Code)
+	}
+	if fmt.Sprint(w.Msg) != fmt.Sprint(g.Msg) {
+		return fmt.Sprintf("msg %v != %v", w.Msg, g.Msg)
+	}
+	wantKeys, gotKeys := sortedKeys(w.Data), sortedKeys(g.Data)
+	if strings.Join(wantKeys, ",") != strings.Join(gotKeys, ",") {
+		return fmt.Sprintf("data 标识符 [%s] != [%s]", strings.Join(wantKeys, ","), strings.Join(gotKeys, ","))
+	}
+	return ""
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"qsiot_server/onenet/topics"
+)
+
+// 下行回放不读写状态文件，也不改动全局 StateDir
+func TestReplayDownlinkKeepsStateDir(t *testing.T) {
+	useTestConfig(t)
+	StateDir = t.TempDir()
+	const name = "replay-dev"
+	setTopic := getTopic(name, PropertySetTopicTemplate)
+	replyTopic := getTopic(name, PropertySetReplyTopicTemplate)
+	recs := []TrafficRecord{
+		newTrafficRecord(name, DirectionDown, setTopic, 1, false, []byte(`{"id":"1","version":"1.0","params":{"relay":1}}`)),
+		newTrafficRecord(name, DirectionUp, replyTopic, 1, false, []byte(`{"id":"1","code":200,"msg":"success"}`)),
+	}
+	dir := StateDir
+	if code := replayDownlink(recs); code != 0 {
+		t.Fatalf("replayDownlink = %d", code)
+	}
+	if StateDir != dir {
+		t.Errorf("StateDir = %q after replay, want %q", StateDir, dir)
+	}
+	if _, err := os.Stat(stateFilePath(name)); !os.IsNotExist(err) {
+		t.Errorf("replay wrote a state file: %v", err)
+	}
+}
+
+// 上行回放使用 replay 子命令自身的 -broker/-product-id/-access-key 接入 (平台模拟校验 Token)
+func TestReplayUplinkFlags(t *testing.T) {
+	b, standIn, _ := startTestPlatform(t)
+	key := AccessKey
+	standIn.EnableRegistration(key)
+	BrokerURL, ProductID, AccessKey = "tcp://127.0.0.1:1", "wrong-pid", "CwsLCwsLCwsLCwsLCwsLCw=="
+
+	const name = "replay-up-dev"
+	postTopic := topics.Device(testProductID, name, topics.PropertyPost)
+	var lines []byte
+	for i := 1; i <= 2; i++ {
+		rec := newTrafficRecord(name, DirectionUp, postTopic, 1, false, []byte(`{"id":"`+strconv.Itoa(i)+`","version":"1.0","params":{}}`))
+		line, _ := json.Marshal(rec)
+		lines = append(append(lines, line...), '\n')
+	}
+	file := filepath.Join(t.TempDir(), "session.jsonl")
+	if err := os.WriteFile(file, lines, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"-mode", "uplink", "-file", file, "-speed", "100",
+		"-broker", b.URL(), "-product-id", testProductID, "-access-key", key}
+	if code := runReplayCommand(args); code != 0 {
+		t.Fatalf("runReplayCommand = %d", code)
+	}
+	waitFor(t, "replayed messages reach the platform", func() bool {
+		s, ok := standIn.Session(name)
+		return ok && s.Messages == 2
+	})
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// ======================================================================
+// 交互式 shell (qsiot_server shell)
+//
+// 设备照常运行，同时在终端读取命令驱动设备，便于调试时手动触发上报/事件、
+// 修改属性或模拟断线。终端下支持 Tab 补全 (命令、设备名、物模型标识符)。
+// ======================================================================
+
+// shellCommand 一条 shell 命令
+type shellCommand struct {
+	usage string
+	help  string
+	run   func(w io.Writer, args []string) error
+}
+
+var shellCommands map[string]shellCommand
+
+func init() {
+	shellCommands = map[string]shellCommand{
+		"help":       {"help", "显示帮助", shellHelp},
+		"devices":    {"devices", "列出所有设备及连接状态", shellDevices},
+		"show":       {"show <dev>", "显示设备属性、状态与时钟", shellShow},
+		"set":        {"set <dev> <prop> <value>", "在本地设置属性并立即上报 (value 为 JSON 或字符串)", shellSet},
+		"event":      {"event <dev> <event> [param=value ...]", "按物模型上报事件", shellEvent},
+		"post":       {"post <dev> [full|dynamic]", "立即上报属性 (默认 dynamic)", shellPost},
+		"sync":       {"sync <dev>", "立即发送时间同步请求", shellSync},
+		"disconnect": {"disconnect <dev>", "手动断开设备连接 (不自动重连)", shellDisconnect},
+		"connect":    {"connect <dev>", "恢复被手动断开的设备连接", shellConnect},
+		"format":     {"format <dev> <direct|wrapped|batch>", "切换设备的事件上报格式", shellFormat},
+		"quit":       {"quit", "退出 (同 exit)", nil},
+		"exit":       {"exit", "退出", nil},
+	}
+}
+
+// runShell 运行交互式命令循环，用户退出 (quit/exit/Ctrl-D) 时关闭 done
+func runShell(done chan<- struct{}) {
+	defer close(done)
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		runShellLines(os.Stdin, os.Stdout)
+		return
+	}
+
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		log.Printf("终端切换为 raw 模式失败: %v", err)
+		runShellLines(os.Stdin, os.Stdout)
+		return
+	}
+	defer term.Restore(fd, oldState)
+
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "qsiot> ")
+	t.AutoCompleteCallback = shellComplete
+
+	// 日志经由 Terminal 输出，避免打乱正在输入的命令行
+	log.SetOutput(t)
+	defer log.SetOutput(os.Stderr)
+
+	fmt.Fprintln(t, "输入 help 查看命令，Tab 补全，Ctrl-D 退出")
+	for {
+		line, err := t.ReadLine()
+		if err != nil {
+			return
+		}
+		if execShellLine(t, line) {
+			return
+		}
+	}
+}
+
+// runShellLines 非终端输入 (管道/脚本) 时逐行执行命令
+func runShellLines(r io.Reader, w io.Writer) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if execShellLine(w, sc.Text()) {
+			return
+		}
+	}
+}
+
+// execShellLine 执行一行命令，返回 true 表示退出
+func execShellLine(w io.Writer, line string) bool {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return false
+	}
+	cmd, ok := shellCommands[args[0]]
+	if !ok {
+		fmt.Fprintf(w, "未知命令: %s (输入 help 查看帮助)\n", args[0])
+		return false
+	}
+	if cmd.run == nil {
+		return true
+	}
+	if err := cmd.run(w, args[1:]); err != nil {
+		fmt.Fprintf(w, "错误: %v\n", err)
+	}
+	return false
+}
+
+func shellHelp(w io.Writer, args []string) error {
+	names := make([]string, 0, len(shellCommands))
+	for name := range shellCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := shellCommands[name]
+		fmt.Fprintf(w, "  %-40s %s\n", c.usage, c.help)
+	}
+	return nil
+}
+
+// shellDevice 解析命令的设备参数
+func shellDevice(args []string, n int, usage string) (*Device, error) {
+	if len(args) < n {
+		return nil, fmt.Errorf("usage: %s", usage)
+	}
+	d, ok := lookupDevice(args[0])
+	if !ok {
+		return nil, fmt.Errorf("unknown device: %s", args[0])
+	}
+	return d, nil
+}
+
+func shellDevices(w io.Writer, args []string) error {
+	for _, d := range registeredDevices() {
+		fmt.Fprintf(w, "  %-20s %-11s interval=%ds format=%s\n", d.Name, d.State(), d.propertySnapshot().Interval, d.eventFormat())
+	}
+	return nil
+}
+
+func shellShow(w io.Writer, args []string) error {
+	d, err := shellDevice(args, 1, shellCommands["show"].usage)
+	if err != nil {
+		return err
+	}
+	offset, lastSync := d.Clock.Offset()
+	fmt.Fprintf(w, "设备:     %s\n", d.Name)
+	fmt.Fprintf(w, "状态:     %s\n", d.State())
+	fmt.Fprintf(w, "事件格式: %s\n", d.eventFormat())
+	fmt.Fprintf(w, "设备时钟: %s (累计修正 %v", d.now().Format(time.RFC3339Nano), offset)
+	if !lastSync.IsZero() {
+		fmt.Fprintf(w, ", 最近校时 %s", lastSync.Format(time.RFC3339))
+	}
+	fmt.Fprintln(w, ")")
+	fmt.Fprintln(w, "属性:")
+	for _, id := range thingPropertyIDs {
+		v, _ := d.getProperty(id)
+		b, _ := json.Marshal(v)
+		mode := "r "
+		if p, ok := thingModelSpec.Property(id); ok && p.Writable() {
+			mode = "rw"
+		}
+		fmt.Fprintf(w, "  [%s] %-18s %s\n", mode, id, b)
+	}
+	return nil
+}
+
+// parseShellValue 将命令行参数解析为 JSON 取值，无法解析时按字符串处理
+func parseShellValue(s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err == nil {
+		return v
+	}
+	return s
+}
+
+func shellSet(w io.Writer, args []string) error {
+	usage := shellCommands["set"].usage
+	d, err := shellDevice(args, 3, usage)
+	if err != nil {
+		return err
+	}
+	if len(args) != 3 {
+		return fmt.Errorf("usage: %s", usage)
+	}
+	if err := d.applyProperties(map[string]interface{}{args[1]: parseShellValue(args[2])}); err != nil {
+		return err
+	}
+	if d.State() == StateOnline && !ThingModelOff {
+		d.postDeviceProperty(false)
+	}
+	return nil
+}
+
+func shellEvent(w io.Writer, args []string) error {
+	d, err := shellDevice(args, 2, shellCommands["event"].usage)
+	if err != nil {
+		return err
+	}
+	params := make(map[string]interface{})
+	for _, kv := range args[2:] {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("event param %q: want param=value", kv)
+		}
+		params[k] = parseShellValue(v)
+	}
+	return d.postEvent(args[1], params)
+}
+
+func shellPost(w io.Writer, args []string) error {
+	usage := shellCommands["post"].usage
+	d, err := shellDevice(args, 1, usage)
+	if err != nil {
+		return err
+	}
+	full := false
+	if len(args) > 1 {
+		switch args[1] {
+		case "full":
+			full = true
+		case "dynamic":
+		default:
+			return fmt.Errorf("usage: %s", usage)
+		}
+	}
+	d.postDeviceProperty(full)
+	return nil
+}
+
+func shellSync(w io.Writer, args []string) error {
+	d, err := shellDevice(args, 1, shellCommands["sync"].usage)
+	if err != nil {
+		return err
+	}
+	d.requestTimeSync()
+	return nil
+}
+
+func shellDisconnect(w io.Writer, args []string) error {
+	d, err := shellDevice(args, 1, shellCommands["disconnect"].usage)
+	if err != nil {
+		return err
+	}
+	d.Disconnect()
+	return nil
+}
+
+func shellConnect(w io.Writer, args []string) error {
+	d, err := shellDevice(args, 1, shellCommands["connect"].usage)
+	if err != nil {
+		return err
+	}
+	d.Connect()
+	return nil
+}
+
+func shellFormat(w io.Writer, args []string) error {
+	d, err := shellDevice(args, 2, shellCommands["format"].usage)
+	if err != nil {
+		return err
+	}
+	f, err := parseEventFormat(args[1])
+	if err != nil {
+		return err
+	}
+	d.setEventFormat(f)
+	fmt.Fprintf(w, "%s 事件格式已切换为 %s\n", d.Name, f)
+	return nil
+}
+
+// ======================================================================
+// Tab 补全
+// ======================================================================
+
+// shellCandidates 返回第 idx 个参数 (0 为命令名) 的候选补全
+func shellCandidates(args []string, idx int) []string {
+	if idx == 0 {
+		names := make([]string, 0, len(shellCommands))
+		for name := range shellCommands {
+			names = append(names, name)
+		}
+		return names
+	}
+	cmd := args[0]
+	if idx == 1 {
+		if c, ok := shellCommands[cmd]; ok && strings.Contains(c.usage, "<dev>") {
+			var names []string
+			for _, d := range registeredDevices() {
+				names = append(names, d.Name)
+			}
+			return names
+		}
+		return nil
+	}
+
+	switch cmd {
+	case "set":
+		if idx == 2 {
+			return writablePropertyIDs
+		}
+	case "event":
+		if idx == 2 {
+			var ids []string
+			for _, e := range thingModelSpec.Events {
+				ids = append(ids, e.Identifier)
+			}
+			return ids
+		}
+		if e, ok := thingModelSpec.Event(args[2]); ok {
+			var params []string
+			for _, f := range e.OutputData {
+				params = append(params, f.Identifier+"=")
+			}
+			return params
+		}
+	case "post":
+		if idx == 2 {
+			return []string{"full", "dynamic"}
+		}
+	case "format":
+		if idx == 2 {
+			return []string{"direct", "wrapped", "batch"}
+		}
+	}
+	return nil
+}
+
+// shellComplete 实现 term.Terminal.AutoCompleteCallback: 补全光标前的最后一个词
+func shellComplete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	head := line[:pos]
+	args := strings.Fields(head)
+	prefix := ""
+	if len(args) > 0 && !strings.HasSuffix(head, " ") {
+		prefix = args[len(args)-1]
+	} else {
+		args = append(args, "")
+	}
+	idx := len(args) - 1
+
+	var matches []string
+	for _, c := range shellCandidates(args, idx) {
+		if strings.HasPrefix(c, prefix) {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+
+	completion := commonPrefix(matches)
+	if len(matches) == 1 && !strings.HasSuffix(completion, "=") {
+		completion += " "
+	}
+	if completion == prefix {
+		return "", 0, false
+	}
+	newHead := head[:len(head)-len(prefix)] + completion
+	return newHead + line[pos:], len(newHead), true
+}
+
+func commonPrefix(items []string) string {
+	p := items[0]
+	for _, s := range items[1:] {
+		for !strings.HasPrefix(s, p) {
+			p = p[:len(p)-1]
+		}
+	}
+	return p
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	_ "embed"
+
+	"qsiot_server/onenet/thingmodel"
+)
+
+//go:embed thingmodel.json
+var thingModelJSON []byte
+
+// thingModelSpec 运行时使用的物模型定义 (与 thingmodel_gen.go 来自同一份 JSON)，
+// 解码、校验与上报编码见 onenet/thingmodel
+var thingModelSpec = thingmodel.MustParse(thingModelJSON)
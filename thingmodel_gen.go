@@ -0,0 +1,335 @@
+// Code generated by tmgen from thingmodel.json; DO NOT EDIT.
+
+package main
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// ThingModelProductID 物模型所属产品 ID (Topic 模板中的占位产品)
+const ThingModelProductID = "5S34OM4Rc6"
+
+// 属性标识符
+const (
+	PropOneNETLBS     = "$OneNET_LBS"      // 基站定位
+	PropOneNETLBSWIFI = "$OneNET_LBS_WIFI" // WiFi定位
+	PropOUT           = "OUT"              // OUT_J9输出控制
+	PropCellInfo      = "cell_info"        // 获取小区基站信息
+	PropCsq           = "csq"              // 信号质量
+	PropImsi          = "imsi"             // sim卡号
+	PropInterval      = "interval"         // 上报周期
+	PropMacs          = "macs"             // 获取MAC地址
+	PropRelay         = "relay"            // 控制继电器开关
+	PropTemperature   = "temperature"      // 温度
+)
+
+// 事件标识符
+const (
+	EventAlarm = "alarm" // 预警事件
+)
+
+// ======================================================================
+// Topic 模板定义
+// ======================================================================
+const (
+	// 🚀 发布 (设备 -> 云端)
+	PropertyPostTopicTemplate     = "$sys/5S34OM4Rc6/{device-name}/thing/property/post"      // 发布: 直连设备上报属性
+	EventPostTopicTemplate        = "$sys/5S34OM4Rc6/{device-name}/thing/event/post"         // 发布: 直连设备上报事件
+	PropertySetReplyTopicTemplate = "$sys/5S34OM4Rc6/{device-name}/thing/property/set_reply" // 发布: 直连设备属性设置响应
+	PropertyGetReplyTopicTemplate = "$sys/5S34OM4Rc6/{device-name}/thing/property/get_reply" // 发布: 直连设备回复平台获取设备属性
+	PackPostTopicTemplate         = "$sys/5S34OM4Rc6/{device-name}/thing/pack/post"          // 发布: 直连设备或子设备批量上报属性或事件
+
+	// ⬇️ 订阅 (云端 -> 设备)
+	PropertyPostReplyTopicTemplate = "$sys/5S34OM4Rc6/{device-name}/thing/property/post/reply" // 订阅: 直连设备上报属性响应
+	EventPostReplyTopicTemplate    = "$sys/5S34OM4Rc6/{device-name}/thing/event/post/reply"    // 订阅: 直连设备上报事件响应
+	PackPostReplyTopicTemplate     = "$sys/5S34OM4Rc6/{device-name}/thing/pack/post/reply"     // 订阅: 平台回复"设备批量上报属性或事件"
+	PropertySetTopicTemplate       = "$sys/5S34OM4Rc6/{device-name}/thing/property/set"        // 订阅: 设置直连设备属性
+	PropertyGetTopicTemplate       = "$sys/5S34OM4Rc6/{device-name}/thing/property/get"        // 订阅: 平台获取直连设备的属性
+)
+
+// ThingProperties 物模型属性集合 (对应物模型 properties)
+type ThingProperties struct {
+	OneNETLBS     []OneNETLBSItem `json:"$OneNET_LBS"`      // 基站定位 (r)
+	OneNETLBSWIFI OneNETLBSWIFI   `json:"$OneNET_LBS_WIFI"` // WiFi定位 (r)
+	OUT           int32           `json:"OUT"`              // OUT_J9输出控制 (rw)
+	CellInfo      []string        `json:"cell_info"`        // 获取小区基站信息 (r)
+	Csq           int32           `json:"csq"`              // 信号质量 (r)
+	Imsi          string          `json:"imsi"`             // sim卡号 (r)
+	Interval      int32           `json:"interval"`         // 上报周期 (rw)
+	Macs          []string        `json:"macs"`             // 获取MAC地址 (r)
+	Relay         int32           `json:"relay"`            // 控制继电器开关 (rw)
+	Temperature   int32           `json:"temperature"`      // 温度 (r)
+}
+
+// validateOneNETLBS 校验属性 $OneNET_LBS (基站定位)
+func validateOneNETLBS(v []OneNETLBSItem) error {
+	if len(v) != 3 {
+		return fmt.Errorf("$OneNET_LBS: array has %d elements, want 3", len(v))
+	}
+	for _, e0 := range v {
+		if err := e0.validate(); err != nil {
+			return fmt.Errorf("$OneNET_LBS[].%w", err)
+		}
+	}
+	return nil
+}
+
+// validateOneNETLBSWIFI 校验属性 $OneNET_LBS_WIFI (WiFi定位)
+func validateOneNETLBSWIFI(v OneNETLBSWIFI) error {
+	if err := v.validate(); err != nil {
+		return fmt.Errorf("$OneNET_LBS_WIFI.%w", err)
+	}
+	return nil
+}
+
+// validateOUT 校验属性 OUT (OUT_J9输出控制)
+func validateOUT(v int32) error {
+	if v < 0 || v > 1 {
+		return fmt.Errorf("OUT: value %v out of range [0, 1]", v)
+	}
+	return nil
+}
+
+// validateCellInfo 校验属性 cell_info (获取小区基站信息)
+func validateCellInfo(v []string) error {
+	if len(v) != 10 {
+		return fmt.Errorf("cell_info: array has %d elements, want 10", len(v))
+	}
+	for _, e0 := range v {
+		if utf8.RuneCountInString(e0) > 256 {
+			return fmt.Errorf("cell_info[]: text longer than 256")
+		}
+	}
+	return nil
+}
+
+// validateCsq 校验属性 csq (信号质量)
+func validateCsq(v int32) error {
+	if v < 0 || v > 31 {
+		return fmt.Errorf("csq: value %v out of range [0, 31]", v)
+	}
+	return nil
+}
+
+// validateImsi 校验属性 imsi (sim卡号)
+func validateImsi(v string) error {
+	if utf8.RuneCountInString(v) > 50 {
+		return fmt.Errorf("imsi: text longer than 50")
+	}
+	return nil
+}
+
+// validateInterval 校验属性 interval (上报周期)
+func validateInterval(v int32) error {
+	if v < 1 || v > 65535 {
+		return fmt.Errorf("interval: value %v out of range [1, 65535]", v)
+	}
+	return nil
+}
+
+// validateMacs 校验属性 macs (获取MAC地址)
+func validateMacs(v []string) error {
+	if len(v) != 10 {
+		return fmt.Errorf("macs: array has %d elements, want 10", len(v))
+	}
+	for _, e0 := range v {
+		if utf8.RuneCountInString(e0) > 256 {
+			return fmt.Errorf("macs[]: text longer than 256")
+		}
+	}
+	return nil
+}
+
+// validateRelay 校验属性 relay (控制继电器开关)
+func validateRelay(v int32) error {
+	if v < 0 || v > 1 {
+		return fmt.Errorf("relay: value %v out of range [0, 1]", v)
+	}
+	return nil
+}
+
+// validateTemperature 校验属性 temperature (温度)
+func validateTemperature(v int32) error {
+	if v < -55 || v > 125 {
+		return fmt.Errorf("temperature: value %v out of range [-55, 125]", v)
+	}
+	return nil
+}
+
+// thingPropertyIDs 全部属性标识符 (物模型顺序)
+var thingPropertyIDs = []string{
+	PropOneNETLBS,
+	PropOneNETLBSWIFI,
+	PropOUT,
+	PropCellInfo,
+	PropCsq,
+	PropImsi,
+	PropInterval,
+	PropMacs,
+	PropRelay,
+	PropTemperature,
+}
+
+// writablePropertyIDs 可写 (rw) 属性标识符
+var writablePropertyIDs = []string{
+	PropOUT,
+	PropInterval,
+	PropRelay,
+}
+
+// setProperty 按标识符写入属性值，raw 为 property/set 中 JSON 解码后的值
+func (p *ThingProperties) setProperty(id string, raw interface{}) error {
+	switch id {
+	case PropOUT:
+		var v int32
+		if err := thingModelSpec.DecodeProperty(id, raw, &v); err != nil {
+			return err
+		}
+		if err := validateOUT(v); err != nil {
+			return err
+		}
+		p.OUT = v
+	case PropInterval:
+		var v int32
+		if err := thingModelSpec.DecodeProperty(id, raw, &v); err != nil {
+			return err
+		}
+		if err := validateInterval(v); err != nil {
+			return err
+		}
+		p.Interval = v
+	case PropRelay:
+		var v int32
+		if err := thingModelSpec.DecodeProperty(id, raw, &v); err != nil {
+			return err
+		}
+		if err := validateRelay(v); err != nil {
+			return err
+		}
+		p.Relay = v
+	case PropOneNETLBS, PropOneNETLBSWIFI, PropCellInfo, PropCsq, PropImsi, PropMacs, PropTemperature:
+		return fmt.Errorf("property %s is read-only", id)
+	default:
+		return fmt.Errorf("unknown property: %s", id)
+	}
+	return nil
+}
+
+// getProperty 按标识符读取属性值
+func (p *ThingProperties) getProperty(id string) (interface{}, bool) {
+	switch id {
+	case PropOneNETLBS:
+		return p.OneNETLBS, true
+	case PropOneNETLBSWIFI:
+		return p.OneNETLBSWIFI, true
+	case PropOUT:
+		return p.OUT, true
+	case PropCellInfo:
+		return p.CellInfo, true
+	case PropCsq:
+		return p.Csq, true
+	case PropImsi:
+		return p.Imsi, true
+	case PropInterval:
+		return p.Interval, true
+	case PropMacs:
+		return p.Macs, true
+	case PropRelay:
+		return p.Relay, true
+	case PropTemperature:
+		return p.Temperature, true
+	}
+	return nil, false
+}
+
+// params 返回事件 alarm 的参数表 (标识符 -> 值)
+func (e AlarmEvent) params() map[string]interface{} {
+	return map[string]interface{}{
+		"powerOff":    e.PowerOff,
+		"overcurrent": e.Overcurrent,
+		"smoke":       e.Smoke,
+		"IN1":         e.IN1,
+		"IN2":         e.IN2,
+	}
+}
+
+// OneNETLBSItem 物模型结构体类型
+type OneNETLBSItem struct {
+	Mnc          int32 `json:"mnc"`          // 移动网号
+	Mcc          int32 `json:"mcc"`          // 移动国家号码
+	Lac          int32 `json:"lac"`          // 地区区域码
+	Cid          int32 `json:"cid"`          // 基站码
+	NetworkType  int32 `json:"networkType"`  // 网络制式
+	Ss           int32 `json:"ss"`           // 信号强度
+	SignalLength int32 `json:"signalLength"` // 当前基站广播信号强度
+	Ta           int32 `json:"ta"`           // 移动台距以确定其发往基站的定时超前量
+	Flag         int32 `json:"flag"`         // 基站信息数字进制
+}
+
+// validate 按物模型 specs 校验 OneNETLBSItem 的各字段
+func (v OneNETLBSItem) validate() error {
+	return nil
+}
+
+// OneNETLBSWIFI 物模型结构体类型
+type OneNETLBSWIFI struct {
+	Imsi     string `json:"imsi"`     // 移动用户识别码
+	Serverip string `json:"serverip"` // 设备接入基站时对应的网关ip
+	Macs     string `json:"macs"`     // 可以接收到的热点mac信息
+	Mmac     string `json:"mmac"`     // 已连热点mac信息
+	Smac     string `json:"smac"`     // 手机mac码
+	Idfa     string `json:"idfa"`     // IOS手机的idfa
+}
+
+// validate 按物模型 specs 校验 OneNETLBSWIFI 的各字段
+func (v OneNETLBSWIFI) validate() error {
+	if utf8.RuneCountInString(v.Imsi) > 255 {
+		return fmt.Errorf("imsi: text longer than 255")
+	}
+	if utf8.RuneCountInString(v.Serverip) > 255 {
+		return fmt.Errorf("serverip: text longer than 255")
+	}
+	if utf8.RuneCountInString(v.Macs) > 255 {
+		return fmt.Errorf("macs: text longer than 255")
+	}
+	if utf8.RuneCountInString(v.Mmac) > 255 {
+		return fmt.Errorf("mmac: text longer than 255")
+	}
+	if utf8.RuneCountInString(v.Smac) > 255 {
+		return fmt.Errorf("smac: text longer than 255")
+	}
+	if utf8.RuneCountInString(v.Idfa) > 255 {
+		return fmt.Errorf("idfa: text longer than 255")
+	}
+	return nil
+}
+
+// AlarmEvent 事件 alarm (预警事件) 的输出参数
+type AlarmEvent struct {
+	PowerOff    int32 `json:"powerOff"`    // 断电
+	Overcurrent int32 `json:"overcurrent"` // 过流
+	Smoke       int32 `json:"smoke"`       // 烟雾报警
+	IN1         int32 `json:"IN1"`         // IN1检测IO
+	IN2         int32 `json:"IN2"`         // IN2检测IO
+}
+
+// validate 按物模型 specs 校验 AlarmEvent 的各字段
+func (v AlarmEvent) validate() error {
+	if v.PowerOff < 0 || v.PowerOff > 1 {
+		return fmt.Errorf("powerOff: value %v out of range [0, 1]", v.PowerOff)
+	}
+	if v.Overcurrent < 0 || v.Overcurrent > 1 {
+		return fmt.Errorf("overcurrent: value %v out of range [0, 1]", v.Overcurrent)
+	}
+	if v.Smoke < 0 || v.Smoke > 1 {
+		return fmt.Errorf("smoke: value %v out of range [0, 1]", v.Smoke)
+	}
+	if v.IN1 < 0 || v.IN1 > 1 {
+		return fmt.Errorf("IN1: value %v out of range [0, 1]", v.IN1)
+	}
+	if v.IN2 < 0 || v.IN2 > 1 {
+		return fmt.Errorf("IN2: value %v out of range [0, 1]", v.IN2)
+	}
+	return nil
+}
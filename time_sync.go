@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"qsiot_server/onenet/device"
+	"qsiot_server/onenet/topics"
+)
+
+// ======================================================================
+// 设备时钟与 NTP 时间同步
+//
+// 模组通过平台的时间同步请求/响应 Topic 校时 (类 NTP 四时间戳算法):
+//   T1 = deviceSendTime  设备发送请求时的本地时间
+//   T2 = serverRecvTime  平台收到请求的时间
+//   T3 = serverSendTime  平台发送响应的时间
+//   T4 = 设备收到响应时的本地时间
+//   offset = ((T2 - T1) + (T3 - T4)) / 2
+//   rtt    = (T4 - T1) - (T3 - T2)
+// ======================================================================
+
+const (
+	NtpRequestTopicTemplate  = topics.Prefix + topics.NtpRequest  // 发布: 设备请求时间同步
+	NtpResponseTopicTemplate = topics.Prefix + topics.NtpResponse // 订阅: 平台返回时间同步结果
+)
+
+// 时钟模拟配置 (可通过命令行参数覆盖)
+var (
+	ClockDriftPPM    = 0.0              // 模拟晶振漂移，单位 ppm (正数表示走快)
+	ClockInitialSkew = time.Duration(0) // 模拟上电时的初始偏差
+	TimeSyncInterval = 1 * time.Hour    // 周期校时间隔，0 表示仅在连接成功时校时一次
+)
+
+// DeviceClock 模拟设备本地时钟：宿主机时间 + 初始偏差 + 漂移，校时后叠加偏移量
+type DeviceClock struct {
+	mu       sync.Mutex
+	base     time.Time     // 时钟创建时的宿主机时间
+	skew     time.Duration // 初始偏差
+	driftPPM float64       // 漂移 (ppm)
+	offset   time.Duration // 校时得到的累计修正量
+	lastSync time.Time     // 最近一次成功校时的 (已校正) 时间
+}
+
+// NewDeviceClock 创建带初始偏差和漂移的设备时钟
+func NewDeviceClock(skew time.Duration, driftPPM float64) *DeviceClock {
+	return &DeviceClock{base: time.Now(), skew: skew, driftPPM: driftPPM}
+}
+
+// raw 返回未经校时修正的本地时间
+func (c *DeviceClock) raw() time.Time {
+	elapsed := time.Since(c.base)
+	drift := time.Duration(float64(elapsed) * c.driftPPM / 1e6)
+	return c.base.Add(elapsed + c.skew + drift)
+}
+
+// Now 返回设备当前时间 (所有上报时间戳均以此为准)
+func (c *DeviceClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.raw().Add(c.offset)
+}
+
+// Offset 返回当前累计修正量与最近一次校时时间
+func (c *DeviceClock) Offset() (time.Duration, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offset, c.lastSync
+}
+
+// adjust 叠加一次校时得到的偏移量
+func (c *DeviceClock) adjust(delta time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offset += delta
+	c.lastSync = c.raw().Add(c.offset)
+}
+
+// ntpResponse 平台时间同步响应 (时间戳单位: 毫秒，兼容字符串与数字)
+type ntpResponse struct {
+	DeviceSendTime json.Number `json:"deviceSendTime"`
+	ServerRecvTime json.Number `json:"serverRecvTime"`
+	ServerSendTime json.Number `json:"serverSendTime"`
+}
+
+// computeClockOffset 按四时间戳计算时钟偏移与往返时延
+func computeClockOffset(t1, t2, t3, t4 int64) (offset, rtt time.Duration) {
+	offset = time.Duration(((t2-t1)+(t3-t4))/2) * time.Millisecond
+	rtt = time.Duration((t4-t1)-(t3-t2)) * time.Millisecond
+	return offset, rtt
+}
+
+// requestTimeSync 发布时间同步请求
+// 🚀 发布到: $sys/5S34OM4Rc6/{device-name}/thing/ntp/request
+func (d *Device) requestTimeSync() {
+	topic := getTopic(d.Name, NtpRequestTopicTemplate)
+	payload := fmt.Sprintf(`{"deviceSendTime":"%d"}`, d.now().UnixMilli())
+
+	if err := d.Client.Publish(device.KindNtpRequest, topic, []byte(payload)); err != nil {
+		log.Printf("[%s] ⏱️ 时间同步请求失败: %v", d.Name, err)
+	} else {
+		log.Printf("[%s] ⏱️ 已发送时间同步请求", d.Name)
+	}
+}
+
+// handleNtpResponse 处理平台的时间同步响应，修正设备时钟
+// ⬇️ 订阅: $sys/5S34OM4Rc6/{device-name}/thing/ntp/response
+func (d *Device) handleNtpResponse(payload []byte) {
+	t4 := d.now().UnixMilli()
+
+	var resp ntpResponse
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		log.Printf("[%s] 解析时间同步响应失败: %v", d.Name, err)
+		return
+	}
+	t1, err1 := resp.DeviceSendTime.Int64()
+	t2, err2 := resp.ServerRecvTime.Int64()
+	t3, err3 := resp.ServerSendTime.Int64()
+	if err1 != nil || err2 != nil || err3 != nil {
+		log.Printf("[%s] 时间同步响应缺少时间戳: %s", d.Name, payload)
+		return
+	}
+
+	offset, rtt := computeClockOffset(t1, t2, t3, t4)
+	d.Clock.adjust(offset)
+	total, _ := d.Clock.Offset()
+	log.Printf("[%s] ⏱️ 时间同步完成: 本次修正 %v, 往返时延 %v, 累计修正 %v", d.Name, offset, rtt, total)
+}
+
+// now 返回设备时钟当前时间
+func (d *Device) now() time.Time {
+	return d.Clock.Now()
+}
+
+// newMessageID 以设备时钟的毫秒时间戳生成消息 ID (保证单调递增)
+func (d *Device) newMessageID() string {
+	return fmt.Sprintf("%d", d.nextMessageID(d.now().UnixMilli()))
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// ======================================================================
+// 终端仪表盘 (-tui)
+//
+// 定时重绘整屏: 每台设备的连接状态、最近上报时间、最近回复 code、
+// OUT/relay/interval、上下行速率与累计条数，以及最近错误和日志尾部。
+// 仪表盘运行期间日志不直接输出到终端，而是保留在内存中显示在表格下方。
+// ======================================================================
+
+// 仪表盘配置 (可通过命令行参数覆盖)
+var (
+	TUIRefresh    = 1 * time.Second
+	TUIRateWindow = 10 * time.Second // 速率统计窗口
+)
+
+// ANSI 控制序列
+const (
+	ansiClear      = "\033[H\033[2J"
+	ansiHideCursor = "\033[?25l"
+	ansiShowCursor = "\033[?25h"
+	ansiReset      = "\033[0m"
+	ansiBold       = "\033[1m"
+	ansiRed        = "\033[31m"
+	ansiGreen      = "\033[32m"
+	ansiYellow     = "\033[33m"
+	ansiGray       = "\033[90m"
+)
+
+// logTail 保存最近若干行日志的 io.Writer
+type logTail struct {
+	mu      sync.Mutex
+	max     int
+	lines   []string
+	partial string
+}
+
+func newLogTail(max int) *logTail {
+	return &logTail{max: max}
+}
+
+func (l *logTail) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	parts := strings.Split(l.partial+string(p), "\n")
+	l.partial = parts[len(parts)-1]
+	l.lines = append(l.lines, parts[:len(parts)-1]...)
+	if len(l.lines) > l.max {
+		l.lines = l.lines[len(l.lines)-l.max:]
+	}
+	return len(p), nil
+}
+
+// Last 返回最近 n 行
+func (l *logTail) Last(n int) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n > len(l.lines) {
+		n = len(l.lines)
+	}
+	return append([]string(nil), l.lines[len(l.lines)-n:]...)
+}
+
+// rateSample 某一时刻的累计收发条数
+type rateSample struct {
+	t        time.Time
+	up, down int64
+}
+
+// tuiDashboard 终端仪表盘
+type tuiDashboard struct {
+	out     io.Writer
+	logs    *logTail
+	history map[string][]rateSample
+}
+
+// startDashboard 启动终端仪表盘，返回的 stop 会停止重绘并恢复终端与日志输出
+func startDashboard(logFile io.Writer) (stop func()) {
+	db := &tuiDashboard{
+		out:     os.Stdout,
+		logs:    newLogTail(200),
+		history: make(map[string][]rateSample),
+	}
+	if logFile != nil {
+		log.SetOutput(io.MultiWriter(db.logs, logFile))
+	} else {
+		log.SetOutput(db.logs)
+	}
+	fmt.Fprint(db.out, ansiHideCursor)
+
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(TUIRefresh)
+		defer ticker.Stop()
+		for {
+			db.render(time.Now())
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(quit)
+			<-done
+			fmt.Fprint(db.out, ansiShowCursor)
+			log.SetOutput(os.Stderr)
+		})
+	}
+}
+
+// rates 记录当前样本并返回窗口内的上下行速率 (条/秒)
+func (db *tuiDashboard) rates(name string, now time.Time, up, down int64) (float64, float64) {
+	h := append(db.history[name], rateSample{now, up, down})
+	for len(h) > 1 && now.Sub(h[0].t) > TUIRateWindow {
+		h = h[1:]
+	}
+	db.history[name] = h
+	span := now.Sub(h[0].t).Seconds()
+	if span <= 0 {
+		return 0, 0
+	}
+	return float64(up-h[0].up) / span, float64(down-h[0].down) / span
+}
+
+func stateColor(s ConnState) string {
+	switch s {
+	case StateOnline:
+		return ansiGreen
+	case StateConnecting, StateOffline:
+		return ansiYellow
+	case StateFailed:
+		return ansiRed
+	}
+	return ansiGray
+}
+
+// ago 以 "3s" 形式显示距今时长
+func ago(now, t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return now.Sub(t).Round(time.Second).String()
+}
+
+// displayWidth 估算字符串在终端中的显示宽度 (CJK 等宽字符计 2 列)
+func displayWidth(s string) int {
+	w := 0
+	for _, r := range s {
+		if r >= 0x1100 {
+			w += 2
+		} else {
+			w++
+		}
+	}
+	return w
+}
+
+func padRight(s string, width int) string {
+	if n := width - displayWidth(s); n > 0 {
+		return s + strings.Repeat(" ", n)
+	}
+	return s
+}
+
+func padLeft(s string, width int) string {
+	if n := width - displayWidth(s); n > 0 {
+		return strings.Repeat(" ", n) + s
+	}
+	return s
+}
+
+// clip 按终端宽度截断一行 (按字符计)
+func clip(s string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	return string([]rune(s)[:width])
+}
+
+func (db *tuiDashboard) render(now time.Time) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width, height = 120, 40
+	}
+
+	var b strings.Builder
+	b.WriteString(ansiClear)
+
+	devices := registeredDevices()
+	online := 0
+	for _, d := range devices {
+		if d.State() == StateOnline {
+			online++
+		}
+	}
+	fmt.Fprintf(&b, "%sQSIoT 设备仪表盘%s  %s  设备 %d (在线 %d)  Ctrl+C 退出\n\n",
+		ansiBold, ansiReset, now.Format("2006-01-02 15:04:05"), len(devices), online)
+	header := []struct {
+		title string
+		width int
+	}{{"设备", 18}, {"状态", 11}, {"最近上报", 8}, {"回复", 5}, {"OUT", 4}, {"relay", 5}, {"interval", 8},
+		{"上行/s", 8}, {"下行/s", 8}, {"上行", 7}, {"下行", 7}}
+	b.WriteString(ansiBold)
+	for i, h := range header {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if i >= 7 {
+			b.WriteString(padLeft(h.title, h.width))
+		} else {
+			b.WriteString(padRight(h.title, h.width))
+		}
+	}
+	b.WriteString(ansiReset + "\n")
+
+	type devErr struct {
+		device string
+		err    DeviceError
+	}
+	var errs []devErr
+	for _, d := range devices {
+		st := d.Stats.Snapshot()
+		upRate, downRate := db.rates(d.Name, now, st.Up, st.Down)
+		reply := "-"
+		if st.LastReplyCode != 0 {
+			reply = fmt.Sprint(st.LastReplyCode)
+		}
+		state := d.State()
+		props := d.propertySnapshot()
+		fmt.Fprintf(&b, "%-18s %s%-11s%s %-8s %-5s %-4d %-5d %-8d %8.2f %8.2f %7d %7d\n",
+			d.Name, stateColor(state), state, ansiReset, ago(now, st.LastPost), reply,
+			props.OUT, props.Relay, props.Interval, upRate, downRate, st.Up, st.Down)
+		for _, e := range st.Errors {
+			errs = append(errs, devErr{d.Name, e})
+		}
+	}
+
+	sort.Slice(errs, func(i, j int) bool { return errs[i].err.Time.After(errs[j].err.Time) })
+	if len(errs) > maxRecentErrors {
+		errs = errs[:maxRecentErrors]
+	}
+	fmt.Fprintf(&b, "\n%s最近错误%s\n", ansiBold, ansiReset)
+	if len(errs) == 0 {
+		b.WriteString(ansiGray + "  (无)" + ansiReset + "\n")
+	}
+	for _, e := range errs {
+		line := fmt.Sprintf("  %s [%s] %s", e.err.Time.Format("15:04:05"), e.device, e.err.Msg)
+		b.WriteString(ansiRed + clip(line, width) + ansiReset + "\n")
+	}
+
+	// 剩余行数显示日志尾部
+	used := 6 + len(devices) + max(len(errs), 1)
+	if n := height - used - 1; n > 0 {
+		fmt.Fprintf(&b, "\n%s最近日志%s\n", ansiBold, ansiReset)
+		for _, line := range db.logs.Last(n - 1) {
+			b.WriteString(ansiGray + clip(line, width) + ansiReset + "\n")
+		}
+	}
+	io.WriteString(db.out, b.String())
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ======================================================================
+// Web 仪表盘 (-web 127.0.0.1:8080)
+//
+//	GET  /                                  页面
+//	GET  /api/devices                       设备列表、属性与统计
+//	GET  /api/thingmodel                    可写属性与事件定义 (供页面生成按钮)
+//	POST /api/devices/{name}/properties     {"relay": 1}，与平台 property/set 同一路径
+//	POST /api/devices/{name}/events/{event} {"smoke": 1}，为空时随机生成参数
+//	GET  /ws                                上下行消息与状态迁移的实时推送
+// ======================================================================
+
+// WebAddr Web 仪表盘监听地址，为空时不启用
+var WebAddr = ""
+
+// ======================================================================
+// 消息广播 (recordTraffic 调用)
+// ======================================================================
+
+type trafficBroadcaster struct {
+	mu   sync.Mutex
+	subs map[chan TrafficRecord]struct{}
+}
+
+var trafficFeed = &trafficBroadcaster{subs: make(map[chan TrafficRecord]struct{})}
+
+// SubscribeTraffic 订阅所有设备的上下行消息；订阅者处理过慢时消息会被丢弃。
+// 返回的 cancel 用于取消订阅并关闭通道。
+func SubscribeTraffic(buffer int) (<-chan TrafficRecord, func()) {
+	ch := make(chan TrafficRecord, buffer)
+	trafficFeed.mu.Lock()
+	trafficFeed.subs[ch] = struct{}{}
+	trafficFeed.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			trafficFeed.mu.Lock()
+			delete(trafficFeed.subs, ch)
+			trafficFeed.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// active 是否有订阅者 (没有时不必构造记录)
+func (b *trafficBroadcaster) active() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs) > 0
+}
+
+func (b *trafficBroadcaster) publish(rec TrafficRecord) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- rec:
+		default:
+		}
+	}
+}
+
+// ======================================================================
+// HTTP 服务
+// ======================================================================
+
+// startWebDashboard 启动 Web 仪表盘，返回的 stop 用于关闭服务
+func startWebDashboard(addr string) (stop func(), err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: newWebMux()}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Web 仪表盘异常退出: %v", err)
+		}
+	}()
+	log.Printf("🌐 Web 仪表盘: http://%s/", ln.Addr())
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}, nil
+}
+
+func newWebMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, webDashboardHTML)
+	})
+	mux.HandleFunc("GET /api/devices", webDevices)
+	mux.HandleFunc("GET /api/thingmodel", webThingModel)
+	mux.HandleFunc("POST /api/devices/{name}/properties", webSetProperties)
+	mux.HandleFunc("POST /api/devices/{name}/events/{event}", webPostEvent)
+	mux.HandleFunc("GET /ws", webSocket)
+	return mux
+}
+
+// webDevice /api/devices 中的一台设备
+type webDevice struct {
+	Name        string                 `json:"name"`
+	State       ConnState              `json:"state"`
+	EventFormat string                 `json:"event_format"`
+	Properties  map[string]interface{} `json:"properties"`
+	Up          int64                  `json:"up"`
+	Down        int64                  `json:"down"`
+	LastPost    *time.Time             `json:"last_post,omitempty"`
+	ReplyCode   int                    `json:"last_reply_code,omitempty"`
+	ReasonCodes map[string]int64       `json:"reason_codes,omitempty"`
+	Throttled   int64                  `json:"throttled,omitempty"`
+	RateDropped int64                  `json:"rate_dropped,omitempty"`
+	Errors      []webError             `json:"errors,omitempty"`
+}
+
+type webError struct {
+	Time time.Time `json:"time"`
+	Msg  string    `json:"msg"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func webDevices(w http.ResponseWriter, r *http.Request) {
+	var out []webDevice
+	for _, d := range registeredDevices() {
+		st := d.Stats.Snapshot()
+		props := d.propertySnapshot()
+		wd := webDevice{
+			Name:        d.Name,
+			State:       d.State(),
+			EventFormat: d.eventFormat().String(),
+			Properties:  props.propertyMap(thingPropertyIDs),
+			Up:          st.Up,
+			Down:        st.Down,
+			ReplyCode:   st.LastReplyCode,
+			ReasonCodes: st.ReasonCodes,
+			Throttled:   st.Throttled,
+			RateDropped: st.RateDropped,
+		}
+		if !st.LastPost.IsZero() {
+			wd.LastPost = &st.LastPost
+		}
+		for _, e := range st.Errors {
+			wd.Errors = append(wd.Errors, webError{e.Time, e.Msg})
+		}
+		out = append(out, wd)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func webThingModel(w http.ResponseWriter, r *http.Request) {
+	type event struct {
+		ID     string   `json:"id"`
+		Params []string `json:"params"`
+	}
+	var events []event
+	for _, e := range thingModelSpec.Events {
+		ev := event{ID: e.Identifier}
+		for _, f := range e.OutputData {
+			ev.Params = append(ev.Params, f.Identifier)
+		}
+		events = append(events, ev)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"writable": writablePropertyIDs,
+		"events":   events,
+	})
+}
+
+// webLookup 解析路径中的设备名
+func webLookup(w http.ResponseWriter, r *http.Request) (*Device, bool) {
+	d, ok := lookupDevice(r.PathValue("name"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("unknown device: %s", r.PathValue("name")))
+	}
+	return d, ok
+}
+
+// readParams 读取可选的 JSON 对象请求体
+func readParams(r *http.Request) (map[string]interface{}, error) {
+	params := make(map[string]interface{})
+	err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("decode body: %w", err)
+	}
+	return params, nil
+}
+
+func webSetProperties(w http.ResponseWriter, r *http.Request) {
+	d, ok := webLookup(w, r)
+	if !ok {
+		return
+	}
+	params, err := readParams(r)
+	if err == nil && len(params) == 0 {
+		err = errors.New("no properties given")
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	log.Printf("[%s] 🌐 Web 设置属性: %v", d.Name, params)
+	if err := d.applyProperties(params); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	// 与平台 property/set 一致：设置成功后立即上报最新状态
+	if d.State() == StateOnline && !ThingModelOff {
+		d.postDeviceProperty(false)
+	}
+	props := d.propertySnapshot()
+	writeJSON(w, http.StatusOK, props.propertyMap(thingPropertyIDs))
+}
+
+func webPostEvent(w http.ResponseWriter, r *http.Request) {
+	d, ok := webLookup(w, r)
+	if !ok {
+		return
+	}
+	params, err := readParams(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	event := r.PathValue("event")
+	if _, ok := thingModelSpec.Event(event); !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("unknown event: %s", event))
+		return
+	}
+	if d.State() != StateOnline {
+		writeJSONError(w, http.StatusConflict, fmt.Errorf("device %s is %s", d.Name, d.State()))
+		return
+	}
+	log.Printf("[%s] 🌐 Web 触发事件: %s %v", d.Name, event, params)
+	if len(params) == 0 {
+		d.postDeviceEvent(event)
+	} else if err := d.postEvent(event, params); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "posted"})
+}
+
+// ======================================================================
+// WebSocket 实时推送
+// ======================================================================
+
+// wsMessage 推送给页面的一条消息: type 为 traffic 或 state
+type wsMessage struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+type wsStateEvent struct {
+	Device  string    `json:"device"`
+	From    ConnState `json:"from"`
+	To      ConnState `json:"to"`
+	Time    time.Time `json:"time"`
+	Attempt int       `json:"attempt,omitempty"`
+	Err     string    `json:"error,omitempty"`
+}
+
+var wsUpgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 4096}
+
+func webSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade 已回复错误
+	}
+	defer conn.Close()
+
+	traffic, cancelTraffic := SubscribeTraffic(256)
+	defer cancelTraffic()
+	states, cancelStates := SubscribeStateEvents(64)
+	defer cancelStates()
+
+	// 页面不发送消息，读循环只用于感知连接关闭
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+	for {
+		var msg wsMessage
+		select {
+		case <-closed:
+			return
+		case rec := <-traffic:
+			msg = wsMessage{"traffic", rec}
+		case ev := <-states:
+			se := wsStateEvent{Device: ev.Device, From: ev.From, To: ev.To, Time: ev.Time, Attempt: ev.Attempt}
+			if ev.Err != nil {
+				se.Err = ev.Err.Error()
+			}
+			msg = wsMessage{"state", se}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+			continue
+		}
+		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+// webDashboardHTML 单页面：设备表格定时轮询 /api/devices，消息流经由 /ws 推送
+const webDashboardHTML = `<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>QSIoT 设备仪表盘</title>
+<style>
+body { font: 13px/1.5 -apple-system, "PingFang SC", "Microsoft YaHei", sans-serif; margin: 16px; color: #222; }
+h1 { font-size: 18px; margin: 0 0 12px; }
+h2 { font-size: 15px; margin: 18px 0 6px; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #e3e3e3; padding: 4px 6px; text-align: left; vertical-align: top; }
+th { background: #f6f6f6; }
+.online { color: #1a7f37; font-weight: bold; }
+.connecting, .offline { color: #9a6700; }
+.failed { color: #cf222e; font-weight: bold; }
+.stopped { color: #888; }
+.props { font-family: monospace; white-space: pre; }
+.err { color: #cf222e; }
+#feed { font-family: monospace; font-size: 12px; height: 360px; overflow-y: auto; border: 1px solid #ddd; padding: 4px; }
+#feed div { white-space: pre-wrap; word-break: break-all; border-bottom: 1px dotted #eee; }
+.up { color: #0550ae; } .down { color: #8250df; } .state { color: #9a6700; }
+input, select, button { font-size: 12px; }
+</style>
+</head>
+<body>
+<h1>QSIoT 设备仪表盘 <small id="status"></small></h1>
+<table>
+<thead><tr><th>设备</th><th>状态</th><th>属性</th><th>上行/下行</th><th>最近上报</th><th>回复</th><th>操作</th></tr></thead>
+<tbody id="devices"></tbody>
+</table>
+<h2>实时消息 <label><input type="checkbox" id="pause"> 暂停</label>
+ <input id="filter" placeholder="按设备或 Topic 过滤"></h2>
+<div id="feed"></div>
+<script>
+let model = {writable: [], events: []};
+const $ = id => document.getElementById(id);
+const esc = s => String(s).replace(/[&<>"]/g, c => ({'&':'&amp;','<':'&lt;','>':'&gt;','"':'&quot;'}[c]));
+
+async function call(method, url, body) {
+  const r = await fetch(url, {method, headers: {'Content-Type': 'application/json'}, body: body && JSON.stringify(body)});
+  const j = await r.json();
+  if (!r.ok) alert(j.error || r.statusText);
+  return j;
+}
+
+function parseValue(s) { try { return JSON.parse(s); } catch (e) { return s; } }
+
+async function setProp(dev) {
+  const id = $('prop-' + dev).value, v = $('val-' + dev).value;
+  await call('POST', '/api/devices/' + dev + '/properties', {[id]: parseValue(v)});
+  refresh();
+}
+
+async function postEvent(dev) {
+  const ev = $('ev-' + dev).value, params = {};
+  for (const kv of $('evp-' + dev).value.split(/\s+/).filter(Boolean)) {
+    const i = kv.indexOf('=');
+    if (i > 0) params[kv.slice(0, i)] = parseValue(kv.slice(i + 1));
+  }
+  await call('POST', '/api/devices/' + dev + '/events/' + ev, params);
+}
+
+function controls(d) {
+  const n = esc(d.name);
+  const props = model.writable.map(p => '<option>' + esc(p) + '</option>').join('');
+  const events = model.events.map(e => '<option title="' + esc((e.params || []).join(', ')) + '">' + esc(e.id) + '</option>').join('');
+  return '<select id="prop-' + n + '">' + props + '</select> <input id="val-' + n + '" size="4"> ' +
+    '<button onclick="setProp(\'' + n + '\')">设置</button><br>' +
+    '<select id="ev-' + n + '">' + events + '</select> <input id="evp-' + n + '" size="14" placeholder="param=value ..."> ' +
+    '<button onclick="postEvent(\'' + n + '\')">触发事件</button>';
+}
+
+async function refresh() {
+  const devs = await (await fetch('/api/devices')).json() || [];
+  const tbody = $('devices');
+  for (const d of devs) {
+    let tr = $('row-' + d.name);
+    if (!tr) {
+      tr = tbody.insertRow();
+      tr.id = 'row-' + d.name;
+      for (let i = 0; i < 7; i++) tr.insertCell();
+      tr.cells[0].textContent = d.name;
+      tr.cells[6].innerHTML = controls(d);
+    }
+    tr.cells[1].innerHTML = '<span class="' + d.state + '">' + d.state + '</span>';
+    tr.cells[2].innerHTML = '<div class="props">' + esc(Object.entries(d.properties).map(([k, v]) => k + ' = ' + JSON.stringify(v)).join('\n')) + '</div>' +
+      (d.errors || []).slice(-3).map(e => '<div class="err">' + esc(e.msg) + '</div>').join('');
+    tr.cells[3].textContent = d.up + ' / ' + d.down;
+    tr.cells[4].textContent = d.last_post ? new Date(d.last_post).toLocaleTimeString() : '-';
+    tr.cells[5].textContent = d.last_reply_code || '-';
+  }
+}
+
+function addFeed(cls, text, key) {
+  if ($('pause').checked) return;
+  const f = $('filter').value;
+  if (f && !key.includes(f)) return;
+  const feed = $('feed'), atBottom = feed.scrollTop + feed.clientHeight >= feed.scrollHeight - 4;
+  const div = document.createElement('div');
+  div.className = cls;
+  div.textContent = text;
+  feed.appendChild(div);
+  while (feed.childNodes.length > 500) feed.removeChild(feed.firstChild);
+  if (atBottom) feed.scrollTop = feed.scrollHeight;
+}
+
+function connect() {
+  const ws = new WebSocket((location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + '/ws');
+  ws.onopen = () => { $('status').textContent = '● 已连接'; };
+  ws.onclose = () => { $('status').textContent = '○ 连接断开，重连中...'; setTimeout(connect, 2000); };
+  ws.onmessage = e => {
+    const m = JSON.parse(e.data), d = m.data, t = new Date(d.time).toLocaleTimeString();
+    if (m.type === 'traffic') {
+      addFeed(d.dir, t + ' ' + (d.dir === 'up' ? '⬆' : '⬇') + ' [' + d.device + '] ' + d.topic + '\n  ' + d.payload, d.device + ' ' + d.topic);
+    } else {
+      addFeed('state', t + ' 🔁 [' + d.device + '] ' + d.from + ' -> ' + d.to + (d.error ? ' (' + d.error + ')' : ''), d.device);
+      refresh();
+    }
+  };
+}
+
+(async () => {
+  model = await (await fetch('/api/thingmodel')).json();
+  model.events = model.events || [];
+  await refresh();
+  setInterval(refresh, 2000);
+  connect();
+})();
+</script>
+</body>
+</html>
+`